@@ -0,0 +1,262 @@
+package handles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+const personalAccessTokenPrefix = "pat_"
+
+const (
+	scopeProfileWrite = "profile:write"
+	scopeAccountRead  = "account:read"
+	scopeAdmin        = "admin"
+)
+
+// Routes (as registered in gin) that accept personal access tokens, with the scope each of them requires.
+// Other routes, including management of credentials and tokens themselves, need interactive sign in.
+var personalAccessTokenRoutes = map[string]string{
+	"/profiles":                          scopeAccountRead,
+	"/users/batch":                       scopeAccountRead,
+	"/users/search":                      scopeAccountRead,
+	"/profiles/update":                   scopeProfileWrite,
+	"/me/profile":                        scopeProfileWrite,
+	"/me/avatar":                         scopeProfileWrite,
+	"/avatars/:user_id/:avatar_id/:size": scopeAccountRead,
+	"/me/export":                         scopeAccountRead,
+	"/me/sessions":                       scopeAccountRead,
+	"/profiles/:user_id/history":         scopeAccountRead,
+	"/roles":                             scopeAdmin,
+	"/roles/assign":                      scopeAdmin,
+	"/roles/revoke":                      scopeAdmin,
+	"/admin/users":                       scopeAdmin,
+	"/admin/users/:user_id/suspend":      scopeAdmin,
+	"/admin/users/:user_id/reinstate":    scopeAdmin,
+}
+
+// authenticatePersonalAccessToken verifies "Authorization: Bearer pat_..." header with user service
+// and checks token's scopes against the route. On failure the request is aborted and nil is returned.
+func (h *HandleContext) authenticatePersonalAccessToken(ctx *gin.Context, route string, authorization string) *JwtClaims {
+	secret, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || !strings.HasPrefix(secret, personalAccessTokenPrefix) {
+		ctx.AbortWithStatusJSON(401, map[string]any{"error": fmt.Sprintf("%v: authorization header must carry bearer personal access token", route)})
+		return nil
+	}
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), time.Second*10)
+	defer cancel()
+
+	response, err := h.UserserviceClient.VerifyPersonalAccessToken(c, &userservice.VerifyPersonalAccessTokenRequest{
+		Secret: secret,
+	})
+	if err != nil {
+		st, ok := status.FromError(err)
+		if !ok {
+			log.Printf("%v: grpc err: %v\n", route, err)
+			ctx.AbortWithStatus(500)
+			return nil
+		}
+		switch st.Code() {
+		case codes.Unauthenticated:
+			ctx.AbortWithStatusJSON(401, map[string]any{"error": fmt.Sprintf("%v: personal access token verification failed: %v", route, st.Err().Error())})
+		case codes.PermissionDenied:
+			ctx.AbortWithStatusJSON(403, map[string]any{"error": fmt.Sprintf("%v: %v", route, st.Err().Error())})
+		case codes.Internal:
+			log.Printf("%v: internal error: %v\n", route, st.Err().Error())
+			ctx.AbortWithStatus(500)
+		default:
+			log.Printf("%v: non recognized status: %v (code: %v)\n", route, st.Err().Error(), st.Code())
+			ctx.AbortWithStatus(500)
+		}
+		return nil
+	}
+
+	scope, ok := personalAccessTokenRoutes[route]
+	if !ok {
+		ctx.AbortWithStatusJSON(403, map[string]any{"error": fmt.Sprintf("%v: route isn't available for personal access tokens", route)})
+		return nil
+	}
+	if !slices.Contains(response.Scopes, scope) {
+		ctx.AbortWithStatusJSON(403, map[string]any{"error": fmt.Sprintf("%v: personal access token lacks %v scope", route, scope)})
+		return nil
+	}
+
+	userId, err := uuid.Parse(response.Id.Uuid)
+	if err != nil {
+		log.Printf("%v: user service returned invalid user id: %v\n", route, err)
+		ctx.AbortWithStatus(500)
+		return nil
+	}
+
+	return &JwtClaims{
+		UserId:              userId,
+		TokenId:             response.TokenId,
+		EmailVerified:       response.EmailVerified,
+		Roles:               response.Roles,
+		Scopes:              response.Scopes,
+		PersonalAccessToken: true,
+	}
+}
+
+func personalAccessTokenToJson(token *userservice.PersonalAccessToken) map[string]any {
+	result := map[string]any{
+		"token_id":        token.TokenId,
+		"name":            token.Name,
+		"scopes":          token.Scopes,
+		"creation_time":   token.CreationTime.AsTime(),
+		"expiration_time": token.ExpirationTime.AsTime(),
+	}
+	if token.LastUseTime != nil {
+		result["last_use_time"] = token.LastUseTime.AsTime()
+	}
+	return result
+}
+
+func handleCreatePersonalAccessToken(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Name           string     `json:"name"`
+		Scopes         []string   `json:"scopes"`
+		ExpirationTime *time.Time `json:"expiration_time"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/tokens: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		var expirationTime *timestamppb.Timestamp
+		if request.ExpirationTime != nil {
+			expirationTime = timestamppb.New(*request.ExpirationTime)
+		}
+
+		response, err := h.UserserviceClient.CreatePersonalAccessToken(c, &userservice.CreatePersonalAccessTokenRequest{
+			Id:             &shared.Id{Uuid: claims.UserId.String()},
+			Name:           request.Name,
+			Scopes:         request.Scopes,
+			ExpirationTime: expirationTime,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/tokens: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/tokens: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/tokens: %v", st.Err().Error())})
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/me/tokens: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/me/tokens: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/tokens: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		result := personalAccessTokenToJson(response.Token)
+		result["token"] = response.Secret
+		ctx.Header("Cache-Control", "no-store")
+		ctx.JSON(200, result)
+	}
+}
+
+func handleListPersonalAccessTokens(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.ListPersonalAccessTokens(c, &userservice.ListPersonalAccessTokensRequest{
+			Id: &shared.Id{Uuid: claims.UserId.String()},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/tokens: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.Internal:
+				log.Printf("/me/tokens: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/tokens: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		tokens := make([]map[string]any, 0, len(response.Tokens))
+		for _, token := range response.Tokens {
+			tokens = append(tokens, personalAccessTokenToJson(token))
+		}
+
+		ctx.JSON(200, map[string]any{"tokens": tokens})
+	}
+}
+
+func handleRevokePersonalAccessToken(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		_, err := h.UserserviceClient.RevokePersonalAccessToken(c, &userservice.RevokePersonalAccessTokenRequest{
+			Id:      &shared.Id{Uuid: claims.UserId.String()},
+			TokenId: ctx.Param("token_id"),
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/tokens/:token_id: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/tokens/:token_id: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/tokens/:token_id: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/me/tokens/:token_id: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/tokens/:token_id: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(200)
+	}
+}
@@ -0,0 +1,186 @@
+package handles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+func userSummaryToJson(user *userservice.UserSummary) map[string]any {
+	result := map[string]any{
+		"user_id":        user.Id.Uuid,
+		"login":          user.Login,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"suspended":      user.Suspended,
+	}
+	if user.SuspendedTime != nil {
+		result["suspended_time"] = user.SuspendedTime.AsTime()
+		result["suspension_reason"] = user.SuspensionReason
+	}
+	if user.SuspendedUntil != nil {
+		result["suspended_until"] = user.SuspendedUntil.AsTime()
+	}
+	return result
+}
+
+func handleListUsers(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		request := &userservice.ListUsersRequest{
+			Query:         ctx.Query("query"),
+			Role:          ctx.Query("role"),
+			SuspendedOnly: ctx.Query("suspended") == "true",
+			PageToken:     ctx.Query("page_token"),
+		}
+		if pageSize := ctx.Query("page_size"); pageSize != "" {
+			size, err := strconv.Atoi(pageSize)
+			if err != nil || size <= 0 {
+				ctx.JSON(400, map[string]any{"error": "/admin/users: page_size must be a positive integer"})
+				return
+			}
+			request.PageSize = int32(min(size, 1<<30))
+		}
+
+		response, err := h.UserserviceClient.ListUsers(c, request)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/admin/users: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/admin/users: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/admin/users: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/admin/users: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		users := make([]map[string]any, 0, len(response.Users))
+		for _, user := range response.Users {
+			users = append(users, userSummaryToJson(user))
+		}
+
+		ctx.JSON(200, map[string]any{"users": users, "next_page_token": response.NextPageToken})
+	}
+}
+
+func handleSuspendUser(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		// Body is optional: without it user is suspended indefinitely with no reason given
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/admin/users/suspend: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		userId := ctx.Param("user_id")
+		if userId == getJwtClaims(ctx).UserId.String() {
+			ctx.JSON(409, map[string]any{"error": "/admin/users/suspend: administrator can't suspend themselves"})
+			return
+		}
+
+		grpcRequest := &userservice.SuspendUserRequest{
+			Id:     &shared.Id{Uuid: userId},
+			Reason: request.Reason,
+		}
+		if request.Until != nil {
+			grpcRequest.Until = timestamppb.New(*request.Until)
+		}
+
+		_, err = h.UserserviceClient.SuspendUser(c, grpcRequest)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/admin/users/suspend: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/admin/users/suspend: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/admin/users/suspend: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/admin/users/suspend: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/admin/users/suspend: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		// Sessions of the suspended user were revoked, pick it up without waiting for the periodic sync
+		err = h.Revocations.Sync(c, h.UserserviceClient)
+		if err != nil {
+			log.Printf("/admin/users/suspend: failed to sync revocations: %v\n", err)
+		}
+
+		ctx.Status(200)
+	}
+}
+
+func handleReinstateUser(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		_, err := h.UserserviceClient.ReinstateUser(c, &userservice.ReinstateUserRequest{
+			Id: &shared.Id{Uuid: ctx.Param("user_id")},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/admin/users/reinstate: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/admin/users/reinstate: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/admin/users/reinstate: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/admin/users/reinstate: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/admin/users/reinstate: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(200)
+	}
+}
@@ -0,0 +1,204 @@
+package handles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+const (
+	// User service accepts images up to 3MB, the rest is left for multipart framing
+	maxAvatarUploadBytes = 3<<20 + 64<<10
+	// Avatar URLs change along with the image, but visibility of the avatar may be narrowed
+	// at any time, so shared caches keep public avatars only for a while
+	publicAvatarCacheControl = "public, max-age=3600"
+	// Avatars hidden from someone are kept by the viewer's browser only and revalidated
+	privateAvatarCacheControl = "private, no-cache"
+)
+
+type Avatar struct {
+	Size int    `json:"size"`
+	Url  string `json:"url"`
+}
+
+func AvatarsPbToStruct(avatars []*shared.Avatar) []Avatar {
+	result := make([]Avatar, 0, len(avatars))
+	for _, avatar := range avatars {
+		result = append(result, Avatar{Size: int(avatar.Size), Url: avatar.Url})
+	}
+	return result
+}
+
+func handleUploadAvatar(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxAvatarUploadBytes)
+		file, err := ctx.FormFile("avatar")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				ctx.JSON(413, map[string]any{"error": fmt.Sprintf("/me/avatar: request is larger than %v bytes", maxAvatarUploadBytes)})
+			} else {
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/avatar: couldn't read avatar form field: %v", err)})
+			}
+			return
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/avatar: couldn't open uploaded file: %v", err)})
+			return
+		}
+		defer reader.Close()
+		image, err := io.ReadAll(reader)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/avatar: couldn't read uploaded file: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.UploadAvatar(withActorMetadata(c, ctx), &userservice.UploadAvatarRequest{
+			Id:    &shared.Id{Uuid: claims.UserId.String()},
+			Image: image,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/avatar: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/avatar: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/avatar: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/me/avatar: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/avatar: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"avatars": AvatarsPbToStruct(response.Avatars)})
+	}
+}
+
+func handleDeleteAvatar(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		_, err := h.UserserviceClient.DeleteAvatar(withActorMetadata(c, ctx), &userservice.DeleteAvatarRequest{
+			Id: &shared.Id{Uuid: claims.UserId.String()},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/avatar: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/avatar: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/me/avatar: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/avatar: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(200)
+	}
+}
+
+func handleGetAvatar(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		userId, err := uuid.Parse(ctx.Param("user_id"))
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/avatars: couldn't retrieve id: %v", err)})
+			return
+		}
+		size, err := strconv.Atoi(ctx.Param("size"))
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/avatars: size must be an integer: %v", err)})
+			return
+		}
+
+		avatarRequest := &userservice.GetAvatarRequest{
+			Id:       &shared.Id{Uuid: userId.String()},
+			AvatarId: ctx.Param("avatar_id"),
+			Size:     int32(size),
+		}
+		// Avatar hidden from the viewer is reported as absent by user service
+		if claims := getOptionalJwtClaims(ctx); claims != nil {
+			avatarRequest.ViewerId = &shared.Id{Uuid: claims.UserId.String()}
+		}
+
+		response, err := h.UserserviceClient.GetAvatar(c, avatarRequest)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/avatars: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/avatars: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/avatars: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/avatars: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/avatars: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		etag := fmt.Sprintf(`"%v-%d"`, ctx.Param("avatar_id"), size)
+		if response.Public {
+			ctx.Header("Cache-Control", publicAvatarCacheControl)
+		} else {
+			ctx.Header("Cache-Control", privateAvatarCacheControl)
+		}
+		ctx.Header("ETag", etag)
+		ctx.Header("X-Content-Type-Options", "nosniff")
+		if ctx.GetHeader("If-None-Match") == etag {
+			ctx.Status(304)
+			return
+		}
+
+		ctx.Data(200, response.ContentType, response.Image)
+	}
+}
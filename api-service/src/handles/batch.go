@@ -0,0 +1,115 @@
+package handles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+// Ids accepted by one /users/batch request, as limited by user service
+const maxBatchSize = 100
+
+// batchToJson merges users with their profiles in the order of users. Users without
+// profile get none, users missing in the first place are listed separately.
+func batchToJson(users *userservice.BatchGetUsersResponse, profiles *userservice.BatchGetProfilesResponse) map[string]any {
+	profileById := make(map[string]*shared.Profile, len(profiles.Profiles))
+	for _, profile := range profiles.Profiles {
+		profileById[profile.Id.GetUuid()] = profile.Profile
+	}
+
+	result := make([]map[string]any, 0, len(users.Users))
+	for _, user := range users.Users {
+		item := map[string]any{"user_id": user.Id.GetUuid(), "login": user.Login}
+		if profile, ok := profileById[user.Id.GetUuid()]; ok && profile != nil {
+			item["profile"] = ProfilePbToStruct(profile)
+		}
+		result = append(result, item)
+	}
+
+	missing := make([]string, 0, len(users.MissingIds))
+	for _, id := range users.MissingIds {
+		missing = append(missing, id.GetUuid())
+	}
+
+	return map[string]any{"users": result, "missing_user_ids": missing}
+}
+
+func handleBatchGetUsers(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Ids []string `json:"user_ids"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/users/batch: couldn't bind input to json: %v", err)})
+			return
+		}
+		if len(request.Ids) > maxBatchSize {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/users/batch: at most %v ids may be requested at once", maxBatchSize)})
+			return
+		}
+
+		ids := make([]*shared.Id, 0, len(request.Ids))
+		for _, id := range request.Ids {
+			userId, err := uuid.Parse(id)
+			if err != nil {
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/users/batch: couldn't retrieve id %q: %v", id, err)})
+				return
+			}
+			ids = append(ids, &shared.Id{Uuid: userId.String()})
+		}
+
+		users, err := h.UserserviceClient.BatchGetUsers(c, &userservice.BatchGetUsersRequest{Ids: ids})
+		if err != nil {
+			respondBatchError(ctx, err)
+			return
+		}
+
+		profilesRequest := &userservice.BatchGetProfilesRequest{Ids: ids}
+		// Fields hidden from the viewer are left empty by user service
+		if claims := getOptionalJwtClaims(ctx); claims != nil {
+			profilesRequest.ViewerId = &shared.Id{Uuid: claims.UserId.String()}
+		}
+
+		profiles, err := h.UserserviceClient.BatchGetProfiles(c, profilesRequest)
+		if err != nil {
+			respondBatchError(ctx, err)
+			return
+		}
+
+		ctx.JSON(200, batchToJson(users, profiles))
+	}
+}
+
+func respondBatchError(ctx *gin.Context, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		log.Printf("/users/batch: grpc err: %v\n", err)
+		ctx.Status(500)
+		return
+	}
+	switch st.Code() {
+	case codes.InvalidArgument:
+		ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/users/batch: %v", st.Err().Error())})
+	case codes.Internal:
+		log.Printf("/users/batch: internal error: %v\n", st.Err().Error())
+		ctx.Status(500)
+	default:
+		log.Printf("/users/batch: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+		ctx.Status(500)
+	}
+}
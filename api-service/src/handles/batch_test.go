@@ -0,0 +1,40 @@
+package handles
+
+import (
+	"testing"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+func TestBatchToJson(t *testing.T) {
+	users := &userservice.BatchGetUsersResponse{
+		Users: []*userservice.BatchUser{
+			{Id: &shared.Id{Uuid: "b"}, Login: "bob", Email: "bob@example.com"},
+			{Id: &shared.Id{Uuid: "a"}, Login: "alice", Email: "alice@example.com"},
+		},
+		MissingIds: []*shared.Id{{Uuid: "c"}},
+	}
+	profiles := &userservice.BatchGetProfilesResponse{
+		Profiles:   []*userservice.BatchProfile{{Id: &shared.Id{Uuid: "a"}, Profile: &shared.Profile{Name: "Alice"}}},
+		MissingIds: []*shared.Id{{Uuid: "b"}, {Uuid: "c"}},
+	}
+
+	result := batchToJson(users, profiles)
+	items := result["users"].([]map[string]any)
+	if len(items) != 2 || items[0]["login"] != "bob" || items[1]["login"] != "alice" {
+		t.Fatalf("batchToJson returned users %v, where bob and alice expected", items)
+	}
+	if _, ok := items[0]["profile"]; ok {
+		t.Errorf("batchToJson returned profile %v for user without one", items[0]["profile"])
+	}
+	if profile, ok := items[1]["profile"].(Profile); !ok || profile.Name != "Alice" {
+		t.Errorf("batchToJson returned profile %v, where profile of alice expected", items[1]["profile"])
+	}
+	if _, ok := items[0]["email"]; ok {
+		t.Errorf("batchToJson exposed email of %v", items[0]["login"])
+	}
+	if missing := result["missing_user_ids"].([]string); len(missing) != 1 || missing[0] != "c" {
+		t.Errorf("batchToJson returned missing ids %v, where [c] expected", missing)
+	}
+}
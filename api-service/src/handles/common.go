@@ -1,14 +1,19 @@
 package handles
 
 import (
-	"crypto/rsa"
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	shared "soa-project/shared/proto"
@@ -30,12 +35,85 @@ func UserPbToStruct(u *shared.User) User {
 }
 
 type Profile struct {
-	Name           string    `json:"name,omitempty"`
-	Surname        string    `json:"surname,omitempty"`
-	PhoneNumber    string    `json:"phone_number,omitempty"`
-	Birthday       string    `json:"birthday,omitempty"`
-	CreationTime   time.Time `json:"creation_time"`
-	LastUpdateTime time.Time `json:"last_update_time"`
+	Name        string `json:"name,omitempty"`
+	Surname     string `json:"surname,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Birthday    string `json:"birthday,omitempty"`
+	// Read only, changed at /me/avatar
+	Avatars []Avatar `json:"avatars,omitempty"`
+	// Returned only to the owner, fields hidden from the viewer are omitted
+	Visibility     *ProfileVisibility `json:"visibility,omitempty"`
+	CreationTime   time.Time          `json:"creation_time"`
+	LastUpdateTime time.Time          `json:"last_update_time"`
+}
+
+// ProfileVisibility tells who sees each field of the profile: "public", "followers" or "only_me".
+// Empty value stands for the default of the field.
+type ProfileVisibility struct {
+	Name        string `json:"name,omitempty"`
+	Surname     string `json:"surname,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Birthday    string `json:"birthday,omitempty"`
+	Avatars     string `json:"avatars,omitempty"`
+}
+
+var visibilitiesToPb = map[string]shared.Visibility{
+	"":          shared.Visibility_VISIBILITY_UNSPECIFIED,
+	"public":    shared.Visibility_VISIBILITY_PUBLIC,
+	"followers": shared.Visibility_VISIBILITY_FOLLOWERS,
+	"only_me":   shared.Visibility_VISIBILITY_ONLY_ME,
+}
+
+func visibilityPbToString(v shared.Visibility) string {
+	for result, visibility := range visibilitiesToPb {
+		if visibility == v {
+			return result
+		}
+	}
+	return ""
+}
+
+func ProfileVisibilityPbToStruct(v *shared.ProfileVisibility) *ProfileVisibility {
+	if v == nil {
+		return nil
+	}
+	return &ProfileVisibility{
+		Name:        visibilityPbToString(v.Name),
+		Surname:     visibilityPbToString(v.Surname),
+		PhoneNumber: visibilityPbToString(v.PhoneNumber),
+		Birthday:    visibilityPbToString(v.Birthday),
+		Avatars:     visibilityPbToString(v.Avatars),
+	}
+}
+
+func ProfileVisibilityStructToPb(v *ProfileVisibility) (*shared.ProfileVisibility, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	result := &shared.ProfileVisibility{}
+	fields := []struct {
+		name   string
+		value  string
+		target *shared.Visibility
+	}{
+		{"name", v.Name, &result.Name},
+		{"surname", v.Surname, &result.Surname},
+		{"phone_number", v.PhoneNumber, &result.PhoneNumber},
+		{"birthday", v.Birthday, &result.Birthday},
+		{"avatars", v.Avatars, &result.Avatars},
+	}
+	for _, field := range fields {
+		visibility, ok := visibilitiesToPb[field.value]
+		if !ok {
+			return nil, &fieldError{
+				field: "visibility." + field.name,
+				err:   fmt.Errorf("unknown visibility %q, expected public, followers or only_me", field.value),
+			}
+		}
+		*field.target = visibility
+	}
+	return result, nil
 }
 
 func ProfilePbToStruct(p *shared.Profile) Profile {
@@ -50,6 +128,8 @@ func ProfilePbToStruct(p *shared.Profile) Profile {
 		Surname:        p.Surname,
 		PhoneNumber:    p.PhoneNumber,
 		Birthday:       birthday,
+		Avatars:        AvatarsPbToStruct(p.Avatars),
+		Visibility:     ProfileVisibilityPbToStruct(p.Visibility),
 		CreationTime:   p.CreationTime.AsTime(),
 		LastUpdateTime: p.LastUpdateTime.AsTime(),
 	}
@@ -60,16 +140,21 @@ func ProfileStructToPb(p Profile) (*shared.Profile, error) {
 	if p.Birthday != "" {
 		parsedDate, err := time.Parse("2006-01-02", p.Birthday)
 		if err != nil {
-			return nil, fmt.Errorf("failed parsing date: %v", err)
+			return nil, &fieldError{field: "birthday", err: fmt.Errorf("failed parsing date: %v", err)}
 		}
 		year, month, day := parsedDate.Date()
 		birthday = &shared.Date{Year: int32(year), Month: int32(month), Day: int32(day)}
 	}
+	visibility, err := ProfileVisibilityStructToPb(p.Visibility)
+	if err != nil {
+		return nil, err
+	}
 	return &shared.Profile{
 		Name:           p.Name,
 		Surname:        p.Surname,
 		PhoneNumber:    p.PhoneNumber,
 		Birthday:       birthday,
+		Visibility:     visibility,
 		CreationTime:   timestamppb.New(p.CreationTime),
 		LastUpdateTime: timestamppb.New(p.LastUpdateTime),
 	}, nil
@@ -77,16 +162,41 @@ func ProfileStructToPb(p Profile) (*shared.Profile, error) {
 
 type HandleContext struct {
 	UserserviceClient userservice.UserServiceClient
-	JwtPublic         *rsa.PublicKey
+	Keys              *KeySet
+	Revocations       *RevocationCache
+	// Routes (as registered in gin) that are refused until user verifies email
+	VerifiedEmailRoutes map[string]bool
 }
 
 type JwtClaims struct {
-	UserId uuid.UUID
+	UserId        uuid.UUID
+	TokenId       string
+	SessionId     string
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+	EmailVerified bool
+	Roles         []string
+	// Set only for requests authenticated with personal access token
+	Scopes              []string
+	PersonalAccessToken bool
 }
 
-func (h *HandleContext) parseAndVerifyJwtToken(jwtToken string) (*JwtClaims, error) {
+func (c *JwtClaims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HandleContext) parseAndVerifyJwtToken(ctx context.Context, jwtToken string) (*JwtClaims, error) {
 	type Claims struct {
-		UserId string `json:"user_id"`
+		UserId        string   `json:"user_id"`
+		EmailVerified bool     `json:"email_verified"`
+		Roles         []string `json:"roles"`
+		Purpose       string   `json:"purpose"`
+		SessionId     string   `json:"sid"`
 		jwt.RegisteredClaims
 	}
 
@@ -94,7 +204,15 @@ func (h *HandleContext) parseAndVerifyJwtToken(jwtToken string) (*JwtClaims, err
 		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("parse jwt token: unexpected signing method: %v", t.Header["alg"])
 		}
-		return h.JwtPublic, nil
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("parse jwt token: token has no kid")
+		}
+		public, ok := h.Keys.Lookup(ctx, kid)
+		if !ok {
+			return nil, fmt.Errorf("parse jwt token: unknown kid: %v", kid)
+		}
+		return public, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("parse jwt token: failed to parse: %w", err)
@@ -116,7 +234,200 @@ func (h *HandleContext) parseAndVerifyJwtToken(jwtToken string) (*JwtClaims, err
 		return nil, fmt.Errorf("provided jwt token expired")
 	}
 
-	return &JwtClaims{UserId: uuid}, nil
+	if claims.ID == "" {
+		return nil, errors.New("parse jwt token: token has no jti")
+	}
+
+	if claims.Purpose != "" {
+		return nil, errors.New("parse jwt token: action token can't be used for authentication")
+	}
+
+	result := &JwtClaims{
+		UserId:        uuid,
+		TokenId:       claims.ID,
+		SessionId:     claims.SessionId,
+		ExpiresAt:     claims.ExpiresAt.Time,
+		EmailVerified: claims.EmailVerified,
+		Roles:         claims.Roles,
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Time
+	}
+
+	if h.Revocations != nil && h.Revocations.IsRevoked(result) {
+		return nil, errors.New("provided jwt token was revoked")
+	}
+
+	return result, nil
 }
 
 type HandlerFunc func(*gin.Context)
+
+const (
+	jwtKey       = "jwt"
+	jwtClaimsKey = "jwtClaims"
+)
+
+// authenticated rejects requests without valid jwt cookie or personal access token allowed for the route.
+// Verified claims are available to the following handlers through getJwtClaims.
+func (h *HandleContext) authenticated() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		route := ctx.FullPath()
+
+		var claims *JwtClaims
+		if authorization := ctx.GetHeader("Authorization"); authorization != "" {
+			claims = h.authenticatePersonalAccessToken(ctx, route, authorization)
+			if claims == nil {
+				return
+			}
+		} else {
+			jwtToken, err := ctx.Cookie("jwt")
+			if err != nil {
+				ctx.AbortWithStatusJSON(401, map[string]any{"error": fmt.Sprintf("%v: missing jwt cookie", route)})
+				return
+			}
+
+			claims, err = h.parseAndVerifyJwtToken(ctx.Request.Context(), jwtToken)
+			if err != nil {
+				ctx.AbortWithStatusJSON(401, map[string]any{"error": fmt.Sprintf("%v: jwt verification failed: %v", route, err)})
+				return
+			}
+			ctx.Set(jwtKey, jwtToken)
+		}
+
+		if h.VerifiedEmailRoutes[route] && !claims.EmailVerified {
+			ctx.AbortWithStatusJSON(403, map[string]any{"error": fmt.Sprintf("%v: email must be verified to perform this operation", route)})
+			return
+		}
+
+		ctx.Set(jwtClaimsKey, claims)
+		ctx.Next()
+	}
+}
+
+// optionallyAuthenticated lets through anonymous requests, i.e. ones without jwt cookie and
+// authorization header. Credentials, if provided, must be valid, just as for authenticated().
+func (h *HandleContext) optionallyAuthenticated() gin.HandlerFunc {
+	authenticated := h.authenticated()
+	return func(ctx *gin.Context) {
+		if _, err := ctx.Cookie("jwt"); err != nil && ctx.GetHeader("Authorization") == "" {
+			ctx.Next()
+			return
+		}
+		authenticated(ctx)
+	}
+}
+
+// requireRoles must follow authenticated(). It lets through only callers having at least one of the roles.
+func (h *HandleContext) requireRoles(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims := getJwtClaims(ctx)
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				ctx.Next()
+				return
+			}
+		}
+
+		ctx.AbortWithStatusJSON(403, map[string]any{"error": fmt.Sprintf("%v: one of roles %v is required", ctx.FullPath(), roles)})
+	}
+}
+
+func getJwtClaims(ctx *gin.Context) *JwtClaims {
+	return ctx.MustGet(jwtClaimsKey).(*JwtClaims)
+}
+
+// getOptionalJwtClaims is getJwtClaims for routes behind optionallyAuthenticated(), nil stands for anonymous caller.
+func getOptionalJwtClaims(ctx *gin.Context) *JwtClaims {
+	claims, ok := ctx.Get(jwtClaimsKey)
+	if !ok {
+		return nil
+	}
+	return claims.(*JwtClaims)
+}
+
+// retryAfter extracts delay suggested by the service, if there is one.
+func retryAfter(st *status.Status) (time.Duration, bool) {
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			return retryInfo.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+func respondTooManyRequests(ctx *gin.Context, route string, st *status.Status) {
+	if delay, ok := retryAfter(st); ok {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	ctx.JSON(429, map[string]any{"error": fmt.Sprintf("%v: %v", route, st.Err().Error())})
+}
+
+// fieldError is a problem of a single field found by the gateway itself, it is reported
+// the same way as violations found by the service, see respondInvalidArgument.
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.field, e.err)
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// respondInvalidInput replies with 400 to input the gateway rejected. If err is fieldError,
+// the field is listed under "fields" as in respondInvalidArgument.
+func respondInvalidInput(ctx *gin.Context, route string, message string, err error) {
+	response := map[string]any{"error": fmt.Sprintf("%v: %v: %v", route, message, err)}
+
+	var fieldErr *fieldError
+	if errors.As(err, &fieldErr) {
+		response["fields"] = map[string]string{fieldErr.field: fieldErr.err.Error()}
+	}
+
+	ctx.JSON(400, response)
+}
+
+// respondInvalidArgument replies with 400. Fields the service found invalid are listed under
+// "fields" keyed by field name, so the client is able to point at them.
+func respondInvalidArgument(ctx *gin.Context, route string, st *status.Status) {
+	response := map[string]any{"error": fmt.Sprintf("%v: %v", route, st.Err().Error())}
+
+	fields := make(map[string]string)
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				if prev, ok := fields[violation.Field]; ok {
+					fields[violation.Field] = prev + "; " + violation.Description
+				} else {
+					fields[violation.Field] = violation.Description
+				}
+			}
+		}
+	}
+	if len(fields) > 0 {
+		response["fields"] = fields
+	}
+
+	ctx.JSON(400, response)
+}
+
+// withClientMetadata forwards information about the client, that user service uses to
+// throttle authentication attempts and to describe sessions.
+func withClientMetadata(c context.Context, ctx *gin.Context) context.Context {
+	return metadata.AppendToOutgoingContext(c,
+		"x-client-ip", ctx.ClientIP(),
+		"x-client-user-agent", ctx.Request.UserAgent(),
+	)
+}
+
+// withActorMetadata also forwards the authenticated caller, to whom the service attributes
+// the changes. It must be used only after authenticated().
+func withActorMetadata(c context.Context, ctx *gin.Context) context.Context {
+	return metadata.AppendToOutgoingContext(withClientMetadata(c, ctx),
+		"x-actor-id", getJwtClaims(ctx).UserId.String(),
+	)
+}
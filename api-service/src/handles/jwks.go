@@ -0,0 +1,133 @@
+package handles
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	userservice "soa-project/user-service/proto"
+)
+
+// Unknown kid triggers out of order refresh, but not more often than this.
+const keySetMinRefreshInterval = time.Second * 10
+
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// KeySet keeps verification keys published by user-service.
+type KeySet struct {
+	client userservice.UserServiceClient
+
+	mu          sync.RWMutex
+	jwks        []Jwk
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+	lastAttempt time.Time
+}
+
+func NewKeySet(client userservice.UserServiceClient) *KeySet {
+	return &KeySet{client: client, keys: make(map[string]*rsa.PublicKey)}
+}
+
+func parseJwk(jwk *userservice.Jwk) (*rsa.PublicKey, error) {
+	if jwk.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type: %v", jwk.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func (k *KeySet) Refresh(ctx context.Context) error {
+	response, err := k.client.GetJwks(ctx, &userservice.GetJwksRequest{})
+	if err != nil {
+		return err
+	}
+
+	jwks := make([]Jwk, 0, len(response.Keys))
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range response.Keys {
+		public, err := parseJwk(jwk)
+		if err != nil {
+			log.Printf("skipping jwk %v: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = public
+		jwks = append(jwks, Jwk{Kty: jwk.Kty, Kid: jwk.Kid, Use: jwk.Use, Alg: jwk.Alg, N: jwk.N, E: jwk.E})
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.jwks = jwks
+	k.keys = keys
+	k.lastRefresh = time.Now()
+
+	return nil
+}
+
+func (k *KeySet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, interval)
+		err := k.Refresh(refreshCtx)
+		cancel()
+		if err != nil {
+			log.Printf("failed to refresh jwks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lookup returns key with the given kid. Key that is not known yet may belong to
+// freshly rotated signing key, so key set is refreshed before giving up.
+func (k *KeySet) Lookup(ctx context.Context, kid string) (*rsa.PublicKey, bool) {
+	k.mu.Lock()
+	public, ok := k.keys[kid]
+	if ok || time.Since(k.lastRefresh) < keySetMinRefreshInterval || time.Since(k.lastAttempt) < keySetMinRefreshInterval {
+		k.mu.Unlock()
+		return public, ok
+	}
+	k.lastAttempt = time.Now()
+	k.mu.Unlock()
+
+	err := k.Refresh(ctx)
+	if err != nil {
+		log.Printf("failed to refresh jwks: %v", err)
+		return nil, false
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	public, ok = k.keys[kid]
+	return public, ok
+}
+
+func (k *KeySet) Jwks() []Jwk {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.jwks
+}
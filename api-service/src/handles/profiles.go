@@ -0,0 +1,251 @@
+package handles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+// Profile keys that may be patched, they match field names of user.Profile
+var profilePatchFields = []string{"name", "surname", "phone_number", "birthday"}
+
+// Keys of "visibility" object that may be patched, they match field names of user.ProfileVisibility
+var visibilityPatchFields = []string{"name", "surname", "phone_number", "birthday", "avatars"}
+
+// profilePatchToPb converts JSON merge patch (RFC 7396) of the profile into profile and update mask.
+// Every key present in the patch goes to the mask, so null clears the field and absent keys are kept.
+// Null visibility resets visibility of every field to its default.
+func profilePatchToPb(body []byte) (*shared.Profile, *fieldmaskpb.FieldMask, error) {
+	var patch map[string]json.RawMessage
+	err := json.Unmarshal(body, &patch)
+	if err != nil || patch == nil {
+		return nil, nil, fmt.Errorf("patch must be json object")
+	}
+
+	var profile Profile
+	mask := &fieldmaskpb.FieldMask{}
+	for key, value := range patch {
+		if key == "visibility" {
+			paths, err := visibilityPatchToStruct(value, &profile)
+			if err != nil {
+				return nil, nil, err
+			}
+			mask.Paths = append(mask.Paths, paths...)
+			continue
+		}
+		if !slices.Contains(profilePatchFields, key) {
+			return nil, nil, &fieldError{field: key, err: errors.New("can't be patched")}
+		}
+		mask.Paths = append(mask.Paths, key)
+
+		var field *string
+		err := json.Unmarshal(value, &field)
+		if err != nil {
+			return nil, nil, &fieldError{field: key, err: errors.New("must be string or null")}
+		}
+		if field == nil {
+			continue
+		}
+
+		switch key {
+		case "name":
+			profile.Name = *field
+		case "surname":
+			profile.Surname = *field
+		case "phone_number":
+			profile.PhoneNumber = *field
+		case "birthday":
+			profile.Birthday = *field
+		}
+	}
+	slices.Sort(mask.Paths)
+
+	pbProfile, err := ProfileStructToPb(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pbProfile, mask, nil
+}
+
+// visibilityPatchToStruct stores patch of "visibility" object into profile and returns paths to be updated.
+func visibilityPatchToStruct(value json.RawMessage, profile *Profile) ([]string, error) {
+	var patch map[string]*string
+	err := json.Unmarshal(value, &patch)
+	if err != nil {
+		return nil, &fieldError{field: "visibility", err: errors.New("must be object of strings or null")}
+	}
+	if patch == nil {
+		return []string{"visibility"}, nil
+	}
+
+	profile.Visibility = &ProfileVisibility{}
+	var paths []string
+	for key, field := range patch {
+		if !slices.Contains(visibilityPatchFields, key) {
+			return nil, &fieldError{field: "visibility." + key, err: errors.New("can't be patched")}
+		}
+		paths = append(paths, "visibility."+key)
+		if field == nil {
+			continue
+		}
+		if *field == "" {
+			return nil, &fieldError{field: "visibility." + key, err: errors.New("must not be empty")}
+		}
+
+		switch key {
+		case "name":
+			profile.Visibility.Name = *field
+		case "surname":
+			profile.Visibility.Surname = *field
+		case "phone_number":
+			profile.Visibility.PhoneNumber = *field
+		case "birthday":
+			profile.Visibility.Birthday = *field
+		case "avatars":
+			profile.Visibility.Avatars = *field
+		}
+	}
+	return paths, nil
+}
+
+func handlePatchProfile(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/profile: couldn't read body: %v", err)})
+			return
+		}
+
+		profile, mask, err := profilePatchToPb(body)
+		if err != nil {
+			respondInvalidInput(ctx, "/me/profile", "provided patch is invalid", err)
+			return
+		}
+		// Empty mask would replace the whole profile, while empty patch changes nothing
+		if len(mask.Paths) == 0 {
+			ctx.Status(200)
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		_, err = h.UserserviceClient.UpdateProfile(withActorMetadata(c, ctx), &userservice.UpdateProfileRequest{
+			Id:         &shared.Id{Uuid: claims.UserId.String()},
+			Profile:    profile,
+			UpdateMask: mask,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/profile: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/profile: %v", st.Err().Error())})
+			case codes.InvalidArgument:
+				respondInvalidArgument(ctx, "/me/profile", st)
+			case codes.Internal:
+				log.Printf("/me/profile: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/profile: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(200)
+	}
+}
+
+func handleGetProfileHistory(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		userId, err := uuid.Parse(ctx.Param("user_id"))
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/profiles/history: couldn't retrieve id: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+		if claims.UserId != userId && !claims.HasRole(roleAdmin) {
+			ctx.JSON(403, map[string]any{"error": "/profiles/history: only the owner and admins may see profile history"})
+			return
+		}
+
+		request := &userservice.GetProfileHistoryRequest{
+			Id:        &shared.Id{Uuid: userId.String()},
+			PageToken: ctx.Query("page_token"),
+		}
+		if pageSize := ctx.Query("page_size"); pageSize != "" {
+			size, err := strconv.Atoi(pageSize)
+			if err != nil || size <= 0 {
+				ctx.JSON(400, map[string]any{"error": "/profiles/history: page_size must be a positive integer"})
+				return
+			}
+			request.PageSize = int32(min(size, 1<<30))
+		}
+
+		response, err := h.UserserviceClient.GetProfileHistory(c, request)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/profiles/history: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/profiles/history: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/profiles/history: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/profiles/history: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/profiles/history: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		changes := make([]map[string]any, 0, len(response.Changes))
+		for _, change := range response.Changes {
+			fields := make([]map[string]any, 0, len(change.Fields))
+			for _, field := range change.Fields {
+				fields = append(fields, map[string]any{"field": field.Field, "old_value": field.OldValue, "new_value": field.NewValue})
+			}
+			changes = append(changes, map[string]any{
+				"actor_id":    change.ActorId.GetUuid(),
+				"change_time": change.ChangeTime.AsTime(),
+				"ip":          change.Ip,
+				"user_agent":  change.UserAgent,
+				"fields":      fields,
+			})
+		}
+
+		ctx.JSON(200, map[string]any{"changes": changes, "next_page_token": response.NextPageToken})
+	}
+}
@@ -0,0 +1,254 @@
+package handles
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProfilePatchToPb(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		paths   []string
+		surname string
+		valid   bool
+	}{
+		{
+			name:    "Present keys only",
+			body:    `{"surname": "Smith"}`,
+			paths:   []string{"surname"},
+			surname: "Smith",
+			valid:   true,
+		},
+		{
+			name:  "Null clears field",
+			body:  `{"surname": null, "birthday": "2000-01-02"}`,
+			paths: []string{"birthday", "surname"},
+			valid: true,
+		},
+		{
+			name:  "Empty patch",
+			body:  `{}`,
+			paths: nil,
+			valid: true,
+		},
+		{
+			name:  "Read only field",
+			body:  `{"creation_time": "2000-01-02T00:00:00Z"}`,
+			valid: false,
+		},
+		{
+			name:  "Not a string",
+			body:  `{"name": 1}`,
+			valid: false,
+		},
+		{
+			name:  "Invalid birthday",
+			body:  `{"birthday": "02.01.2000"}`,
+			valid: false,
+		},
+		{
+			name:  "Not an object",
+			body:  `null`,
+			valid: false,
+		},
+		{
+			name:  "Visibility of some fields",
+			body:  `{"visibility": {"phone_number": "followers", "birthday": null}}`,
+			paths: []string{"visibility.birthday", "visibility.phone_number"},
+			valid: true,
+		},
+		{
+			name:  "Null visibility resets all fields",
+			body:  `{"visibility": null, "name": "Alice"}`,
+			paths: []string{"name", "visibility"},
+			valid: true,
+		},
+		{
+			name:  "Unknown visibility",
+			body:  `{"visibility": {"name": "friends"}}`,
+			valid: false,
+		},
+		{
+			name:  "Visibility of unknown field",
+			body:  `{"visibility": {"email": "public"}}`,
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, mask, err := profilePatchToPb([]byte(tt.body))
+			if (err == nil) != tt.valid {
+				t.Fatalf("profilePatchToPb returned %v, where valid=%v expected", err, tt.valid)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(mask.Paths, tt.paths) || profile.Surname != tt.surname {
+				t.Errorf("profilePatchToPb returned %v with mask %v, where surname %q and mask %v expected", profile, mask.Paths, tt.surname, tt.paths)
+			}
+		})
+	}
+}
+
+func TestRespondInvalidArgument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st, err := status.New(codes.InvalidArgument, "invalid profile").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "phone_number", Description: "must start with + and country code"},
+			{Field: "birthday", Description: "must not be in the future"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	respondInvalidArgument(ctx, "/me/profile", st)
+
+	var response struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != 400 || len(response.Fields) != 2 || response.Fields["birthday"] != "must not be in the future" {
+		t.Errorf("got %v %s, where 400 with both fields expected", recorder.Code, recorder.Body.Bytes())
+	}
+}
+
+func TestOptionallyAuthenticated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &HandleContext{UserserviceClient: tokenVerifier{tokens: map[string][]string{
+		"pat_reader": {scopeAccountRead},
+	}}}
+
+	tests := []struct {
+		name          string
+		cookie        string
+		authorization string
+		expected      int
+		anonymous     bool
+	}{
+		{
+			name:      "Anonymous",
+			expected:  200,
+			anonymous: true,
+		},
+		{
+			name:     "Invalid jwt",
+			cookie:   "garbage",
+			expected: 401,
+		},
+		{
+			name:          "Personal access token",
+			authorization: "Bearer pat_reader",
+			expected:      200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.GET("/profiles", h.optionallyAuthenticated(), func(ctx *gin.Context) {
+				if (getOptionalJwtClaims(ctx) == nil) != tt.anonymous {
+					t.Errorf("getOptionalJwtClaims returned %v, where anonymous=%v expected", getOptionalJwtClaims(ctx), tt.anonymous)
+				}
+				ctx.Status(200)
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/profiles", nil)
+			if tt.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "jwt", Value: tt.cookie})
+			}
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+			if recorder.Code != tt.expected {
+				t.Errorf("got status %v, where %v expected", recorder.Code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGatewayFieldErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userId := uuid.New()
+	h := &HandleContext{}
+
+	tests := []struct {
+		name   string
+		method string
+		route  string
+		body   string
+		field  string
+	}{
+		{
+			name:   "Patch with invalid birthday",
+			method: http.MethodPatch,
+			route:  "/me/profile",
+			body:   `{"birthday": "2000-13-01"}`,
+			field:  "birthday",
+		},
+		{
+			name:   "Patch with unknown visibility",
+			method: http.MethodPatch,
+			route:  "/me/profile",
+			body:   `{"visibility": {"phone_number": "friends"}}`,
+			field:  "visibility.phone_number",
+		},
+		{
+			name:   "Patch of read only field",
+			method: http.MethodPatch,
+			route:  "/me/profile",
+			body:   `{"creation_time": "2000-01-02T00:00:00Z"}`,
+			field:  "creation_time",
+		},
+		{
+			name:   "Update with invalid birthday",
+			method: http.MethodPost,
+			route:  "/profiles/update",
+			body:   `{"user_id": "` + userId.String() + `", "birthday": "2000-13-01"}`,
+			field:  "birthday",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			withClaims := func(ctx *gin.Context) { ctx.Set(jwtClaimsKey, &JwtClaims{UserId: userId}) }
+			engine.PATCH("/me/profile", withClaims, gin.HandlerFunc(handlePatchProfile(h)))
+			engine.POST("/profiles/update", withClaims, gin.HandlerFunc(handleUpdateProfile(h)))
+
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.route, strings.NewReader(tt.body)))
+
+			var response struct {
+				Fields map[string]string `json:"fields"`
+			}
+			err := json.Unmarshal(recorder.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorder.Code != 400 || len(response.Fields) != 1 || response.Fields[tt.field] == "" {
+				t.Errorf("got %v %s, where 400 with field %v expected", recorder.Code, recorder.Body.Bytes(), tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package handles
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	userservice "soa-project/user-service/proto"
+)
+
+const (
+	revocationKindToken   = "token"
+	revocationKindUser    = "user"
+	revocationKindSession = "session"
+)
+
+// Revocations are fetched incrementally, but a revocation committed concurrently with the
+// previous sync may carry slightly older timestamp. Re-requesting a small window covers it.
+const revocationSyncOverlap = time.Minute
+
+type revocationKey struct {
+	kind    string
+	subject string
+}
+
+type revocationEntry struct {
+	revocationTime time.Time
+	expirationTime time.Time
+}
+
+// RevocationCache mirrors revocation list of user-service, so tokens can be checked without grpc call.
+type RevocationCache struct {
+	mu       sync.RWMutex
+	entries  map[revocationKey]revocationEntry
+	lastSync time.Time
+}
+
+func NewRevocationCache() *RevocationCache {
+	return &RevocationCache{entries: make(map[revocationKey]revocationEntry)}
+}
+
+func (c *RevocationCache) Add(kind string, subject string, revocationTime time.Time, expirationTime time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := revocationKey{kind: kind, subject: subject}
+	entry, ok := c.entries[key]
+	if !ok || entry.revocationTime.Before(revocationTime) {
+		entry.revocationTime = revocationTime
+	}
+	if !ok || entry.expirationTime.Before(expirationTime) {
+		entry.expirationTime = expirationTime
+	}
+	c.entries[key] = entry
+}
+
+func (c *RevocationCache) IsRevoked(claims *JwtClaims) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.entries[revocationKey{kind: revocationKindToken, subject: claims.TokenId}]
+	if ok {
+		return true
+	}
+
+	if claims.SessionId != "" {
+		_, ok = c.entries[revocationKey{kind: revocationKindSession, subject: claims.SessionId}]
+		if ok {
+			return true
+		}
+	}
+
+	// iat has seconds precision, so token issued in the same second as revocation is
+	// considered fresh. Otherwise user who relogins right away would be locked out.
+	entry, ok := c.entries[revocationKey{kind: revocationKindUser, subject: claims.UserId.String()}]
+	if ok && claims.IssuedAt.Before(entry.revocationTime.Truncate(time.Second)) {
+		return true
+	}
+
+	return false
+}
+
+func (c *RevocationCache) Sync(ctx context.Context, client userservice.UserServiceClient) error {
+	c.mu.RLock()
+	var since *timestamppb.Timestamp
+	if !c.lastSync.IsZero() {
+		since = timestamppb.New(c.lastSync.Add(-revocationSyncOverlap))
+	}
+	c.mu.RUnlock()
+
+	response, err := client.ListRevocations(ctx, &userservice.ListRevocationsRequest{Since: since})
+	if err != nil {
+		return err
+	}
+
+	for _, revocation := range response.Revocations {
+		c.Add(revocation.Kind, revocation.Subject, revocation.RevocationTime.AsTime(), revocation.ExpirationTime.AsTime())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSync = response.SyncTime.AsTime()
+	now := time.Now()
+	for key, entry := range c.entries {
+		if entry.expirationTime.Before(now) {
+			delete(c.entries, key)
+		}
+	}
+
+	return nil
+}
+
+func (c *RevocationCache) Run(ctx context.Context, client userservice.UserServiceClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		syncCtx, cancel := context.WithTimeout(ctx, interval)
+		err := c.Sync(syncCtx, client)
+		cancel()
+		if err != nil {
+			log.Printf("failed to sync revocation list: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package handles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRevocationCache(t *testing.T) {
+	now := time.Now()
+	userId := uuid.New()
+	cache := NewRevocationCache()
+	cache.Add(revocationKindToken, "revoked", now, now.Add(time.Minute))
+	cache.Add(revocationKindUser, userId.String(), now, now.Add(time.Minute))
+	cache.Add(revocationKindSession, "signed-out", now, now.Add(time.Minute))
+
+	tests := []struct {
+		name     string
+		claims   JwtClaims
+		expected bool
+	}{
+		{
+			name:     "Revoked token",
+			claims:   JwtClaims{TokenId: "revoked"},
+			expected: true,
+		},
+		{
+			name:     "Other token",
+			claims:   JwtClaims{TokenId: "other"},
+			expected: false,
+		},
+		{
+			name:     "Token of revoked session",
+			claims:   JwtClaims{TokenId: "other", SessionId: "signed-out"},
+			expected: true,
+		},
+		{
+			name:     "Token of other session",
+			claims:   JwtClaims{TokenId: "other", SessionId: "active"},
+			expected: false,
+		},
+		{
+			name:     "Issued before user revocation",
+			claims:   JwtClaims{UserId: userId, TokenId: "old", IssuedAt: now.Add(-time.Minute)},
+			expected: true,
+		},
+		{
+			name:     "Issued after user revocation",
+			claims:   JwtClaims{UserId: userId, TokenId: "new", IssuedAt: now.Add(time.Second)},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if revoked := cache.IsRevoked(&tt.claims); revoked != tt.expected {
+				t.Errorf("IsRevoked(%v) returned %v, where %v expected", tt.claims, revoked, tt.expected)
+			}
+		})
+	}
+}
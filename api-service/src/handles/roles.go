@@ -0,0 +1,159 @@
+package handles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+const roleAdmin = "admin"
+
+func handleListRoles(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		request := &userservice.ListRolesRequest{}
+		if userId := ctx.Query("user_id"); userId != "" {
+			request.Id = &shared.Id{Uuid: userId}
+		}
+
+		response, err := h.UserserviceClient.ListRoles(c, request)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/roles: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/roles: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/roles: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/roles: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/roles: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		roles := make([]map[string]any, 0, len(response.Roles))
+		for _, role := range response.Roles {
+			roles = append(roles, map[string]any{"name": role.Name, "description": role.Description})
+		}
+
+		ctx.JSON(200, map[string]any{"roles": roles})
+	}
+}
+
+func handleAssignRole(h *HandleContext) HandlerFunc {
+	type Request struct {
+		UserId string `json:"user_id"`
+		Role   string `json:"role"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/roles/assign: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		_, err = h.UserserviceClient.AssignRole(c, &userservice.AssignRoleRequest{
+			Id:   &shared.Id{Uuid: request.UserId},
+			Role: request.Role,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/roles/assign: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/roles/assign: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/roles/assign: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/roles/assign: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/roles/assign: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(200)
+	}
+}
+
+func handleRevokeRole(h *HandleContext) HandlerFunc {
+	type Request struct {
+		UserId string `json:"user_id"`
+		Role   string `json:"role"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/roles/revoke: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		_, err = h.UserserviceClient.RevokeRole(c, &userservice.RevokeRoleRequest{
+			Id:   &shared.Id{Uuid: request.UserId},
+			Role: request.Role,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/roles/revoke: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/roles/revoke: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/roles/revoke: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/roles/revoke: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/roles/revoke: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		// Tokens of the user carrying the revoked role were revoked, pick it up without waiting for the periodic sync
+		err = h.Revocations.Sync(c, h.UserserviceClient)
+		if err != nil {
+			log.Printf("/roles/revoke: failed to sync revocations: %v\n", err)
+		}
+
+		ctx.Status(200)
+	}
+}
@@ -0,0 +1,53 @@
+package handles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &HandleContext{}
+
+	tests := []struct {
+		name     string
+		roles    []string
+		expected int
+	}{
+		{
+			name:     "No roles",
+			roles:    nil,
+			expected: 403,
+		},
+		{
+			name:     "Other role",
+			roles:    []string{"moderator"},
+			expected: 403,
+		},
+		{
+			name:     "Required role",
+			roles:    []string{"moderator", roleAdmin},
+			expected: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.GET("/admin",
+				func(ctx *gin.Context) { ctx.Set(jwtClaimsKey, &JwtClaims{Roles: tt.roles}) },
+				h.requireRoles(roleAdmin),
+				func(ctx *gin.Context) { ctx.Status(200) },
+			)
+
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin", nil))
+			if recorder.Code != tt.expected {
+				t.Errorf("got status %v, where %v expected", recorder.Code, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+func foundUserToJson(user *userservice.FoundUser) map[string]any {
+	result := map[string]any{"user_id": user.Id.GetUuid(), "login": user.Login}
+	if user.Profile != nil {
+		result["profile"] = ProfilePbToStruct(user.Profile)
+	}
+	return result
+}
+
+func handleSearchUsers(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		request := &userservice.SearchUsersRequest{
+			Query:     ctx.Query("q"),
+			PageToken: ctx.Query("page_token"),
+		}
+		if pageSize := ctx.Query("page_size"); pageSize != "" {
+			size, err := strconv.Atoi(pageSize)
+			if err != nil || size <= 0 {
+				ctx.JSON(400, map[string]any{"error": "/users/search: page_size must be a positive integer"})
+				return
+			}
+			request.PageSize = int32(min(size, 1<<30))
+		}
+		// Names hidden from the viewer are neither searched nor returned
+		if claims := getOptionalJwtClaims(ctx); claims != nil {
+			request.ViewerId = &shared.Id{Uuid: claims.UserId.String()}
+		}
+
+		response, err := h.UserserviceClient.SearchUsers(c, request)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/users/search: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/users/search: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/users/search: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/users/search: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		users := make([]map[string]any, 0, len(response.Users))
+		for _, user := range response.Users {
+			users = append(users, foundUserToJson(user))
+		}
+
+		ctx.JSON(200, map[string]any{"users": users, "next_page_token": response.NextPageToken})
+	}
+}
@@ -0,0 +1,93 @@
+package handles
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+// userSearcher answers SearchUsers with a single user and remembers the request, other calls panic.
+type userSearcher struct {
+	userservice.UserServiceClient
+	request *userservice.SearchUsersRequest
+}
+
+func (s *userSearcher) SearchUsers(ctx context.Context, in *userservice.SearchUsersRequest, opts ...grpc.CallOption) (*userservice.SearchUsersResponse, error) {
+	s.request = in
+	return &userservice.SearchUsersResponse{
+		Users:         []*userservice.FoundUser{{Id: &shared.Id{Uuid: "a"}, Login: "alice", Profile: &shared.Profile{Name: "Alice"}}},
+		NextPageToken: "next",
+	}, nil
+}
+
+func TestHandleSearchUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+		pageSize int32
+	}{
+		{
+			name:     "Query only",
+			query:    "?q=alic",
+			expected: 200,
+		},
+		{
+			name:     "With page",
+			query:    "?q=alic&page_size=5&page_token=token",
+			expected: 200,
+			pageSize: 5,
+		},
+		{
+			name:     "Invalid page size",
+			query:    "?q=alic&page_size=-1",
+			expected: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			searcher := &userSearcher{}
+			h := &HandleContext{UserserviceClient: searcher}
+			engine := gin.New()
+			engine.GET("/users/search", h.optionallyAuthenticated(), gin.HandlerFunc(handleSearchUsers(h)))
+
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/users/search"+tt.query, nil))
+			if recorder.Code != tt.expected {
+				t.Fatalf("got status %v, where %v expected", recorder.Code, tt.expected)
+			}
+			if recorder.Code != 200 {
+				return
+			}
+
+			if searcher.request.Query != "alic" || searcher.request.PageSize != tt.pageSize || searcher.request.ViewerId != nil {
+				t.Errorf("SearchUsers was called with %v, where anonymous search of alic expected", searcher.request)
+			}
+			var response struct {
+				Users []struct {
+					Login   string  `json:"login"`
+					Profile Profile `json:"profile"`
+				} `json:"users"`
+				NextPageToken string `json:"next_page_token"`
+			}
+			err := json.Unmarshal(recorder.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Users) != 1 || response.Users[0].Profile.Name != "Alice" || response.NextPageToken != "next" {
+				t.Errorf("got %s, where alice with next page token expected", recorder.Body.Bytes())
+			}
+		})
+	}
+}
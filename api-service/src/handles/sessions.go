@@ -0,0 +1,102 @@
+package handles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+func handleListSessions(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.ListSessions(c, &userservice.ListSessionsRequest{
+			Id: &shared.Id{Uuid: claims.UserId.String()},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/sessions: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.Internal:
+				log.Printf("/me/sessions: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/sessions: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		sessions := make([]map[string]any, 0, len(response.Sessions))
+		for _, session := range response.Sessions {
+			sessions = append(sessions, map[string]any{
+				"session_id":    session.SessionId,
+				"creation_time": session.CreationTime.AsTime(),
+				"last_use_time": session.LastUseTime.AsTime(),
+				"user_agent":    session.UserAgent,
+				"ip":            session.Ip,
+				"current":       session.SessionId == claims.SessionId,
+			})
+		}
+
+		ctx.JSON(200, map[string]any{"sessions": sessions})
+	}
+}
+
+func handleRevokeSession(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		_, err := h.UserserviceClient.RevokeSession(c, &userservice.RevokeSessionRequest{
+			Id:        &shared.Id{Uuid: claims.UserId.String()},
+			SessionId: ctx.Param("session_id"),
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/sessions/:session_id: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me/sessions/:session_id: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/sessions/:session_id: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/me/sessions/:session_id: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/sessions/:session_id: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		// Access tokens of the session must stop working right away, not after the periodic sync
+		err = h.Revocations.Sync(c, h.UserserviceClient)
+		if err != nil {
+			log.Printf("/me/sessions/:session_id: failed to sync revocations: %v\n", err)
+		}
+
+		ctx.Status(200)
+	}
+}
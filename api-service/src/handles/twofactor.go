@@ -0,0 +1,214 @@
+package handles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "soa-project/shared/proto"
+	userservice "soa-project/user-service/proto"
+)
+
+func handleVerifySecondFactor(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/auth/2fa: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		response, err := h.UserserviceClient.VerifySecondFactor(withClientMetadata(c, ctx), &userservice.VerifySecondFactorRequest{
+			Challenge: request.Challenge,
+			Code:      request.Code,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/auth/2fa: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.Unauthenticated:
+				ctx.JSON(401, map[string]any{"error": fmt.Sprintf("/auth/2fa: %v", st.Err().Error())})
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/auth/2fa: %v", st.Err().Error())})
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/auth/2fa: %v", st.Err().Error())})
+			case codes.ResourceExhausted:
+				respondTooManyRequests(ctx, "/auth/2fa", st)
+			case codes.PermissionDenied:
+				if _, ok := retryAfter(st); ok {
+					respondTooManyRequests(ctx, "/auth/2fa", st)
+				} else {
+					ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/auth/2fa: %v", st.Err().Error())})
+				}
+			case codes.Internal:
+				log.Printf("/auth/2fa: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/auth/2fa: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"user_id": response.Id.String(), "jwt": response.Jwt, "refresh_token": response.RefreshToken})
+	}
+}
+
+func handleEnrollTotp(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.EnrollTotp(c, &userservice.EnrollTotpRequest{
+			Id: &shared.Id{Uuid: claims.UserId.String()},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/2fa/totp/enroll: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/2fa/totp/enroll: %v", st.Err().Error())})
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/2fa/totp/enroll: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/2fa/totp/enroll: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/2fa/totp/enroll: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"secret": response.Secret, "uri": response.Uri})
+	}
+}
+
+func handleConfirmTotp(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Code string `json:"code"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/2fa/totp/confirm: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.ConfirmTotp(c, &userservice.ConfirmTotpRequest{
+			Id:   &shared.Id{Uuid: claims.UserId.String()},
+			Code: request.Code,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/2fa/totp/confirm: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.PermissionDenied:
+				ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/2fa/totp/confirm: %v", st.Err().Error())})
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/2fa/totp/confirm: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/2fa/totp/confirm: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/2fa/totp/confirm: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"recovery_codes": response.RecoveryCodes})
+	}
+}
+
+func handleDisableTotp(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/2fa/totp/disable: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		_, err = h.UserserviceClient.DisableTotp(withClientMetadata(c, ctx), &userservice.DisableTotpRequest{
+			Id:       &shared.Id{Uuid: claims.UserId.String()},
+			Password: request.Password,
+			Code:     request.Code,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/2fa/totp/disable: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/2fa/totp/disable: %v", st.Err().Error())})
+			case codes.ResourceExhausted:
+				respondTooManyRequests(ctx, "/2fa/totp/disable", st)
+			case codes.PermissionDenied:
+				if _, ok := retryAfter(st); ok {
+					respondTooManyRequests(ctx, "/2fa/totp/disable", st)
+				} else {
+					ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/2fa/totp/disable: %v", st.Err().Error())})
+				}
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/2fa/totp/disable: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/2fa/totp/disable: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/2fa/totp/disable: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(200)
+	}
+}
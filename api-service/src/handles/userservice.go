@@ -2,13 +2,11 @@ package handles
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"time"
-	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -24,9 +22,43 @@ import (
 func (h *HandleContext) HandleUserService(engine *gin.Engine) {
 	engine.POST("/register", gin.HandlerFunc(handleRegister(h)))
 	engine.POST("/auth", gin.HandlerFunc(handleAuth(h)))
+	engine.POST("/auth/refresh", gin.HandlerFunc(handleRefreshToken(h)))
+	engine.POST("/auth/2fa", gin.HandlerFunc(handleVerifySecondFactor(h)))
 	engine.GET("/users", gin.HandlerFunc(handleGetUserById(h)))
-	engine.GET("/profiles", gin.HandlerFunc(handleGetProfileById(h)))
-	engine.POST("/profiles/update", gin.HandlerFunc(handleUpdateProfile(h)))
+	engine.POST("/users/batch", h.optionallyAuthenticated(), gin.HandlerFunc(handleBatchGetUsers(h)))
+	engine.GET("/users/search", h.optionallyAuthenticated(), gin.HandlerFunc(handleSearchUsers(h)))
+	engine.GET("/profiles", h.optionallyAuthenticated(), gin.HandlerFunc(handleGetProfileById(h)))
+	engine.POST("/profiles/update", h.authenticated(), gin.HandlerFunc(handleUpdateProfile(h)))
+	engine.PATCH("/me/profile", h.authenticated(), gin.HandlerFunc(handlePatchProfile(h)))
+	engine.GET("/profiles/:user_id/history", h.authenticated(), gin.HandlerFunc(handleGetProfileHistory(h)))
+	engine.POST("/logout", h.authenticated(), gin.HandlerFunc(handleLogout(h)))
+	engine.GET("/.well-known/jwks.json", gin.HandlerFunc(handleJwks(h)))
+	engine.POST("/password/change", h.authenticated(), gin.HandlerFunc(handleChangePassword(h)))
+	engine.POST("/password/reset/request", gin.HandlerFunc(handleRequestPasswordReset(h)))
+	engine.POST("/password/reset/confirm", gin.HandlerFunc(handleConfirmPasswordReset(h)))
+	engine.POST("/email/verify", gin.HandlerFunc(handleVerifyEmail(h)))
+	engine.POST("/email/verify/resend", h.authenticated(), gin.HandlerFunc(handleResendVerificationEmail(h)))
+	engine.POST("/2fa/totp/enroll", h.authenticated(), gin.HandlerFunc(handleEnrollTotp(h)))
+	engine.POST("/2fa/totp/confirm", h.authenticated(), gin.HandlerFunc(handleConfirmTotp(h)))
+	engine.POST("/2fa/totp/disable", h.authenticated(), gin.HandlerFunc(handleDisableTotp(h)))
+	engine.DELETE("/me", h.authenticated(), gin.HandlerFunc(handleDeleteAccount(h)))
+	engine.GET("/me/export", h.authenticated(), gin.HandlerFunc(handleExportUserData(h)))
+	engine.GET("/me/sessions", h.authenticated(), gin.HandlerFunc(handleListSessions(h)))
+	engine.DELETE("/me/sessions/:session_id", h.authenticated(), gin.HandlerFunc(handleRevokeSession(h)))
+	engine.POST("/me/tokens", h.authenticated(), gin.HandlerFunc(handleCreatePersonalAccessToken(h)))
+	engine.GET("/me/tokens", h.authenticated(), gin.HandlerFunc(handleListPersonalAccessTokens(h)))
+	engine.DELETE("/me/tokens/:token_id", h.authenticated(), gin.HandlerFunc(handleRevokePersonalAccessToken(h)))
+	engine.PUT("/me/avatar", h.authenticated(), gin.HandlerFunc(handleUploadAvatar(h)))
+	engine.DELETE("/me/avatar", h.authenticated(), gin.HandlerFunc(handleDeleteAvatar(h)))
+	engine.GET("/avatars/:user_id/:avatar_id/:size", h.optionallyAuthenticated(), gin.HandlerFunc(handleGetAvatar(h)))
+	engine.GET("/roles", h.authenticated(), h.requireRoles(roleAdmin), gin.HandlerFunc(handleListRoles(h)))
+	engine.POST("/roles/assign", h.authenticated(), h.requireRoles(roleAdmin), gin.HandlerFunc(handleAssignRole(h)))
+	engine.POST("/roles/revoke", h.authenticated(), h.requireRoles(roleAdmin), gin.HandlerFunc(handleRevokeRole(h)))
+
+	admin := engine.Group("/admin", h.authenticated(), h.requireRoles(roleAdmin))
+	admin.GET("/users", gin.HandlerFunc(handleListUsers(h)))
+	admin.POST("/users/:user_id/suspend", gin.HandlerFunc(handleSuspendUser(h)))
+	admin.POST("/users/:user_id/reinstate", gin.HandlerFunc(handleReinstateUser(h)))
 }
 
 func handleRegister(h *HandleContext) HandlerFunc {
@@ -41,17 +73,10 @@ func handleRegister(h *HandleContext) HandlerFunc {
 			return
 		}
 
-		if err := checkPasswordValidity(user.Password); err != nil {
-			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/register: password is invalid: %v", err)})
-			return
-		}
-
-		hashedPass := hashPassword(user)
-
 		response, err := h.UserserviceClient.Register(c, &userservice.RegisterRequest{
-			Login:          user.Login,
-			Email:          user.Email,
-			HashedPassword: hex.EncodeToString(hashedPass[:]),
+			Login:    user.Login,
+			Email:    user.Email,
+			Password: user.Password,
 		})
 		if err != nil {
 			st, ok := status.FromError(err)
@@ -62,9 +87,9 @@ func handleRegister(h *HandleContext) HandlerFunc {
 			}
 			switch st.Code() {
 			case codes.AlreadyExists:
-				ctx.JSON(409, map[string]any{"error": "/register: user with provided login/email already exists"})
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/register: %v", st.Err().Error())})
 			case codes.InvalidArgument:
-				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/register: login/email have unexpected format: %v", st.Err().Error())})
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/register: login/email/password have unexpected format: %v", st.Err().Error())})
 			case codes.Internal:
 				log.Printf("/register: internal error: %v\n", st.Err().Error())
 				ctx.Status(500)
@@ -91,17 +116,10 @@ func handleAuth(h *HandleContext) HandlerFunc {
 			return
 		}
 
-		if err := checkPasswordValidity(user.Password); err != nil {
-			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/auth: password is invalid: %v", err)})
-			return
-		}
-
-		hashedPass := hashPassword(user)
-
-		response, err := h.UserserviceClient.Auth(c, &userservice.AuthRequest{
-			Login:          user.Login,
-			Email:          user.Email,
-			HashedPassword: hex.EncodeToString(hashedPass[:]),
+		response, err := h.UserserviceClient.Auth(withClientMetadata(c, ctx), &userservice.AuthRequest{
+			Login:    user.Login,
+			Email:    user.Email,
+			Password: user.Password,
 		})
 		if err != nil {
 			st, ok := status.FromError(err)
@@ -117,6 +135,14 @@ func handleAuth(h *HandleContext) HandlerFunc {
 				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/auth: login/email have unexpected format or invalid password provided: %v", st.Err().Error())})
 			case codes.NotFound:
 				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/auth: %v", st.Err().Error())})
+			case codes.ResourceExhausted:
+				respondTooManyRequests(ctx, "/auth", st)
+			case codes.PermissionDenied:
+				if _, ok := retryAfter(st); ok {
+					respondTooManyRequests(ctx, "/auth", st)
+				} else {
+					ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/auth: %v", st.Err().Error())})
+				}
 			case codes.Internal:
 				log.Printf("/auth: internal error: %v\n", st.Err().Error())
 				ctx.Status(500)
@@ -127,7 +153,59 @@ func handleAuth(h *HandleContext) HandlerFunc {
 			return
 		}
 
-		ctx.JSON(200, map[string]any{"user_id": response.Id.String(), "jwt": response.Jwt})
+		if response.SecondFactorChallenge != "" {
+			ctx.JSON(200, map[string]any{"user_id": response.Id.String(), "second_factor_required": true, "challenge": response.SecondFactorChallenge})
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"user_id": response.Id.String(), "jwt": response.Jwt, "refresh_token": response.RefreshToken})
+	}
+}
+
+func handleRefreshToken(h *HandleContext) HandlerFunc {
+	type Request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/auth/refresh: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		response, err := h.UserserviceClient.RefreshToken(withClientMetadata(c, ctx), &userservice.RefreshTokenRequest{
+			RefreshToken: request.RefreshToken,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/auth/refresh: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/auth/refresh: %v", st.Err().Error())})
+			case codes.Unauthenticated:
+				ctx.JSON(401, map[string]any{"error": fmt.Sprintf("/auth/refresh: %v", st.Err().Error())})
+			case codes.PermissionDenied:
+				ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/auth/refresh: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/auth/refresh: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/auth/refresh: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"user_id": response.Id.String(), "jwt": response.Jwt, "refresh_token": response.RefreshToken})
 	}
 }
 
@@ -176,7 +254,7 @@ func handleGetUserById(h *HandleContext) HandlerFunc {
 			return
 		}
 
-		ctx.JSON(200, map[string]any{"login": response.Login, "email": response.Email})
+		ctx.JSON(200, map[string]any{"login": response.Login, "email": response.Email, "email_verified": response.EmailVerified})
 	}
 }
 
@@ -201,9 +279,15 @@ func handleGetProfileById(h *HandleContext) HandlerFunc {
 			return
 		}
 
-		response, err := h.UserserviceClient.GetProfile(c, &userservice.GetProfileRequest{
+		profileRequest := &userservice.GetProfileRequest{
 			Id: &shared.Id{Uuid: uuid.String()},
-		})
+		}
+		// Fields hidden from the viewer are left empty by user service
+		if claims := getOptionalJwtClaims(ctx); claims != nil {
+			profileRequest.ViewerId = &shared.Id{Uuid: claims.UserId.String()}
+		}
+
+		response, err := h.UserserviceClient.GetProfile(c, profileRequest)
 		if err != nil {
 			st, ok := status.FromError(err)
 			if !ok {
@@ -260,18 +344,7 @@ func handleUpdateProfile(h *HandleContext) HandlerFunc {
 			return
 		}
 
-		jwtToken, err := ctx.Cookie("jwt")
-		if err != nil {
-			ctx.JSON(401, map[string]any{"error": "/profiles/update: missing jwt cookie"})
-			return
-		}
-
-		claims, err := h.parseAndVerifyJwtToken(jwtToken)
-		if err != nil {
-			ctx.JSON(401, map[string]any{"error": fmt.Sprintf("/profiles/update: jwt verification failed: %v", err)})
-			return
-		}
-
+		claims := getJwtClaims(ctx)
 		log.Printf("update: claims: %v", claims)
 
 		if claims.UserId != uuid {
@@ -281,11 +354,11 @@ func handleUpdateProfile(h *HandleContext) HandlerFunc {
 
 		profile, err := ProfileStructToPb(request.Profile)
 		if err != nil {
-			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/profiles/update: provided profile is invalid: %v", err)})
+			respondInvalidInput(ctx, "/profiles/update", "provided profile is invalid", err)
 			return
 		}
 
-		_, err = h.UserserviceClient.UpdateProfile(c, &userservice.UpdateProfileRequest{
+		_, err = h.UserserviceClient.UpdateProfile(withActorMetadata(c, ctx), &userservice.UpdateProfileRequest{
 			Id:      &shared.Id{Uuid: uuid.String()},
 			Profile: profile,
 		})
@@ -299,6 +372,8 @@ func handleUpdateProfile(h *HandleContext) HandlerFunc {
 			switch st.Code() {
 			case codes.NotFound:
 				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/profiles/update: %v", st.Err().Error())})
+			case codes.InvalidArgument:
+				respondInvalidArgument(ctx, "/profiles/update", st)
 			case codes.Internal:
 				log.Printf("/profiles/update: internal error: %v\n", st.Err().Error())
 				ctx.Status(502)
@@ -313,41 +388,412 @@ func handleUpdateProfile(h *HandleContext) HandlerFunc {
 	}
 }
 
-func hashPassword(user User) [16]byte {
-	return md5.Sum([]byte(user.Password + user.Login))
+func handleLogout(h *HandleContext) HandlerFunc {
+	type Request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		// Body is optional: without refresh token only the access token is revoked
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/logout: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		_, err = h.UserserviceClient.Logout(c, &userservice.LogoutRequest{
+			Jwt:          ctx.GetString(jwtKey),
+			RefreshToken: request.RefreshToken,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/logout: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.Unauthenticated:
+				ctx.JSON(401, map[string]any{"error": fmt.Sprintf("/logout: %v", st.Err().Error())})
+			case codes.PermissionDenied:
+				ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/logout: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/logout: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/logout: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		// Other gateway instances will learn about it on the next revocation sync
+		h.Revocations.Add(revocationKindToken, claims.TokenId, time.Now(), claims.ExpiresAt)
+
+		ctx.SetCookie("jwt", "", -1, "/", "", false, true)
+		ctx.Status(200)
+	}
 }
 
-func checkPasswordValidity(password string) error {
-	if len(password) > 72 {
-		return errors.New("too long password")
+func handleJwks(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		jwks := h.Keys.Jwks()
+		if jwks == nil {
+			ctx.JSON(503, map[string]any{"error": "/.well-known/jwks.json: keys were not fetched yet"})
+			return
+		}
+
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(200, map[string]any{"keys": jwks})
 	}
-	if len(password) < 7 {
-		return errors.New("too short password")
+}
+
+func handleChangePassword(h *HandleContext) HandlerFunc {
+	type Request struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
 	}
 
-	controls := 0
-	digits := 0
-	letters := 0
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/password/change: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
 
-	for _, r := range password {
-		if r > unicode.MaxASCII {
-			return errors.New("non-ASCII character in password")
+		_, err = h.UserserviceClient.ChangePassword(withClientMetadata(c, ctx), &userservice.ChangePasswordRequest{
+			Id:          &shared.Id{Uuid: claims.UserId.String()},
+			OldPassword: request.OldPassword,
+			NewPassword: request.NewPassword,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/password/change: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/password/change: %v", st.Err().Error())})
+			case codes.ResourceExhausted:
+				respondTooManyRequests(ctx, "/password/change", st)
+			case codes.PermissionDenied:
+				if _, ok := retryAfter(st); ok {
+					respondTooManyRequests(ctx, "/password/change", st)
+				} else {
+					ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/password/change: %v", st.Err().Error())})
+				}
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/password/change: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/password/change: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/password/change: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
 		}
-		switch {
-		case unicode.IsDigit(r):
-			digits++
-		case unicode.IsLetter(r):
-			letters++
-		case unicode.IsPunct(r) || unicode.IsSymbol(r):
-			controls++
-		case unicode.IsSpace(r):
-			return errors.New("space in password")
+
+		// All sessions of the user were revoked, pick it up without waiting for the periodic sync
+		err = h.Revocations.Sync(c, h.UserserviceClient)
+		if err != nil {
+			log.Printf("/password/change: failed to sync revocations: %v\n", err)
 		}
+
+		ctx.SetCookie("jwt", "", -1, "/", "", false, true)
+		ctx.Status(200)
 	}
+}
 
-	if controls == 0 || digits == 0 || letters == 0 {
-		return errors.New("password doesn't contain enough variety of symbols")
+func handleRequestPasswordReset(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Login string `json:"login"`
+		Email string `json:"email"`
 	}
 
-	return nil
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/password/reset/request: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		_, err = h.UserserviceClient.RequestPasswordReset(c, &userservice.RequestPasswordResetRequest{
+			Login: request.Login,
+			Email: request.Email,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/password/reset/request: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/password/reset/request: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/password/reset/request: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/password/reset/request: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(202)
+	}
+}
+
+func handleConfirmPasswordReset(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/password/reset/confirm: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		_, err = h.UserserviceClient.ConfirmPasswordReset(c, &userservice.ConfirmPasswordResetRequest{
+			Token:       request.Token,
+			NewPassword: request.NewPassword,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/password/reset/confirm: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.InvalidArgument:
+				ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/password/reset/confirm: %v", st.Err().Error())})
+			case codes.PermissionDenied:
+				ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/password/reset/confirm: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/password/reset/confirm: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/password/reset/confirm: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		err = h.Revocations.Sync(c, h.UserserviceClient)
+		if err != nil {
+			log.Printf("/password/reset/confirm: failed to sync revocations: %v\n", err)
+		}
+
+		ctx.Status(200)
+	}
+}
+
+func handleVerifyEmail(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Code string `json:"code"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/email/verify: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		response, err := h.UserserviceClient.VerifyEmail(c, &userservice.VerifyEmailRequest{
+			Code: request.Code,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/email/verify: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.PermissionDenied:
+				ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/email/verify: %v", st.Err().Error())})
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/email/verify: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/email/verify: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/email/verify: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.JSON(200, map[string]any{"user_id": response.Id.Uuid})
+	}
+}
+
+func handleResendVerificationEmail(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		_, err := h.UserserviceClient.ResendVerificationEmail(c, &userservice.ResendVerificationEmailRequest{
+			Id: &shared.Id{Uuid: claims.UserId.String()},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/email/verify/resend: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/email/verify/resend: %v", st.Err().Error())})
+			case codes.FailedPrecondition:
+				ctx.JSON(409, map[string]any{"error": fmt.Sprintf("/email/verify/resend: %v", st.Err().Error())})
+			case codes.ResourceExhausted:
+				respondTooManyRequests(ctx, "/email/verify/resend", st)
+			case codes.Internal:
+				log.Printf("/email/verify/resend: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/email/verify/resend: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Status(202)
+	}
+}
+
+func handleDeleteAccount(h *HandleContext) HandlerFunc {
+	type Request struct {
+		Password         string `json:"password"`
+		SecondFactorCode string `json:"second_factor_code"`
+	}
+
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var request Request
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(400, map[string]any{"error": fmt.Sprintf("/me: couldn't bind input to json: %v", err)})
+			return
+		}
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.DeleteAccount(withClientMetadata(c, ctx), &userservice.DeleteAccountRequest{
+			Id:               &shared.Id{Uuid: claims.UserId.String()},
+			Password:         request.Password,
+			SecondFactorCode: request.SecondFactorCode,
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me: %v", st.Err().Error())})
+			case codes.ResourceExhausted:
+				respondTooManyRequests(ctx, "/me", st)
+			case codes.PermissionDenied:
+				if _, ok := retryAfter(st); ok {
+					respondTooManyRequests(ctx, "/me", st)
+				} else {
+					ctx.JSON(403, map[string]any{"error": fmt.Sprintf("/me: %v", st.Err().Error())})
+				}
+			case codes.Internal:
+				log.Printf("/me: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		// All sessions of the user were revoked, pick it up without waiting for the periodic sync
+		err = h.Revocations.Sync(c, h.UserserviceClient)
+		if err != nil {
+			log.Printf("/me: failed to sync revocations: %v\n", err)
+		}
+
+		ctx.SetCookie("jwt", "", -1, "/", "", false, true)
+		ctx.JSON(200, map[string]any{"purging_time": response.PurgingTime.AsTime()})
+	}
+}
+
+func handleExportUserData(h *HandleContext) HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		claims := getJwtClaims(ctx)
+
+		response, err := h.UserserviceClient.ExportUserData(c, &userservice.ExportUserDataRequest{
+			Id: &shared.Id{Uuid: claims.UserId.String()},
+		})
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				log.Printf("/me/export: grpc err: %v\n", err)
+				ctx.Status(500)
+				return
+			}
+			switch st.Code() {
+			case codes.NotFound:
+				ctx.JSON(404, map[string]any{"error": fmt.Sprintf("/me/export: %v", st.Err().Error())})
+			case codes.Internal:
+				log.Printf("/me/export: internal error: %v\n", st.Err().Error())
+				ctx.Status(500)
+			default:
+				log.Printf("/me/export: non recognized status: %v (code: %v)\n", st.Err().Error(), st.Code())
+				ctx.Status(500)
+			}
+			return
+		}
+
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%v.json"`, claims.UserId))
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Data(200, "application/json", response.Archive)
+	}
 }
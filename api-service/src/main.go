@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
-	"path/filepath"
 	"soa-project/api-service/handles"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
@@ -15,27 +16,13 @@ import (
 )
 
 func main() {
-	jwtPublicFile := os.Getenv("JWT_PUBLIC")
 	userserviceGrpcAddr := os.Getenv("USERSERVICE_GRPC_ADDR")
+	verifiedEmailRoutes := os.Getenv("REQUIRE_VERIFIED_EMAIL")
+	trustedProxies := os.Getenv("TRUSTED_PROXIES")
 
 	log.Printf("USERSERVICE_GRPC_ADDR: %v", userserviceGrpcAddr)
-
-	if jwtPublicFile == "" {
-		log.Fatalf("jwt public key file not provided")
-	}
-
-	absolutePublicFile, err := filepath.Abs(jwtPublicFile)
-	if err != nil {
-		log.Fatalf("failed to obtain absolute path to public file: %v", err)
-	}
-	public, err := os.ReadFile(absolutePublicFile)
-	if err != nil {
-		log.Fatalf("failed to read jwtPublicFile: %v", err)
-	}
-	jwtPublic, err := jwt.ParseRSAPublicKeyFromPEM(public)
-	if err != nil {
-		log.Fatalf("failed to parse public key: %v", err)
-	}
+	log.Printf("REQUIRE_VERIFIED_EMAIL: %v", verifiedEmailRoutes)
+	log.Printf("TRUSTED_PROXIES: %v", trustedProxies)
 
 	if userserviceGrpcAddr == "" {
 		log.Fatalf("userservice grpc address not provided")
@@ -49,12 +36,39 @@ func main() {
 		log.Fatalf("failed to create grpc connection with userservice: %v", err)
 	}
 
+	userserviceClient := userservice.NewUserServiceClient(userserviceConn)
+
+	keys := handles.NewKeySet(userserviceClient)
+	go keys.Run(context.Background(), time.Minute)
+
+	revocations := handles.NewRevocationCache()
+	go revocations.Run(context.Background(), userserviceClient, time.Second*5)
+
 	handleContext := handles.HandleContext{
-		UserserviceClient: userservice.NewUserServiceClient(userserviceConn),
-		JwtPublic:         jwtPublic,
+		UserserviceClient:   userserviceClient,
+		Keys:                keys,
+		Revocations:         revocations,
+		VerifiedEmailRoutes: make(map[string]bool),
+	}
+	for _, route := range strings.Split(verifiedEmailRoutes, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			handleContext.VerifiedEmailRoutes[route] = true
+		}
 	}
 
 	engine := gin.Default()
+	// Client address is used to throttle authentication, so X-Forwarded-For
+	// is honored only when it comes from explicitly trusted proxies.
+	var proxies []string
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	err = engine.SetTrustedProxies(proxies)
+	if err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
 	handleContext.HandleUserService(engine)
 
 	engine.Run()
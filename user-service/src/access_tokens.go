@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "soa-project/user-service/proto"
+	"soa-project/user-service/storage"
+)
+
+const (
+	// Prefix lets gateway tell personal access tokens from jwt and secret scanners find leaked ones
+	personalAccessTokenPrefix = "pat_"
+
+	defaultPersonalAccessTokenLifetime = time.Hour * 24 * 90
+	maxPersonalAccessTokenLifetime     = time.Hour * 24 * 366
+	maxPersonalAccessTokenNameLength   = 64
+	maxPersonalAccessTokensPerUser     = 50
+	// Last use time is a hint for the owner, so it isn't written on every request
+	personalAccessTokenTouchInterval = time.Minute
+)
+
+// Scopes personal access tokens may be granted. Gateway decides which routes each of them opens.
+const (
+	scopeProfileWrite = "profile:write"
+	scopeAccountRead  = "account:read"
+	scopeAdmin        = "admin"
+)
+
+var personalAccessTokenScopes = []string{scopeProfileWrite, scopeAccountRead, scopeAdmin}
+
+func generatePersonalAccessToken() (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return personalAccessTokenPrefix + token, nil
+}
+
+// checkPersonalAccessTokenScopes validates requested scopes and returns them sorted and deduplicated.
+func checkPersonalAccessTokenScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !slices.Contains(personalAccessTokenScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, expected one of %v", scope, strings.Join(personalAccessTokenScopes, ", "))
+		}
+		result = append(result, scope)
+	}
+
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+func checkPersonalAccessTokenName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("empty token name")
+	}
+	if len(name) > maxPersonalAccessTokenNameLength {
+		return errors.New("too long token name")
+	}
+	return nil
+}
+
+func personalAccessTokenToPb(token *storage.PersonalAccessToken) *pb.PersonalAccessToken {
+	result := &pb.PersonalAccessToken{
+		TokenId:        token.TokenId.String(),
+		Name:           token.Name,
+		Scopes:         token.Scopes,
+		CreationTime:   timestamppb.New(*token.CreationTime),
+		ExpirationTime: timestamppb.New(*token.ExpirationTime),
+	}
+	if token.LastUseTime != nil {
+		result.LastUseTime = timestamppb.New(*token.LastUseTime)
+	}
+	return result
+}
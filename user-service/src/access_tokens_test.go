@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckPersonalAccessTokenScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		scopes   []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:    "No scopes",
+			scopes:  nil,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown scope",
+			scopes:  []string{scopeProfileWrite, "everything"},
+			wantErr: true,
+		},
+		{
+			name:     "Duplicated scopes",
+			scopes:   []string{scopeProfileWrite, scopeAccountRead, scopeProfileWrite},
+			expected: []string{scopeAccountRead, scopeProfileWrite},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, err := checkPersonalAccessTokenScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPersonalAccessTokenScopes(%v) returned error %v, where error expected: %v", tt.scopes, err, tt.wantErr)
+			}
+			if !slices.Equal(scopes, tt.expected) {
+				t.Errorf("checkPersonalAccessTokenScopes(%v) returned %v, where %v expected", tt.scopes, scopes, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGeneratePersonalAccessToken(t *testing.T) {
+	first, err := generatePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := generatePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(first, personalAccessTokenPrefix) {
+		t.Errorf("token %v has no %v prefix", first, personalAccessTokenPrefix)
+	}
+	if first == second {
+		t.Errorf("two generated tokens are equal")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	shared "soa-project/shared/proto"
+	pb "soa-project/user-service/proto"
+	"soa-project/user-service/storage"
+)
+
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 200
+)
+
+// Page tokens are opaque for clients, but they are merely encoded keyset cursors.
+type usersPageToken struct {
+	Login  string `json:"l"`
+	UserId string `json:"i"`
+}
+
+func encodeUsersPageToken(user *storage.User) string {
+	data, _ := json.Marshal(usersPageToken{Login: user.Login, UserId: user.UserId.String()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUsersPageToken(token string) (*storage.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageToken usersPageToken
+	err = json.Unmarshal(data, &pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := &storage.UserCursor{Login: pageToken.Login}
+	err = cursor.UserId.UnmarshalText([]byte(pageToken.UserId))
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func usersPageSize(requested int32) int {
+	if requested <= 0 {
+		return defaultUsersPageSize
+	}
+	return min(int(requested), maxUsersPageSize)
+}
+
+func userSummary(user *storage.User, now time.Time) *pb.UserSummary {
+	summary := &pb.UserSummary{
+		Id:               &shared.Id{Uuid: user.UserId.String()},
+		Login:            user.Login,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Suspended:        user.IsSuspended(now),
+		SuspensionReason: user.SuspensionReason,
+	}
+	if user.SuspendedTime != nil {
+		summary.SuspendedTime = timestamppb.New(*user.SuspendedTime)
+	}
+	if user.SuspendedUntil != nil {
+		summary.SuspendedUntil = timestamppb.New(*user.SuspendedUntil)
+	}
+	return summary
+}
+
+// checkNotSuspended must be called before issuing any tokens to the user.
+func checkNotSuspended(user *storage.User, now time.Time) error {
+	if !user.IsSuspended(now) {
+		return nil
+	}
+	if user.SuspendedUntil != nil {
+		return status.Errorf(codes.PermissionDenied, "account is suspended until %v: %v", user.SuspendedUntil.UTC().Format(time.RFC3339), user.SuspensionReason)
+	}
+	return status.Errorf(codes.PermissionDenied, "account is suspended: %v", user.SuspensionReason)
+}
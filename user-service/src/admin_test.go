@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func TestUsersPageToken(t *testing.T) {
+	user := &storage.User{UserId: uuid.New(), Login: "user"}
+
+	cursor, err := decodeUsersPageToken(encodeUsersPageToken(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Login != user.Login || cursor.UserId != user.UserId {
+		t.Errorf("decoded cursor %+v doesn't match user %v (%v)", cursor, user.Login, user.UserId)
+	}
+
+	if _, err := decodeUsersPageToken("garbage"); err == nil {
+		t.Errorf("malformed page token was accepted")
+	}
+}
+
+func TestCheckNotSuspended(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name      string
+		user      storage.User
+		suspended bool
+	}{
+		{
+			name:      "Not suspended",
+			user:      storage.User{},
+			suspended: false,
+		},
+		{
+			name:      "Indefinitely",
+			user:      storage.User{SuspendedTime: at(-time.Hour)},
+			suspended: true,
+		},
+		{
+			name:      "Until future",
+			user:      storage.User{SuspendedTime: at(-time.Hour), SuspendedUntil: at(time.Hour)},
+			suspended: true,
+		},
+		{
+			name:      "Expired",
+			user:      storage.User{SuspendedTime: at(-time.Hour * 2), SuspendedUntil: at(-time.Hour)},
+			suspended: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNotSuspended(&tt.user, now)
+			if (err != nil) != tt.suspended {
+				t.Errorf("checkNotSuspended returned %v, where suspended is %v", err, tt.suspended)
+			}
+		})
+	}
+}
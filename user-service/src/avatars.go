@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	shared "soa-project/shared/proto"
+)
+
+const (
+	// Leaves room for the rest of the request within default 4MB limit of grpc messages
+	maxAvatarBytes = 3 << 20
+	// Compressed image may be small while decoded one is huge, so dimensions are limited too
+	maxAvatarDimension = 4096
+	avatarJpegQuality  = 90
+)
+
+// Every avatar is stored in these sizes, the largest first.
+var avatarSizes = []int{512, 128, 64}
+
+// Uploaded types mapped to types of stored images. GIF is stored as PNG, only its first frame is kept.
+var avatarContentTypes = map[string]string{
+	"image/jpeg": "image/jpeg",
+	"image/png":  "image/png",
+	"image/gif":  "image/png",
+}
+
+type avatarImage struct {
+	size int
+	data []byte
+}
+
+// decodeAvatar checks uploaded image and decodes it. Returned content type is the type
+// the image has to be stored in.
+func decodeAvatar(data []byte) (image.Image, string, error) {
+	if len(data) == 0 {
+		return nil, "", errors.New("empty image")
+	}
+	if len(data) > maxAvatarBytes {
+		return nil, "", fmt.Errorf("image is larger than %v bytes", maxAvatarBytes)
+	}
+
+	contentType, ok := avatarContentTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", errors.New("only jpeg, png and gif images are supported")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+	if config.Width == 0 || config.Height == 0 || config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		return nil, "", fmt.Errorf("image must be at most %vx%v pixels", maxAvatarDimension, maxAvatarDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	if contentType == "image/jpeg" {
+		img = orientImage(img, jpegOrientation(data))
+	}
+	return img, contentType, nil
+}
+
+// encodeAvatar crops the middle square of img and encodes it in every avatar size.
+// Images are encoded from pixels only, so metadata of the upload, such as EXIF, is dropped.
+func encodeAvatar(img image.Image, contentType string) ([]avatarImage, error) {
+	square := cropSquare(img)
+
+	images := make([]avatarImage, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		var err error
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, resizeSquare(square, size), &jpeg.Options{Quality: avatarJpegQuality})
+		} else {
+			err = png.Encode(&buf, resizeSquare(square, size))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %vpx image: %w", size, err)
+		}
+		images = append(images, avatarImage{size: size, data: buf.Bytes()})
+	}
+	return images, nil
+}
+
+func cropSquare(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// resizeSquare scales square image averaging source pixels covered by every resulting pixel.
+// Upscaling degrades to nearest neighbour, which is fine for tiny uploads.
+func resizeSquare(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	span := func(i int) (int, int) {
+		from, to := i*side/size, (i+1)*side/size
+		return from, max(to, from+1)
+	}
+
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range sum {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for c := range sum {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns value of EXIF orientation tag, or 1 (as is) if there is none.
+func jpegOrientation(data []byte) int {
+	const (
+		markerSos    = 0xda
+		markerApp1   = 0xe1
+		tagOrient    = 0x0112
+		ifdEntrySize = 12
+	)
+
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xff; {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == markerSos || length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		if marker != markerApp1 || !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			continue
+		}
+
+		tiff := segment[6:]
+		if len(tiff) < 8 {
+			return 1
+		}
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return 1
+		}
+
+		ifd := int(order.Uint32(tiff[4:]))
+		if ifd+2 > len(tiff) {
+			return 1
+		}
+		entries := int(order.Uint16(tiff[ifd:]))
+		for i := 0; i < entries; i++ {
+			entry := ifd + 2 + i*ifdEntrySize
+			if entry+ifdEntrySize > len(tiff) {
+				return 1
+			}
+			if order.Uint16(tiff[entry:]) == tagOrient {
+				orientation := int(order.Uint16(tiff[entry+8:]))
+				if orientation < 1 || orientation > 8 {
+					return 1
+				}
+				return orientation
+			}
+		}
+		return 1
+	}
+	return 1
+}
+
+// orientImage transforms img, so it is displayed upright without EXIF orientation tag.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation == 1 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// Source point for every point of the result
+	source := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return w - 1 - x, y },
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		4: func(x, y int) (int, int) { return x, h - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return y, h - 1 - x },
+		7: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x },
+		8: func(x, y int) (int, int) { return w - 1 - y, x },
+	}[orientation]
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// newAvatarId is random, so URL of the avatar can't be derived from the image by someone
+// the avatar is hidden from. Every upload gets new URLs.
+func newAvatarId() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func userAvatarsPrefix(userId uuid.UUID) string {
+	return fmt.Sprintf("avatars/%v/", userId)
+}
+
+func avatarPrefix(userId uuid.UUID, avatarId string) string {
+	return fmt.Sprintf("%v%v/", userAvatarsPrefix(userId), avatarId)
+}
+
+func avatarKey(userId uuid.UUID, avatarId string, size int) string {
+	return fmt.Sprintf("%v%d", avatarPrefix(userId, avatarId), size)
+}
+
+// avatarsToPb returns URLs of every size of the avatar, gateway serves them at /avatars.
+func (s UserService) avatarsToPb(userId uuid.UUID, avatarId string) []*shared.Avatar {
+	if avatarId == "" {
+		return nil
+	}
+
+	avatars := make([]*shared.Avatar, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		avatars = append(avatars, &shared.Avatar{
+			Size: int32(size),
+			Url:  fmt.Sprintf("%v%v/%v/%d", s.avatarUrl, userId, avatarId, size),
+		})
+	}
+	return avatars
+}
+
+// discardAvatar removes images of the avatar which is no longer referenced.
+// Failure only leaves garbage in the blob store, so it is logged and ignored.
+func (s UserService) discardAvatar(ctx context.Context, userId uuid.UUID, avatarId string) {
+	err := s.blobs.DeletePrefix(ctx, avatarPrefix(userId, avatarId))
+	if err != nil {
+		log.Printf("failed to delete avatar %v of user %v: %v", avatarId, userId, err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// halves returns image, left half of which is red and right half is blue.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, img image.Image, format string) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation inserts EXIF segment with orientation tag right after SOI marker of jpeg.
+func withOrientation(data []byte, orientation byte) []byte {
+	exif := []byte{
+		0xff, 0xe1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	result := append([]byte{}, data[:2]...)
+	result = append(result, exif...)
+	return append(result, data[2:]...)
+}
+
+func TestDecodeAvatar(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		wantErr     bool
+	}{
+		{
+			name:        "Png",
+			data:        encodeTestImage(t, halves(10, 10), "png"),
+			contentType: "image/png",
+		},
+		{
+			name:        "Jpeg",
+			data:        encodeTestImage(t, halves(10, 10), "jpeg"),
+			contentType: "image/jpeg",
+		},
+		{
+			name:        "Gif is stored as png",
+			data:        encodeTestImage(t, halves(10, 10), "gif"),
+			contentType: "image/png",
+		},
+		{
+			name:    "Empty",
+			data:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Not an image",
+			data:    []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"),
+			wantErr: true,
+		},
+		{
+			name:    "Truncated",
+			data:    encodeTestImage(t, halves(10, 10), "png")[:40],
+			wantErr: true,
+		},
+		{
+			name:    "Too wide",
+			data:    encodeTestImage(t, image.NewGray(image.Rect(0, 0, maxAvatarDimension+1, 1)), "png"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, contentType, err := decodeAvatar(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeAvatar returned %v, where error expected: %v", err, tt.wantErr)
+			}
+			if contentType != tt.contentType {
+				t.Errorf("decodeAvatar returned %v, where %v expected", contentType, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestEncodeAvatar(t *testing.T) {
+	data := withOrientation(encodeTestImage(t, halves(300, 200), "jpeg"), 1)
+	img, contentType, err := decodeAvatar(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := encodeAvatar(img, contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != len(avatarSizes) {
+		t.Fatalf("encodeAvatar returned %v images, where %v expected", len(images), len(avatarSizes))
+	}
+
+	for i, encoded := range images {
+		if bytes.Contains(encoded.data, []byte("Exif")) {
+			t.Errorf("%vpx image keeps EXIF metadata", encoded.size)
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(encoded.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != "jpeg" || encoded.size != avatarSizes[i] || config.Width != avatarSizes[i] || config.Height != avatarSizes[i] {
+			t.Errorf("image %v is %v %vx%v, where jpeg %vx%v expected", i, format, config.Width, config.Height, avatarSizes[i], avatarSizes[i])
+		}
+	}
+}
+
+func TestJpegOrientation(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected int
+		// Color expected across the top of the upright image
+		top color.RGBA
+	}{
+		{
+			name:     "Without exif",
+			data:     encodeTestImage(t, halves(64, 64), "jpeg"),
+			expected: 1,
+		},
+		{
+			name:     "Rotated clockwise",
+			data:     withOrientation(encodeTestImage(t, halves(64, 64), "jpeg"), 6),
+			expected: 6,
+			top:      color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:     "Rotated counterclockwise",
+			data:     withOrientation(encodeTestImage(t, halves(64, 64), "jpeg"), 8),
+			expected: 8,
+			top:      color.RGBA{B: 255, A: 255},
+		},
+		{
+			name:     "Invalid value",
+			data:     withOrientation(encodeTestImage(t, halves(64, 64), "jpeg"), 9),
+			expected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orientation := jpegOrientation(tt.data)
+			if orientation != tt.expected {
+				t.Fatalf("jpegOrientation returned %v, where %v expected", orientation, tt.expected)
+			}
+			if tt.expected == 1 {
+				return
+			}
+
+			img, _, err := decodeAvatar(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, x := range []int{16, 48} {
+				r, g, b, _ := img.At(x, 4).RGBA()
+				got := color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}
+				if !closeColors(got, tt.top) {
+					t.Errorf("upright image has %v at (%v, 4), where %v expected", got, x, tt.top)
+				}
+			}
+		})
+	}
+}
+
+func closeColors(a, b color.RGBA) bool {
+	near := func(x, y uint8) bool { return x-y < 32 || y-x < 32 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B)
+}
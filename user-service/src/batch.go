@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+
+	shared "soa-project/shared/proto"
+)
+
+// Ids accepted by one BatchGetUsers or BatchGetProfiles call
+const maxBatchSize = 100
+
+// parseBatchIds returns ids of the batch request without duplicates, in the order of the request.
+func parseBatchIds(ids []*shared.Id) ([]uuid.UUID, error) {
+	result := make([]uuid.UUID, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		userId, err := uuid.Parse(id.GetUuid())
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q: %w", id.GetUuid(), err)
+		}
+		if !seen[userId] {
+			seen[userId] = true
+			result = append(result, userId)
+		}
+	}
+	return result, nil
+}
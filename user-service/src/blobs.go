@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var errNoSuchBlob = errors.New("no blob were found")
+
+// BlobStore keeps binary objects, such as images, under slash separated keys.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns errNoSuchBlob if there is nothing under the key
+	Get(ctx context.Context, key string) ([]byte, error)
+	// DeletePrefix removes every blob under prefix, which must end with a slash
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// DirectoryBlobStore keeps every blob as a file in the directory, keys are relative paths.
+type DirectoryBlobStore struct {
+	dir string
+}
+
+func NewDirectoryBlobStore(dir string) (*DirectoryBlobStore, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+	return &DirectoryBlobStore{dir: dir}, nil
+}
+
+// path returns file of the key. Keys never leave the directory, since ".." elements are rejected.
+func (s *DirectoryBlobStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *DirectoryBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(file)
+	err = os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	return writeFileAtomically(dir, filepath.Base(file), data)
+}
+
+func (s *DirectoryBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNoSuchBlob
+	}
+	return data, err
+}
+
+func (s *DirectoryBlobStore) DeletePrefix(ctx context.Context, prefix string) error {
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("blob prefix %q doesn't end with a slash", prefix)
+	}
+
+	dir, err := s.path(path.Clean(prefix))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
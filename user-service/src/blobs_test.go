@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDirectoryBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDirectoryBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"avatars/user/first/64", "avatars/user/second/64"} {
+		err = store.Put(ctx, key, []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := store.Get(ctx, "avatars/user/first/64")
+	if err != nil || string(data) != "avatars/user/first/64" {
+		t.Errorf("Get returned %q, %v, where stored blob expected", data, err)
+	}
+
+	err = store.DeletePrefix(ctx, "avatars/user/first/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "avatars/user/first/64"); err != errNoSuchBlob {
+		t.Errorf("Get of deleted blob returned %v, where %v expected", err, errNoSuchBlob)
+	}
+	if _, err := store.Get(ctx, "avatars/user/second/64"); err != nil {
+		t.Errorf("Get of blob outside of deleted prefix returned %v", err)
+	}
+
+	invalid := []string{"../outside", "/absolute", "avatars/../../outside", ""}
+	for _, key := range invalid {
+		if err := store.Put(ctx, key, nil); err == nil {
+			t.Errorf("Put accepted invalid key %q", key)
+		}
+	}
+	if err := store.DeletePrefix(ctx, "avatars/user"); err == nil {
+		t.Errorf("DeletePrefix accepted prefix without trailing slash")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultDeletionGracePeriod = time.Hour * 24 * 30
+
+	purgeBatchSize = 100
+)
+
+type userDeletedPayload struct {
+	UserId      string    `json:"user_id"`
+	PurgingTime time.Time `json:"purging_time"`
+}
+
+// purgeDeletedAccounts removes a batch of accounts deleted more than grace period ago
+// and reports how many were removed.
+func (s UserService) purgeDeletedAccounts(ctx context.Context, now time.Time) (int, error) {
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userIds, err := tx.ListDeletedUsers(ctx, now.Add(-s.deletionGracePeriod), purgeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list deleted users: %w", err)
+	}
+
+	for _, userId := range userIds {
+		err = tx.PurgeUser(ctx, userId)
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge user %v: %w", userId, err)
+		}
+
+		err = insertEvent(ctx, tx, eventKindUserDeleted, userDeletedPayload{UserId: userId.String(), PurgingTime: now}, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert event: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	// Blobs aren't covered by tx, leftovers of a failed deletion are only garbage
+	for _, userId := range userIds {
+		err = s.blobs.DeletePrefix(ctx, userAvatarsPrefix(userId))
+		if err != nil {
+			log.Printf("failed to delete avatars of purged user %v: %v\n", userId, err)
+		}
+	}
+
+	return len(userIds), nil
+}
+
+func (s UserService) RunAccountPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.purgeDeletedAccounts(ctx, time.Now())
+		if err != nil {
+			log.Printf("failed to purge deleted accounts: %v\n", err)
+		} else if purged > 0 {
+			log.Printf("purged %v deleted accounts\n", purged)
+		}
+		if err == nil && purged == purgeBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
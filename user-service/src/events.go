@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+const (
+	eventKindUserDeleted = "user_deleted"
+
+	eventRelayBatchSize = 100
+)
+
+type Event struct {
+	EventId      uuid.UUID       `json:"event_id"`
+	Kind         string          `json:"kind"`
+	CreationTime time.Time       `json:"creation_time"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+// EventPublisher delivers events to other services. Delivery is at least once,
+// so consumers must tolerate duplicates by event_id.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// DirectoryPublisher stores every event as json file in the directory, which is
+// meant to be shipped to a broker by an external agent.
+type DirectoryPublisher struct {
+	dir string
+}
+
+func NewDirectoryPublisher(dir string) (*DirectoryPublisher, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create events directory: %w", err)
+	}
+	return &DirectoryPublisher{dir: dir}, nil
+}
+
+func (p *DirectoryPublisher) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json", event.CreationTime.UTC().Format("20060102T150405"), event.Kind, event.EventId)
+	err = writeFileAtomically(p.dir, name, data)
+	if err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+
+	return nil
+}
+
+// insertEvent puts event to the outbox within tx, it is published after tx is committed.
+func insertEvent(ctx context.Context, tx storage.Transaction, kind string, payload any, now time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event payload: %w", err)
+	}
+
+	eventId, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to generate uuid: %w", err)
+	}
+
+	return tx.InsertEvent(ctx, storage.Event{EventId: eventId, Kind: kind, Payload: data, CreationTime: &now})
+}
+
+// relayEvents publishes a batch of events from the outbox and reports how many were published.
+func (s UserService) relayEvents(ctx context.Context) (int, error) {
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	events, err := tx.ListEvents(ctx, eventRelayBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	published := 0
+	var publishErr error
+	for _, event := range events {
+		publishErr = s.publisher.Publish(ctx, Event{
+			EventId:      event.EventId,
+			Kind:         event.Kind,
+			CreationTime: *event.CreationTime,
+			Payload:      event.Payload,
+		})
+		if publishErr != nil {
+			break
+		}
+
+		err = tx.DeleteEvent(ctx, event.EventId)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete event: %w", err)
+		}
+		published++
+	}
+
+	// Published events are removed even if some later event failed, the rest is retried
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	if publishErr != nil {
+		return published, fmt.Errorf("failed to publish event: %w", publishErr)
+	}
+	return published, nil
+}
+
+func (s UserService) RunEventRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := s.relayEvents(ctx)
+		if err != nil {
+			log.Printf("failed to relay events: %v\n", err)
+		}
+		// Full batch means there are likely more events waiting
+		if err == nil && published == eventRelayBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
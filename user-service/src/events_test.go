@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDirectoryPublisher(t *testing.T) {
+	dir := t.TempDir()
+	publisher, err := NewDirectoryPublisher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := Event{
+		EventId:      uuid.New(),
+		Kind:         eventKindUserDeleted,
+		CreationTime: time.Now(),
+		Payload:      json.RawMessage(`{"user_id":"id"}`),
+	}
+	err = publisher.Publish(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("directory contains %v events, where 1 expected", len(files))
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published Event
+	err = json.Unmarshal(content, &published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published.EventId != event.EventId || published.Kind != event.Kind || string(published.Payload) != string(event.Payload) {
+		t.Errorf("unexpected event content: %s", content)
+	}
+}
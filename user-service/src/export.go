@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"soa-project/user-service/storage"
+)
+
+// Version of the export archive layout. It must be increased on every incompatible change
+// of existing sections, new sections may be added without it.
+const exportFormatVersion = 1
+
+type exportArchive struct {
+	FormatVersion int                        `json:"format_version"`
+	ExportTime    time.Time                  `json:"export_time"`
+	UserId        string                     `json:"user_id"`
+	Sections      map[string]json.RawMessage `json:"sections"`
+}
+
+// exportSection collects one part of the user's data. Every table holding personal data
+// must be covered by some section. Sections judge expiration by now, the time of the export.
+type exportSection struct {
+	name    string
+	collect func(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error)
+}
+
+var exportSections = []exportSection{
+	{name: "account", collect: exportAccount},
+	{name: "profile", collect: exportProfile},
+	{name: "profile_history", collect: exportProfileHistory},
+	{name: "roles", collect: exportRoles},
+	{name: "second_factor", collect: exportSecondFactor},
+	{name: "sessions", collect: exportSessions},
+	{name: "personal_access_tokens", collect: exportPersonalAccessTokens},
+}
+
+// buildExportArchive collects all sections within tx, which is expected to be a snapshot,
+// so sections are consistent with each other.
+func buildExportArchive(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) ([]byte, error) {
+	archive := exportArchive{
+		FormatVersion: exportFormatVersion,
+		ExportTime:    now.UTC(),
+		UserId:        user.UserId.String(),
+		Sections:      make(map[string]json.RawMessage, len(exportSections)),
+	}
+
+	for _, section := range exportSections {
+		data, err := section.collect(ctx, tx, user, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %v: %w", section.name, err)
+		}
+
+		archive.Sections[section.name], err = json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %v: %w", section.name, err)
+		}
+	}
+
+	return json.MarshalIndent(archive, "", "  ")
+}
+
+func exportAccount(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	// Password hash is deliberately left out: it is not useful for the user, but is sensitive
+	type account struct {
+		Login            string     `json:"login"`
+		Email            string     `json:"email"`
+		EmailVerified    bool       `json:"email_verified"`
+		SuspendedTime    *time.Time `json:"suspended_time,omitempty"`
+		SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`
+		SuspensionReason string     `json:"suspension_reason,omitempty"`
+	}
+
+	return account{
+		Login:            user.Login,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		SuspendedTime:    user.SuspendedTime,
+		SuspendedUntil:   user.SuspendedUntil,
+		SuspensionReason: user.SuspensionReason,
+	}, nil
+}
+
+func exportProfile(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	type profile struct {
+		Name           string     `json:"name"`
+		Surname        string     `json:"surname"`
+		PhoneNumber    string     `json:"phone_number"`
+		Birthday       string     `json:"birthday,omitempty"`
+		CreationTime   *time.Time `json:"creation_time"`
+		LastUpdateTime *time.Time `json:"last_update_time"`
+		// Images are served at /avatars/<user id>/<avatar id>/<size>
+		AvatarId   string            `json:"avatar_id,omitempty"`
+		Visibility map[string]string `json:"visibility"`
+	}
+
+	p, err := tx.FindProfileByUserId(ctx, user.UserId)
+	if err == storage.ErrNoSuchUser {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := profile{
+		Name:           p.Name,
+		Surname:        p.Surname,
+		PhoneNumber:    p.PhoneNumber,
+		CreationTime:   p.CreationTime,
+		LastUpdateTime: p.LastUpdateTime,
+		AvatarId:       p.AvatarId,
+		Visibility: map[string]string{
+			"name":         string(p.Visibility.Name),
+			"surname":      string(p.Visibility.Surname),
+			"phone_number": string(p.Visibility.PhoneNumber),
+			"birthday":     string(p.Visibility.BirthDay),
+			"avatars":      string(p.Visibility.Avatar),
+		},
+	}
+	if p.BirthDay != nil {
+		result.Birthday = p.BirthDay.Format(time.DateOnly)
+	}
+	return result, nil
+}
+
+func exportProfileHistory(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	type fieldChange struct {
+		Field    string `json:"field"`
+		OldValue string `json:"old_value"`
+		NewValue string `json:"new_value"`
+	}
+	type profileChange struct {
+		ActorId    string        `json:"actor_id"`
+		ChangeTime *time.Time    `json:"change_time"`
+		Ip         string        `json:"ip"`
+		UserAgent  string        `json:"user_agent"`
+		Fields     []fieldChange `json:"fields"`
+	}
+
+	result := []profileChange{}
+	var after *storage.ProfileChangeCursor
+	for {
+		changes, err := tx.ListProfileChanges(ctx, user.UserId, after, maxProfileHistoryPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range changes {
+			change := profileChange{
+				ActorId:    c.ActorId.String(),
+				ChangeTime: c.ChangeTime,
+				Ip:         c.Ip,
+				UserAgent:  c.UserAgent,
+			}
+			for _, f := range c.Fields {
+				change.Fields = append(change.Fields, fieldChange{Field: f.Field, OldValue: f.OldValue, NewValue: f.NewValue})
+			}
+			result = append(result, change)
+		}
+
+		if len(changes) < maxProfileHistoryPageSize {
+			return result, nil
+		}
+		last := changes[len(changes)-1]
+		after = &storage.ProfileChangeCursor{ChangeTime: *last.ChangeTime, ChangeId: last.ChangeId}
+	}
+}
+
+func exportRoles(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	roles, err := tx.ListUserRoles(ctx, user.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.RoleName)
+	}
+	return names, nil
+}
+
+func exportSecondFactor(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	// Secret and recovery codes themselves are credentials, only the fact of their existence is exported
+	type secondFactor struct {
+		TotpEnabled         bool       `json:"totp_enabled"`
+		TotpCreationTime    *time.Time `json:"totp_creation_time,omitempty"`
+		UnusedRecoveryCodes int        `json:"unused_recovery_codes"`
+	}
+
+	result := secondFactor{}
+	secret, err := tx.FindTotpSecretByUserId(ctx, user.UserId)
+	if err != nil && err != storage.ErrNoSuchTotpSecret {
+		return nil, err
+	}
+	if secret != nil && secret.Confirmed {
+		result.TotpEnabled = true
+		result.TotpCreationTime = secret.CreationTime
+	}
+
+	result.UnusedRecoveryCodes, err = tx.CountUnusedRecoveryCodes(ctx, user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func exportSessions(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	type session struct {
+		SessionId    string     `json:"session_id"`
+		CreationTime *time.Time `json:"creation_time"`
+		LastUseTime  *time.Time `json:"last_use_time"`
+		UserAgent    string     `json:"user_agent"`
+		Ip           string     `json:"ip"`
+	}
+
+	sessions, err := tx.ListUserSessions(ctx, user.UserId, now.Add(-refreshTokenLifetime))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]session, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, session{
+			SessionId:    s.SessionId.String(),
+			CreationTime: s.CreationTime,
+			LastUseTime:  s.LastUseTime,
+			UserAgent:    s.UserAgent,
+			Ip:           s.Ip,
+		})
+	}
+	return result, nil
+}
+
+func exportPersonalAccessTokens(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (any, error) {
+	type personalAccessToken struct {
+		TokenId        string     `json:"token_id"`
+		Name           string     `json:"name"`
+		Scopes         []string   `json:"scopes"`
+		CreationTime   *time.Time `json:"creation_time"`
+		ExpirationTime *time.Time `json:"expiration_time"`
+		LastUseTime    *time.Time `json:"last_use_time,omitempty"`
+	}
+
+	tokens, err := tx.ListUserPersonalAccessTokens(ctx, user.UserId, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]personalAccessToken, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, personalAccessToken{
+			TokenId:        t.TokenId.String(),
+			Name:           t.Name,
+			Scopes:         t.Scopes,
+			CreationTime:   t.CreationTime,
+			ExpirationTime: t.ExpirationTime,
+			LastUseTime:    t.LastUseTime,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	shared "soa-project/shared/proto"
+	pb "soa-project/user-service/proto"
+	"soa-project/user-service/storage"
+)
+
+func TestExportAccountOmitsCredentials(t *testing.T) {
+	user := &storage.User{
+		UserId:         uuid.New(),
+		Login:          "user",
+		Email:          "user@example.com",
+		HashedPassword: []byte("secret-hash"),
+		PasswordScheme: passwordSchemeBcrypt,
+	}
+
+	account, err := exportAccount(context.Background(), nil, user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"login":"user"`) {
+		t.Errorf("account section misses login: %s", data)
+	}
+	if strings.Contains(string(data), "password") || strings.Contains(string(data), "c2VjcmV0LWhhc2g") {
+		t.Errorf("account section contains credentials: %s", data)
+	}
+}
+
+func TestExportPersonalAccessTokens(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+
+	expirationTime := time.Now().Add(time.Hour)
+	_, err := s.CreatePersonalAccessToken(ctx, &pb.CreatePersonalAccessTokenRequest{
+		Id:             id,
+		Name:           "ci",
+		Scopes:         []string{scopeAccountRead},
+		ExpirationTime: timestamppb.New(expirationTime),
+	})
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken returned %v", err)
+	}
+
+	tx, err := s.storage.BeginSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	user, err := tx.FindUserById(ctx, uuid.MustParse(id.Uuid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := exportPersonalAccessTokens(ctx, tx, user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"name":"ci"`) || strings.Contains(string(data), "last_use_time") {
+		t.Errorf("personal_access_tokens section is %s, where never used token without last_use_time expected", data)
+	}
+
+	// Expiration is judged by the time of the export
+	tokens, err = exportPersonalAccessTokens(ctx, tx, user, expirationTime.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("personal_access_tokens section exported after expiration is %s, where [] expected", data)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	pb "soa-project/user-service/proto"
+)
+
+type verificationKey struct {
+	kid    string
+	public *rsa.PublicKey
+}
+
+// JwtManager signs tokens with the active key and verifies them with any key of the ring.
+// Retiring keys are kept only for verification of tokens issued before rotation, so they
+// may be provided as public keys.
+type JwtManager struct {
+	activeKid string
+	active    *rsa.PrivateKey
+	keys      []verificationKey
+}
+
+func newJwtManager(active *rsa.PrivateKey, retiring []*rsa.PublicKey) *JwtManager {
+	activeKid := keyId(&active.PublicKey)
+	manager := &JwtManager{
+		activeKid: activeKid,
+		active:    active,
+		keys:      []verificationKey{{kid: activeKid, public: &active.PublicKey}},
+	}
+	for _, public := range retiring {
+		kid := keyId(public)
+		if kid == activeKid {
+			continue
+		}
+		manager.keys = append(manager.keys, verificationKey{kid: kid, public: public})
+	}
+	return manager
+}
+
+func NewJwtManager(activeFile string, retiringFiles []string) (*JwtManager, error) {
+	pem, err := os.ReadFile(activeFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read active key file: %w", err)
+	}
+	active, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse active key: %w", err)
+	}
+
+	var retiring []*rsa.PublicKey
+	for _, file := range retiringFiles {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read retiring key file %v: %w", file, err)
+		}
+
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+			retiring = append(retiring, &private.PublicKey)
+			continue
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse retiring key %v: %w", file, err)
+		}
+		retiring = append(retiring, public)
+	}
+
+	return newJwtManager(active, retiring), nil
+}
+
+func (m *JwtManager) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.activeKid
+	return token.SignedString(m.active)
+}
+
+func (m *JwtManager) verificationKey(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no kid")
+	}
+	for _, key := range m.keys {
+		if key.kid == kid {
+			return key.public, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kid: %v", kid)
+}
+
+func (m *JwtManager) jwks() []*pb.Jwk {
+	jwks := make([]*pb.Jwk, 0, len(m.keys))
+	for _, key := range m.keys {
+		jwks = append(jwks, &pb.Jwk{
+			Kty: "RSA",
+			Kid: key.kid,
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(key.public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.public.E)).Bytes()),
+		})
+	}
+	return jwks
+}
+
+// keyId computes RFC 7638 thumbprint of the key, so kid doesn't need to be configured.
+func keyId(public *rsa.PublicKey) string {
+	thumbprintInput, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+	})
+	thumbprint := sha256.Sum256(thumbprintInput)
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
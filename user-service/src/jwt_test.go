@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func TestJwtManagerRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := newJwtManager(oldKey, nil)
+	after := newJwtManager(newKey, []*rsa.PublicKey{&oldKey.PublicKey})
+	retired := newJwtManager(newKey, nil)
+
+	token, err := before.issueAccessToken(&storage.User{UserId: uuid.New()}, nil, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := after.parseAccessToken(token); err != nil {
+		t.Errorf("token signed with retiring key was rejected: %v", err)
+	}
+	if _, err := retired.parseAccessToken(token); err == nil {
+		t.Errorf("token signed with removed key was accepted")
+	}
+	if len(after.jwks()) != 2 {
+		t.Errorf("jwks contains %v keys, where 2 expected", len(after.jwks()))
+	}
+}
+
+func TestActionTokenPurpose(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := newJwtManager(key, nil)
+	user := &storage.User{UserId: uuid.New(), Email: "user@example.com"}
+
+	actionToken, err := manager.issueActionToken(purposeEmailVerification, user.UserId, user.Email, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := manager.issueAccessToken(user, nil, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manager.parseActionToken(actionToken, purposeEmailVerification); err != nil {
+		t.Errorf("action token was rejected: %v", err)
+	}
+	if _, err := manager.parseActionToken(actionToken, "other"); err == nil {
+		t.Errorf("action token was accepted for other purpose")
+	}
+	if _, err := manager.parseAccessToken(actionToken); err == nil {
+		t.Errorf("action token was accepted as access token")
+	}
+	if _, err := manager.parseActionToken(accessToken, purposeEmailVerification); err == nil {
+		t.Errorf("access token was accepted as action token")
+	}
+}
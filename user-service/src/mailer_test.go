@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutboxMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewOutboxMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Subject", Body: "Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), Message{To: "user@example.com\r\nBcc: other@example.com", Subject: "Subject", Body: "Body"})
+	if err == nil {
+		t.Errorf("message with line break in header was sent")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("outbox contains %v messages, where 1 expected", len(files))
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "To: user@example.com\r\n") || !strings.HasSuffix(string(content), "\r\n\r\nBody") {
+		t.Errorf("unexpected message content: %q", content)
+	}
+}
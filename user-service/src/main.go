@@ -1,24 +1,53 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 
 	pb "soa-project/user-service/proto"
 )
 
+func getIntEnv(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%v must be an integer: %v", name, err)
+	}
+	return result
+}
+
 func main() {
 	jwtPrivateFile := os.Getenv("JWT_PRIVATE")
+	jwtRetiringFiles := os.Getenv("JWT_RETIRING")
 	grpcAddr := os.Getenv("GRPC_ADDR")
 	databaseUrl := os.Getenv("DATABASE_ADDR")
 
 	log.Printf("GRPC_ADDR: `%v`\n", grpcAddr)
 	log.Printf("DATABASE_ADDR: `%v`\n", databaseUrl)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if databaseUrl == "" {
+			log.Fatal("database url not provided")
+		}
+		err := runMigrate(context.Background(), databaseUrl, os.Args[2:])
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
 	if jwtPrivateFile == "" {
 		log.Fatalf("jwt private key file not provided")
 	}
@@ -28,6 +57,18 @@ func main() {
 		log.Fatalf("failed to obtain absolute path to private file: %v", err)
 	}
 
+	var absoluteRetiringFiles []string
+	for _, file := range strings.Split(jwtRetiringFiles, ",") {
+		if file == "" {
+			continue
+		}
+		absoluteFile, err := filepath.Abs(file)
+		if err != nil {
+			log.Fatalf("failed to obtain absolute path to retiring key file: %v", err)
+		}
+		absoluteRetiringFiles = append(absoluteRetiringFiles, absoluteFile)
+	}
+
 	if grpcAddr == "" {
 		log.Fatal("grpc address not provided")
 	}
@@ -36,11 +77,88 @@ func main() {
 		log.Fatal("database url not provided")
 	}
 
-	userService, err := NewUserService(absolutePrivateFile, databaseUrl)
+	passwordScheme := os.Getenv("PASSWORD_HASH_SCHEME")
+	if passwordScheme == "" {
+		passwordScheme = passwordSchemeBcrypt
+	}
+	argon2Params := DefaultArgon2Params
+	argon2Params.Memory = uint32(getIntEnv("PASSWORD_ARGON2_MEMORY", int(argon2Params.Memory)))
+	argon2Params.Iterations = uint32(getIntEnv("PASSWORD_ARGON2_ITERATIONS", int(argon2Params.Iterations)))
+	argon2Params.Parallelism = uint8(getIntEnv("PASSWORD_ARGON2_PARALLELISM", int(argon2Params.Parallelism)))
+
+	passwordHasher, err := NewPasswordHasher(passwordScheme, getIntEnv("PASSWORD_BCRYPT_COST", bcrypt.DefaultCost), argon2Params)
+	if err != nil {
+		log.Fatalf("failed to configure password hashing: %v", err)
+	}
+
+	mailOutboxDir := os.Getenv("MAIL_OUTBOX_DIR")
+	if mailOutboxDir == "" {
+		mailOutboxDir = "outbox"
+	}
+	mailer, err := NewOutboxMailer(mailOutboxDir)
+	if err != nil {
+		log.Fatalf("failed to create mailer: %v", err)
+	}
+
+	eventsOutboxDir := os.Getenv("EVENTS_OUTBOX_DIR")
+	if eventsOutboxDir == "" {
+		eventsOutboxDir = "events"
+	}
+	publisher, err := NewDirectoryPublisher(eventsOutboxDir)
+	if err != nil {
+		log.Fatalf("failed to create event publisher: %v", err)
+	}
+
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "blobs"
+	}
+	blobStore, err := NewDirectoryBlobStore(blobDir)
+	if err != nil {
+		log.Fatalf("failed to create blob store: %v", err)
+	}
+
+	// Relative by default, since avatars are served by the gateway the client talks to
+	avatarUrl := os.Getenv("AVATAR_URL")
+	if avatarUrl == "" {
+		avatarUrl = "/avatars/"
+	}
+
+	deletionGracePeriod := defaultDeletionGracePeriod
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); value != "" {
+		deletionGracePeriod, err = time.ParseDuration(value)
+		if err != nil || deletionGracePeriod < 0 {
+			log.Fatalf("ACCOUNT_DELETION_GRACE_PERIOD must be a non-negative duration: %v", value)
+		}
+	}
+
+	userService, err := NewUserService(Config{
+		JwtPrivateFile:       absolutePrivateFile,
+		JwtRetiringFiles:     absoluteRetiringFiles,
+		DatabaseUrl:          databaseUrl,
+		PasswordHasher:       passwordHasher,
+		Mailer:               mailer,
+		PasswordResetUrl:     os.Getenv("PASSWORD_RESET_URL"),
+		EmailVerificationUrl: os.Getenv("EMAIL_VERIFICATION_URL"),
+		EventPublisher:       publisher,
+		BlobStore:            blobStore,
+		AvatarUrl:            avatarUrl,
+		DeletionGracePeriod:  deletionGracePeriod,
+	})
 	if err != nil {
 		log.Fatalf("failed to create service: %v", err)
 	}
 
+	if bootstrapAdmin := os.Getenv("BOOTSTRAP_ADMIN"); bootstrapAdmin != "" {
+		err = userService.BootstrapAdmin(context.Background(), bootstrapAdmin)
+		if err != nil {
+			log.Printf("failed to bootstrap admin: %v\n", err)
+		}
+	}
+
+	go userService.RunAccountPurge(context.Background(), time.Minute*10)
+	go userService.RunEventRelay(context.Background(), time.Second*5)
+
 	lis, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys the gateway uses to forward information about the client.
+const (
+	clientIpMetadataKey        = "x-client-ip"
+	clientUserAgentMetadataKey = "x-client-user-agent"
+	// Authenticated user on whose request the call is made
+	actorIdMetadataKey = "x-actor-id"
+
+	maxUserAgentLength = 512
+)
+
+func incomingMetadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
+
+func clientIp(ctx context.Context) string {
+	return incomingMetadataValue(ctx, clientIpMetadataKey)
+}
+
+func clientUserAgent(ctx context.Context) string {
+	userAgent := incomingMetadataValue(ctx, clientUserAgentMetadataKey)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// actorId returns the user the call is made for. Calls that don't name one are
+// considered to be made by the owner of the data.
+func actorId(ctx context.Context, owner uuid.UUID) uuid.UUID {
+	actor, err := uuid.Parse(incomingMetadataValue(ctx, actorIdMetadataKey))
+	if err != nil {
+		return owner
+	}
+	return actor
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"soa-project/user-service/storage"
+)
+
+const migrateUsage = "usage: user-service migrate up|down [steps]|status"
+
+// runMigrate implements migrate subcommand. Unlike the service, it works with database
+// of any schema version.
+func runMigrate(ctx context.Context, databaseUrl string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	store, err := storage.Connect(databaseUrl)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		applied, err := store.MigrateUp(ctx)
+		for _, migration := range applied {
+			log.Printf("applied migration %04d_%v\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Printf("schema is up to date\n")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive integer: %v", args[1])
+			}
+		} else if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		rolledBack, err := store.MigrateDown(ctx, steps)
+		for _, migration := range rolledBack {
+			log.Printf("rolled back migration %04d_%v\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		statuses, err := store.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedTime != nil {
+				applied = "applied " + status.AppliedTime.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%v\t%v\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"soa-project/user-service/storage"
+)
+
+const (
+	// Hashes created before user-service owned hashing: bcrypt over md5(password + login),
+	// where md5 was computed by the api gateway.
+	passwordSchemeLegacy   = "md5-bcrypt"
+	passwordSchemeBcrypt   = "bcrypt"
+	passwordSchemeArgon2id = "argon2id"
+)
+
+var errPasswordMismatch = errors.New("password mismatch")
+
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// PasswordHasher hashes new passwords with the configured scheme and verifies passwords
+// hashed with any scheme ever used by the service.
+type PasswordHasher struct {
+	scheme     string
+	bcryptCost int
+	argon2     Argon2Params
+}
+
+func NewPasswordHasher(scheme string, bcryptCost int, argon2 Argon2Params) (*PasswordHasher, error) {
+	switch scheme {
+	case passwordSchemeBcrypt:
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be in range [%v, %v]", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case passwordSchemeArgon2id:
+		if argon2.Memory == 0 || argon2.Iterations == 0 || argon2.Parallelism == 0 || argon2.SaltLength == 0 || argon2.KeyLength == 0 {
+			return nil, errors.New("argon2id parameters must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hashing scheme: %v", scheme)
+	}
+
+	return &PasswordHasher{scheme: scheme, bcryptCost: bcryptCost, argon2: argon2}, nil
+}
+
+// Hash returns hash of the password and the scheme it was produced with.
+func (h *PasswordHasher) Hash(password string) ([]byte, string, error) {
+	switch h.scheme {
+	case passwordSchemeBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return hash, h.scheme, err
+	case passwordSchemeArgon2id:
+		salt := make([]byte, h.argon2.SaltLength)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism, h.argon2.KeyLength)
+		return []byte(encodeArgon2id(h.argon2, salt, key)), h.scheme, nil
+	default:
+		return nil, "", fmt.Errorf("unknown password hashing scheme: %v", h.scheme)
+	}
+}
+
+// Verify returns errPasswordMismatch if password doesn't match the one stored for the user.
+func (h *PasswordHasher) Verify(user *storage.User, password string) error {
+	switch user.PasswordScheme {
+	case passwordSchemeLegacy:
+		preHashed := md5.Sum([]byte(password + user.Login))
+		err := bcrypt.CompareHashAndPassword(user.HashedPassword, preHashed[:])
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return errPasswordMismatch
+		}
+		return err
+	case passwordSchemeBcrypt:
+		err := bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return errPasswordMismatch
+		}
+		return err
+	case passwordSchemeArgon2id:
+		params, salt, key, err := decodeArgon2id(string(user.HashedPassword))
+		if err != nil {
+			return err
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return errPasswordMismatch
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown password hashing scheme: %v", user.PasswordScheme)
+	}
+}
+
+// NeedsRehash reports whether stored hash was produced by other scheme or with other parameters
+// than currently configured.
+func (h *PasswordHasher) NeedsRehash(user *storage.User) bool {
+	if user.PasswordScheme != h.scheme {
+		return true
+	}
+
+	switch h.scheme {
+	case passwordSchemeBcrypt:
+		cost, err := bcrypt.Cost(user.HashedPassword)
+		return err != nil || cost != h.bcryptCost
+	case passwordSchemeArgon2id:
+		params, _, _, err := decodeArgon2id(string(user.HashedPassword))
+		return err != nil || params != h.argon2
+	}
+	return false
+}
+
+func encodeArgon2id(params Argon2Params, salt []byte, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != passwordSchemeArgon2id {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version: %v", version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+func checkPasswordValidity(password string) error {
+	if len(password) > 72 {
+		return errors.New("too long password")
+	}
+	if len(password) < 7 {
+		return errors.New("too short password")
+	}
+
+	controls := 0
+	digits := 0
+	letters := 0
+
+	for _, r := range password {
+		if r > unicode.MaxASCII {
+			return errors.New("non-ASCII character in password")
+		}
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			controls++
+		case unicode.IsSpace(r):
+			return errors.New("space in password")
+		}
+	}
+
+	if controls == 0 || digits == 0 || letters == 0 {
+		return errors.New("password doesn't contain enough variety of symbols")
+	}
+
+	return nil
+}
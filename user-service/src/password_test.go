@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/md5"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"soa-project/user-service/storage"
+)
+
+func TestCheckPasswordValidity(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		expected error
+	}{
+		{
+			name:     "Valid",
+			password: "ValidPass1!",
+			expected: nil,
+		},
+		{
+			name:     "Too short",
+			password: "short",
+			expected: errors.New("too short password"),
+		},
+		{
+			name:     "Too long",
+			password: strings.Repeat("A", 73),
+			expected: errors.New("too long password"),
+		},
+		{
+			name:     "Non-ASCII",
+			password: "Pasлолrd_1",
+			expected: errors.New("non-ASCII character in password"),
+		},
+		{
+			name:     "Spaces",
+			password: "Space space",
+			expected: errors.New("space in password"),
+		},
+		{
+			name:     "No digits",
+			password: "NoDigits!!",
+			expected: errors.New("password doesn't contain enough variety of symbols"),
+		},
+		{
+			name:     "No letters",
+			password: "1234567!!",
+			expected: errors.New("password doesn't contain enough variety of symbols"),
+		},
+		{
+			name:     "No special",
+			password: "NoSpecial1",
+			expected: errors.New("password doesn't contain enough variety of symbols"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPasswordValidity(tt.password)
+			if (err == nil && tt.expected != nil) || (err != nil && tt.expected == nil) || (err != nil && err.Error() != tt.expected.Error()) {
+				t.Errorf("checkPasswordValidity(%q) returned %v, where %v expected", tt.password, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPasswordHasher(t *testing.T) {
+	bcryptHasher, err := NewPasswordHasher(passwordSchemeBcrypt, bcrypt.MinCost, DefaultArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hasher, err := NewPasswordHasher(passwordSchemeArgon2id, bcrypt.MinCost, Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preHashed := md5.Sum([]byte("ValidPass1!" + "login"))
+	legacyHash, err := bcrypt.GenerateFromPassword(preHashed[:], bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyUser := storage.User{Login: "login", HashedPassword: legacyHash, PasswordScheme: passwordSchemeLegacy}
+
+	tests := []struct {
+		name   string
+		hasher *PasswordHasher
+	}{
+		{
+			name:   "Bcrypt",
+			hasher: bcryptHasher,
+		},
+		{
+			name:   "Argon2id",
+			hasher: argon2Hasher,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, scheme, err := tt.hasher.Hash("ValidPass1!")
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := storage.User{Login: "login", HashedPassword: hash, PasswordScheme: scheme}
+
+			if err := tt.hasher.Verify(&user, "ValidPass1!"); err != nil {
+				t.Errorf("Verify returned %v for correct password", err)
+			}
+			if err := tt.hasher.Verify(&user, "WrongPass1!"); err != errPasswordMismatch {
+				t.Errorf("Verify returned %v for wrong password, where %v expected", err, errPasswordMismatch)
+			}
+			if tt.hasher.NeedsRehash(&user) {
+				t.Errorf("NeedsRehash returned true for hash produced with current parameters")
+			}
+
+			if err := tt.hasher.Verify(&legacyUser, "ValidPass1!"); err != nil {
+				t.Errorf("Verify returned %v for correct password with legacy hash", err)
+			}
+			if !tt.hasher.NeedsRehash(&legacyUser) {
+				t.Errorf("NeedsRehash returned false for legacy hash")
+			}
+		})
+	}
+}
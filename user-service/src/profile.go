@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	shared "soa-project/shared/proto"
+	pb "soa-project/user-service/proto"
+	"soa-project/user-service/storage"
+)
+
+const (
+	defaultProfileHistoryPageSize = 20
+	maxProfileHistoryPageSize     = 100
+
+	// Characters, as limited by Profiles columns
+	maxProfileNameLength = 100
+	// E.164 limits the number with country code to 15 digits, the shortest numbers in use have 7
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+	maxAge         = 150
+)
+
+// Fields of user.Profile that UpdateProfile changes unless the mask says otherwise
+var profileUpdatePaths = []string{"name", "surname", "phone_number", "birthday"}
+
+// Visibility fields, that may be listed in the mask along with profileUpdatePaths
+var profileVisibilityPaths = []string{
+	"visibility.name", "visibility.surname", "visibility.phone_number", "visibility.birthday", "visibility.avatars",
+}
+
+// profileUpdateFields returns fields listed in mask, with "visibility" expanded to every visibility field.
+// Empty mask stands for profileUpdatePaths.
+func profileUpdateFields(mask *fieldmaskpb.FieldMask) ([]string, error) {
+	if len(mask.GetPaths()) == 0 {
+		return profileUpdatePaths, nil
+	}
+
+	var paths []string
+	for _, path := range mask.GetPaths() {
+		switch {
+		case path == "visibility":
+			paths = append(paths, profileVisibilityPaths...)
+		case slices.Contains(profileUpdatePaths, path) || slices.Contains(profileVisibilityPaths, path):
+			paths = append(paths, path)
+		default:
+			return nil, fmt.Errorf("field %q can't be updated", path)
+		}
+	}
+	return paths, nil
+}
+
+// normalizeProfileUpdate checks the listed fields of update and returns them in canonical form,
+// other fields of the result are left empty. Problems are reported per field, so the client
+// is able to point at them.
+func normalizeProfileUpdate(update *shared.Profile, paths []string, now time.Time) (storage.Profile, []*errdetails.BadRequest_FieldViolation) {
+	var violations []*errdetails.BadRequest_FieldViolation
+	violate := func(field string, err error) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: err.Error()})
+	}
+
+	var normalized storage.Profile
+	var err error
+	for _, path := range paths {
+		switch path {
+		case "name":
+			normalized.Name, err = normalizeName(update.GetName())
+		case "surname":
+			normalized.Surname, err = normalizeName(update.GetSurname())
+		case "phone_number":
+			normalized.PhoneNumber, err = normalizePhoneNumber(update.GetPhoneNumber())
+		case "birthday":
+			err = checkBirthday(update.GetBirthday(), now)
+			if birthday := update.GetBirthday(); err == nil && birthday != nil {
+				date := time.Date(int(birthday.Year), time.Month(birthday.Month), int(birthday.Day), 0, 0, 0, 0, time.UTC)
+				normalized.BirthDay = &date
+			}
+		default:
+			field, _ := strings.CutPrefix(path, "visibility.")
+			*visibilityField(&normalized.Visibility, field), err = visibilityFromPb(
+				visibilityFieldPb(update.GetVisibility(), field),
+				*visibilityField(&storage.DefaultProfileVisibility, field),
+			)
+		}
+		if err != nil {
+			violate(path, err)
+		}
+	}
+
+	return normalized, violations
+}
+
+// normalizeName trims spaces and brings name to NFC, so visually equal names are stored equally.
+func normalizeName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", errors.New("not valid utf8")
+	}
+
+	name = norm.NFC.String(strings.TrimSpace(name))
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", errors.New("must not contain control characters")
+		}
+	}
+
+	if utf8.RuneCountInString(name) > maxProfileNameLength {
+		return "", fmt.Errorf("too long, at most %v characters allowed", maxProfileNameLength)
+	}
+	return name, nil
+}
+
+// normalizePhoneNumber parses number in international format and returns it in E.164 form,
+// i.e. plus sign followed by digits only. Spaces, dashes, dots and parentheses are dropped.
+func normalizePhoneNumber(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return "", nil
+	}
+
+	digits, ok := strings.CutPrefix(phone, "+")
+	if !ok {
+		return "", errors.New("must start with + and country code")
+	}
+
+	var normalized strings.Builder
+	normalized.WriteByte('+')
+	for _, r := range digits {
+		switch {
+		case r >= '0' && r <= '9':
+			normalized.WriteRune(r)
+		case strings.ContainsRune(" -.()", r):
+		default:
+			return "", fmt.Errorf("unexpected character %q", r)
+		}
+	}
+
+	count := normalized.Len() - 1
+	if count < minPhoneDigits || count > maxPhoneDigits {
+		return "", fmt.Errorf("must have from %v to %v digits", minPhoneDigits, maxPhoneDigits)
+	}
+	if normalized.String()[1] == '0' {
+		return "", errors.New("country code can't start with 0")
+	}
+	return normalized.String(), nil
+}
+
+// checkBirthday rejects dates that don't exist, that are in the future or implausibly long ago.
+func checkBirthday(date *shared.Date, now time.Time) error {
+	if date == nil {
+		return nil
+	}
+
+	// time.Date silently moves overflowing values to the next month or year
+	birthday := time.Date(int(date.Year), time.Month(date.Month), int(date.Day), 0, 0, 0, 0, time.UTC)
+	if year, month, day := birthday.Date(); year != int(date.Year) || int(month) != int(date.Month) || day != int(date.Day) {
+		return fmt.Errorf("%04d-%02d-%02d is not a valid date", date.Year, date.Month, date.Day)
+	}
+
+	// In the easternmost time zone the next day may have already begun
+	year, month, day := now.In(time.FixedZone("UTC+14", 14*60*60)).Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if birthday.After(today) {
+		return errors.New("must not be in the future")
+	}
+	if birthday.Before(today.AddDate(-maxAge, 0, 0)) {
+		return fmt.Errorf("must not be more than %v years ago", maxAge)
+	}
+	return nil
+}
+
+// applyProfileUpdate returns profile with the listed fields taken from update.
+func applyProfileUpdate(profile storage.Profile, update storage.Profile, paths []string) storage.Profile {
+	for _, path := range paths {
+		switch path {
+		case "name":
+			profile.Name = update.Name
+		case "surname":
+			profile.Surname = update.Surname
+		case "phone_number":
+			profile.PhoneNumber = update.PhoneNumber
+		case "birthday":
+			profile.BirthDay = update.BirthDay
+		default:
+			field, _ := strings.CutPrefix(path, "visibility.")
+			*visibilityField(&profile.Visibility, field) = *visibilityField(&update.Visibility, field)
+		}
+	}
+
+	return profile
+}
+
+func formatBirthday(birthDay *time.Time) string {
+	if birthDay == nil {
+		return ""
+	}
+	return birthDay.Format(time.DateOnly)
+}
+
+// diffProfiles lists fields, that differ between old and new profile, in text form.
+func diffProfiles(old, new storage.Profile) []storage.ProfileFieldChange {
+	fields := []storage.ProfileFieldChange{
+		{Field: "name", OldValue: old.Name, NewValue: new.Name},
+		{Field: "surname", OldValue: old.Surname, NewValue: new.Surname},
+		{Field: "phone_number", OldValue: old.PhoneNumber, NewValue: new.PhoneNumber},
+		{Field: "birthday", OldValue: formatBirthday(old.BirthDay), NewValue: formatBirthday(new.BirthDay)},
+		{Field: "avatar", OldValue: old.AvatarId, NewValue: new.AvatarId},
+	}
+	for _, path := range profileVisibilityPaths {
+		field, _ := strings.CutPrefix(path, "visibility.")
+		fields = append(fields, storage.ProfileFieldChange{
+			Field:    path,
+			OldValue: string(*visibilityField(&old.Visibility, field)),
+			NewValue: string(*visibilityField(&new.Visibility, field)),
+		})
+	}
+	return slices.DeleteFunc(fields, func(field storage.ProfileFieldChange) bool { return field.OldValue == field.NewValue })
+}
+
+// recordProfileChange appends the difference between old and new profile to the history
+// within tx, so the history can't miss a change. Nothing is recorded if nothing was changed.
+func recordProfileChange(ctx context.Context, tx storage.Transaction, old, new storage.Profile, now time.Time) error {
+	fields := diffProfiles(old, new)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	changeId, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to generate uuid: %w", err)
+	}
+
+	return tx.InsertProfileChange(ctx, storage.ProfileChange{
+		ChangeId:   changeId,
+		UserId:     new.UserId,
+		ActorId:    actorId(ctx, new.UserId),
+		ChangeTime: &now,
+		Ip:         clientIp(ctx),
+		UserAgent:  clientUserAgent(ctx),
+		Fields:     fields,
+	})
+}
+
+func profileChangeToPb(change *storage.ProfileChange) *pb.ProfileChange {
+	result := &pb.ProfileChange{
+		ActorId:    &shared.Id{Uuid: change.ActorId.String()},
+		ChangeTime: timestamppb.New(*change.ChangeTime),
+		Ip:         change.Ip,
+		UserAgent:  change.UserAgent,
+	}
+	for _, field := range change.Fields {
+		result.Fields = append(result.Fields, &pb.ProfileFieldChange{Field: field.Field, OldValue: field.OldValue, NewValue: field.NewValue})
+	}
+	return result
+}
+
+type profileHistoryPageToken struct {
+	ChangeTime time.Time `json:"t"`
+	ChangeId   string    `json:"i"`
+}
+
+func encodeProfileHistoryPageToken(change *storage.ProfileChange) string {
+	data, _ := json.Marshal(profileHistoryPageToken{ChangeTime: *change.ChangeTime, ChangeId: change.ChangeId.String()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProfileHistoryPageToken(token string) (*storage.ProfileChangeCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageToken profileHistoryPageToken
+	err = json.Unmarshal(data, &pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := &storage.ProfileChangeCursor{ChangeTime: pageToken.ChangeTime}
+	err = cursor.ChangeId.UnmarshalText([]byte(pageToken.ChangeId))
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func profileHistoryPageSize(requested int32) int {
+	if requested <= 0 {
+		return defaultProfileHistoryPageSize
+	}
+	return min(int(requested), maxProfileHistoryPageSize)
+}
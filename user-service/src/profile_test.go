@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	shared "soa-project/shared/proto"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		valid    bool
+	}{
+		{
+			name:     "Trimmed",
+			input:    "  Alice ",
+			expected: "Alice",
+			valid:    true,
+		},
+		{
+			name:     "Decomposed to composed",
+			input:    "Jose\u0301",
+			expected: "Jos\u00e9",
+			valid:    true,
+		},
+		{
+			name:     "Empty",
+			input:    "",
+			expected: "",
+			valid:    true,
+		},
+		{
+			name:  "Control character",
+			input: "Al\nice",
+			valid: false,
+		},
+		{
+			name:  "Invalid UTF-8",
+			input: string([]byte{0xff, 0xfe}),
+			valid: false,
+		},
+		{
+			name:     "Length is counted in characters",
+			input:    strings.Repeat("ж", maxProfileNameLength),
+			expected: strings.Repeat("ж", maxProfileNameLength),
+			valid:    true,
+		},
+		{
+			name:  "Too long",
+			input: strings.Repeat("a", maxProfileNameLength+1),
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := normalizeName(tt.input)
+			if (err == nil) != tt.valid || name != tt.expected {
+				t.Errorf("normalizeName(%q) returned %q, %v, where %q, valid=%v expected", tt.input, name, err, tt.expected, tt.valid)
+			}
+		})
+	}
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name     string
+		phone    string
+		expected string
+		valid    bool
+	}{
+		{
+			name:     "E.164",
+			phone:    "+79161234567",
+			expected: "+79161234567",
+			valid:    true,
+		},
+		{
+			name:     "With separators",
+			phone:    " +1 (555) 010-00.00 ",
+			expected: "+15550100000",
+			valid:    true,
+		},
+		{
+			name:     "Empty",
+			phone:    "",
+			expected: "",
+			valid:    true,
+		},
+		{
+			name:  "No country code",
+			phone: "89161234567",
+			valid: false,
+		},
+		{
+			name:  "Country code starting with zero",
+			phone: "+09161234567",
+			valid: false,
+		},
+		{
+			name:  "Letters",
+			phone: "+1555CALLNOW",
+			valid: false,
+		},
+		{
+			name:  "Too short",
+			phone: "+12345",
+			valid: false,
+		},
+		{
+			name:  "Too long",
+			phone: "+1234567890123456",
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phone, err := normalizePhoneNumber(tt.phone)
+			if (err == nil) != tt.valid || phone != tt.expected {
+				t.Errorf("normalizePhoneNumber(%q) returned %q, %v, where %q, valid=%v expected", tt.phone, phone, err, tt.expected, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCheckBirthday(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		date  *shared.Date
+		valid bool
+	}{
+		{
+			name:  "Absent",
+			date:  nil,
+			valid: true,
+		},
+		{
+			name:  "Valid",
+			date:  &shared.Date{Year: 2000, Month: 2, Day: 29},
+			valid: true,
+		},
+		{
+			name:  "Next day in the easternmost time zone",
+			date:  &shared.Date{Year: 2024, Month: 3, Day: 11},
+			valid: true,
+		},
+		{
+			name:  "In the future",
+			date:  &shared.Date{Year: 2024, Month: 3, Day: 12},
+			valid: false,
+		},
+		{
+			name:  "Month 13",
+			date:  &shared.Date{Year: 2000, Month: 13, Day: 1},
+			valid: false,
+		},
+		{
+			name:  "February 29 of non-leap year",
+			date:  &shared.Date{Year: 2001, Month: 2, Day: 29},
+			valid: false,
+		},
+		{
+			name:  "Too long ago",
+			date:  &shared.Date{Year: 1850, Month: 1, Day: 1},
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBirthday(tt.date, now)
+			if (err == nil) != tt.valid {
+				t.Errorf("checkBirthday(%v) returned %v, where valid=%v expected", tt.date, err, tt.valid)
+			}
+		})
+	}
+}
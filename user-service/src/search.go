@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"soa-project/user-service/storage"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	maxSearchQueryLength  = 100
+)
+
+// normalizeSearchQuery trims the query and checks it isn't empty or too long.
+func normalizeSearchQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", errors.New("query must not be empty")
+	}
+	if !utf8.ValidString(query) {
+		return "", errors.New("query is not valid utf8")
+	}
+	if utf8.RuneCountInString(query) > maxSearchQueryLength {
+		return "", fmt.Errorf("query is too long, at most %v characters allowed", maxSearchQueryLength)
+	}
+	return query, nil
+}
+
+// Search page token also carries the query, as the cursor makes no sense for another one.
+type searchPageToken struct {
+	Query  string  `json:"q"`
+	Score  float64 `json:"s"`
+	UserId string  `json:"i"`
+}
+
+func encodeSearchPageToken(query string, user *storage.FoundUser) string {
+	data, _ := json.Marshal(searchPageToken{Query: query, Score: user.Score, UserId: user.User.UserId.String()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchPageToken(query string, token string) (*storage.UserSearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageToken searchPageToken
+	err = json.Unmarshal(data, &pageToken)
+	if err != nil {
+		return nil, err
+	}
+	if pageToken.Query != query {
+		return nil, errors.New("token was issued for another query")
+	}
+
+	cursor := &storage.UserSearchCursor{Score: pageToken.Score}
+	err = cursor.UserId.UnmarshalText([]byte(pageToken.UserId))
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func searchPageSize(requested int32) int {
+	if requested <= 0 {
+		return defaultSearchPageSize
+	}
+	return min(int(requested), maxSearchPageSize)
+}
@@ -2,20 +2,18 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"net/mail"
-	"os"
+	"slices"
+	"strings"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -25,14 +23,42 @@ import (
 	"soa-project/user-service/storage"
 )
 
-type JwtManager struct {
-	jwtPrivate *rsa.PrivateKey
-}
-
 type UserService struct {
 	pb.UnimplementedUserServiceServer
-	storage    *storage.Storage
-	jwtManager JwtManager
+	storage    storage.Store
+	jwtManager *JwtManager
+	hasher     *PasswordHasher
+	mailer     Mailer
+	publisher  EventPublisher
+	blobs      BlobStore
+	followers  FollowerChecker
+
+	passwordResetUrl     string
+	emailVerificationUrl string
+	avatarUrl            string
+	deletionGracePeriod  time.Duration
+}
+
+type Config struct {
+	JwtPrivateFile   string
+	JwtRetiringFiles []string
+	DatabaseUrl      string
+	PasswordHasher   *PasswordHasher
+	Mailer           Mailer
+	// Reset token is appended to it in reset messages
+	PasswordResetUrl string
+	// Verification code is appended to it in verification messages
+	EmailVerificationUrl string
+	EventPublisher       EventPublisher
+	// Used instead of connecting to DatabaseUrl if set
+	Store     storage.Store
+	BlobStore BlobStore
+	// Defaults to the one knowing no followers
+	FollowerChecker FollowerChecker
+	// Avatar path "<user id>/<avatar id>/<size>" is appended to it in profiles
+	AvatarUrl string
+	// Time between account deletion and purging of its data
+	DeletionGracePeriod time.Duration
 }
 
 func checkLoginCorrectness(login string) error {
@@ -70,6 +96,52 @@ func checkEmailCorrectness(email string) error {
 	return nil
 }
 
+// normalizeEmail folds case of the address, so lookups and uniqueness don't depend on
+// how it was typed. Address with display name ("Name <user@example.com>") is reduced to the bare one.
+func normalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	if address, err := mail.ParseAddress(email); err == nil {
+		email = address.Address
+	}
+	return strings.ToLower(email)
+}
+
+// findUserByLoginOrEmail returns status error if user can't be found. Email takes precedence
+// if both login and email are provided.
+func findUserByLoginOrEmail(ctx context.Context, tx storage.Transaction, login string, email string) (*storage.User, error) {
+	var user *storage.User
+	var err error
+	if login != "" {
+		err = checkLoginCorrectness(login)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid login: %v", err)
+		}
+
+		user, err = tx.FindUserByLogin(ctx, login)
+		if err != nil && err != storage.ErrNoSuchUser {
+			return nil, status.Errorf(codes.Internal, "failed to find user by login failed: %v", err)
+		}
+	}
+	if email != "" {
+		email = normalizeEmail(email)
+		err = checkEmailCorrectness(email)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid email: %v", err)
+		}
+
+		user, err = tx.FindUserByEmail(ctx, email)
+		if err != nil && err != storage.ErrNoSuchUser {
+			return nil, status.Errorf(codes.Internal, "failed to find user by email failed: %v", err)
+		}
+	}
+
+	if user == nil {
+		return nil, status.Error(codes.NotFound, "no user with such login/email were found")
+	}
+
+	return user, nil
+}
+
 func (s UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -84,44 +156,34 @@ func (s UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid login: %v", err)
 	}
-	_, err = tx.FindUserByLogin(ctx, req.Login)
-	if err == nil {
-		return nil, status.Error(codes.AlreadyExists, "login is already used")
-	} else if err != storage.ErrNoSuchUser {
-		return nil, status.Errorf(codes.Internal, "failed to find user by login: %v", err)
-	}
 
-	err = checkEmailCorrectness(req.Email)
+	email := normalizeEmail(req.Email)
+	err = checkEmailCorrectness(email)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid email address: %v", err)
 	}
-	_, err = tx.FindUserByEmail(ctx, req.Email)
-	if err == nil {
-		return nil, status.Error(codes.AlreadyExists, "email is already used")
-	} else if err != storage.ErrNoSuchUser {
-		return nil, status.Errorf(codes.Internal, "failed to find user by email: %v", err)
+
+	err = checkPasswordValidity(req.Password)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid password: %v", err)
 	}
 
 	userId, err := uuid.NewRandom()
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to generate uuid: %v", err)
 	}
-	preHashedPassword, err := hex.DecodeString(req.HashedPassword)
-	if err != nil {
-		return nil, status.Error(codes.Internal, "invalid hex hashed password provided")
-	}
 
-	// password checking is done on the apiGateway side
-	hashedPass, err := bcrypt.GenerateFromPassword(preHashedPassword, bcrypt.DefaultCost)
+	hashedPass, passwordScheme, err := s.hasher.Hash(req.Password)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to bcrypt hashed password: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to hash password: %v", err)
 	}
 
 	user := storage.User{
 		UserId:         userId,
 		Login:          req.Login,
-		Email:          req.Email,
+		Email:          email,
 		HashedPassword: hashedPass,
+		PasswordScheme: passwordScheme,
 	}
 
 	time := time.Now()
@@ -132,8 +194,11 @@ func (s UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 		LastUpdateTime: &time,
 	}
 
+	// Uniqueness is enforced by the database, so concurrent registrations can't both succeed
 	err = tx.InsertUser(ctx, user)
-	if err != nil {
+	if err == storage.ErrLoginTaken || err == storage.ErrEmailTaken {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	} else if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to insert user: %v", err)
 	}
 
@@ -142,11 +207,23 @@ func (s UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 		return nil, status.Errorf(codes.Internal, "failed to insert profile: %v", err)
 	}
 
+	verification, _ := nextEmailVerification(nil, &user, time)
+	err = tx.UpsertEmailVerification(ctx, verification)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to insert email verification: %v", err)
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
 	}
 
+	// User is already registered, verification message can be requested once more
+	err = s.sendVerificationEmail(ctx, &user)
+	if err != nil {
+		log.Printf("failed to send verification email to user %v: %v", userId, err)
+	}
+
 	return &pb.RegisterResponse{Id: &shared.Id{Uuid: userId.String()}}, nil
 }
 
@@ -160,32 +237,76 @@ func (s UserService) Auth(ctx context.Context, req *pb.AuthRequest) (*pb.AuthRes
 	}
 	defer tx.Rollback(ctx)
 
-	var user *storage.User
-	if req.Login != "" {
-		err = checkLoginCorrectness(req.Login)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid email: %v", err)
+	throttle := authThrottle{now: time.Now(), ip: clientIp(ctx)}
+	err = throttle.checkIp(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findUserByLoginOrEmail(ctx, tx, req.Login, req.Email)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, throttle.fail(ctx, tx, err)
 		}
+		return nil, err
+	}
 
-		user, err = tx.FindUserByLogin(ctx, req.Login)
-		if err != nil && err != storage.ErrNoSuchUser {
-			return nil, status.Errorf(codes.Internal, "failed to find user by login failed: %v", err)
+	err = throttle.checkAccount(ctx, tx, user.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.hasher.Verify(user, req.Password)
+	if err == errPasswordMismatch {
+		return nil, throttle.fail(ctx, tx, status.Errorf(codes.InvalidArgument, "invalid password"))
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to verify password: %v", err)
+	}
+
+	// Checked only after the password, so suspension isn't disclosed to those who don't know it
+	err = checkNotSuspended(user, throttle.now)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.hasher.NeedsRehash(user) {
+		hashedPass, passwordScheme, err := s.hasher.Hash(req.Password)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to rehash password: %v", err)
+		}
+		err = tx.UpdateUserPassword(ctx, user.UserId, hashedPass, passwordScheme)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to update password hash: %v", err)
 		}
 	}
-	if req.Email != "" {
-		err = checkEmailCorrectness(req.Email)
+
+	secondFactorEnabled, err := hasSecondFactor(ctx, tx, user.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find second factor: %v", err)
+	}
+	if secondFactorEnabled {
+		// Failure counter of the account is kept until the second factor is verified too
+		challenge, err := s.jwtManager.issueActionToken(purposeSecondFactor, user.UserId, "", secondFactorChallengeLifetime)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid login: %v", err)
+			return nil, status.Errorf(codes.Internal, "failed to issue second factor challenge: %v", err)
 		}
 
-		user, err = tx.FindUserByEmail(ctx, req.Email)
-		if err != nil && err != storage.ErrNoSuchUser {
-			return nil, status.Errorf(codes.Internal, "failed to find user by email failed: %v", err)
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
 		}
+
+		return &pb.AuthResponse{Id: &shared.Id{Uuid: user.UserId.String()}, SecondFactorChallenge: challenge}, nil
 	}
 
-	if user == nil {
-		return nil, status.Error(codes.NotFound, "no user with such login/email were found")
+	err = throttle.recordSuccess(ctx, tx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset auth failures: %v", err)
+	}
+
+	accessToken, refreshToken, err := s.startSession(ctx, tx, user, throttle.now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start session: %v", err)
 	}
 
 	err = tx.Commit(ctx)
@@ -193,98 +314,196 @@ func (s UserService) Auth(ctx context.Context, req *pb.AuthRequest) (*pb.AuthRes
 		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
 	}
 
-	preHashedPassword, err := hex.DecodeString(req.HashedPassword)
+	return &pb.AuthResponse{Id: &shared.Id{Uuid: user.UserId.String()}, Jwt: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (s UserService) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty refresh token")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	token, err := tx.FindRefreshTokenByHash(ctx, hashOpaqueToken(req.RefreshToken))
+	if err != nil {
+		if err == storage.ErrNoSuchRefreshToken {
+			return nil, status.Error(codes.Unauthenticated, "unknown refresh token")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find refresh token: %v", err)
+		}
+	}
+
+	if token.Revoked {
+		return nil, status.Error(codes.Unauthenticated, "refresh token was revoked")
+	}
+
+	if token.Used {
+		// The token was already rotated, so either the client or an attacker replays a stolen copy.
+		// We can't tell which one, so the whole family is revoked.
+		err = tx.RevokeRefreshTokenFamily(ctx, token.FamilyId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to revoke refresh token family: %v", err)
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+		}
+
+		log.Printf("refresh token reuse detected: user %v, family %v revoked", token.UserId, token.FamilyId)
+		return nil, status.Error(codes.Unauthenticated, "refresh token reuse detected, all related tokens were revoked")
+	}
+
+	if token.ExpirationTime.Before(time.Now()) {
+		return nil, status.Error(codes.Unauthenticated, "refresh token expired")
+	}
+
+	user, err := tx.FindUserById(ctx, token.UserId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.Unauthenticated, "refresh token owner no longer exists")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	err = checkNotSuspended(user, time.Now())
 	if err != nil {
-		return nil, status.Error(codes.Internal, "invalid hex hashed password provided")
+		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.HashedPassword, preHashedPassword)
+	err = tx.MarkRefreshTokenUsed(ctx, token.TokenHash)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid password")
+		return nil, status.Errorf(codes.Internal, "failed to mark refresh token used: %v", err)
 	}
 
-	issuedTime := time.Now()
-	expirationTime := issuedTime.Add(time.Minute * 10)
-	type Claims struct {
-		UserId string `json:"user_id"`
-		jwt.RegisteredClaims
+	err = tx.TouchSession(ctx, token.FamilyId, time.Now(), clientIp(ctx), clientUserAgent(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update session: %v", err)
 	}
 
-	claims := Claims{
-		user.UserId.String(),
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(issuedTime),
-		},
+	accessToken, refreshToken, err := s.issueTokens(ctx, tx, user, token.FamilyId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to issue tokens: %v", err)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	value, err := token.SignedString(s.jwtManager.jwtPrivate)
+	err = tx.Commit(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "jwt signing error: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
 	}
 
-	return &pb.AuthResponse{Id: &shared.Id{Uuid: user.UserId.String()}, Jwt: value}, nil
+	return &pb.RefreshTokenResponse{Id: &shared.Id{Uuid: user.UserId.String()}, Jwt: accessToken, RefreshToken: refreshToken}, nil
 }
 
-func (s UserService) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UpdateProfileResponse, error) {
+func (s UserService) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	claims, err := s.jwtManager.parseAccessToken(req.Jwt)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid jwt: %v", err)
+	}
+
 	tx, err := s.storage.Begin(ctx)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
 	}
 	defer tx.Rollback(ctx)
 
-	userId, err := uuid.Parse(req.Id.Uuid)
+	now := time.Now()
+	err = tx.DeleteExpiredRevocations(ctx, now)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "failed to parse passed id")
+		return nil, status.Errorf(codes.Internal, "failed to delete expired revocations: %v", err)
 	}
 
-	oldProfile, err := tx.FindProfileByUserId(ctx, userId)
+	err = tx.InsertRevocation(ctx, storage.Revocation{
+		Kind:           storage.RevocationKindToken,
+		Subject:        claims.ID,
+		RevocationTime: &now,
+		ExpirationTime: &claims.ExpiresAt.Time,
+	})
 	if err != nil {
-		if err == storage.ErrNoSuchUser {
-			return nil, status.Error(codes.NotFound, "no profile for provided used id")
-		} else {
-			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to insert revocation: %v", err)
+	}
+
+	if req.RefreshToken != "" {
+		token, err := tx.FindRefreshTokenByHash(ctx, hashOpaqueToken(req.RefreshToken))
+		if err != nil && err != storage.ErrNoSuchRefreshToken {
+			return nil, status.Errorf(codes.Internal, "failed to find refresh token: %v", err)
+		}
+		if err == nil {
+			if token.UserId.String() != claims.UserId {
+				return nil, status.Error(codes.PermissionDenied, "refresh token belongs to another user")
+			}
+			err = revokeSession(ctx, tx, token.FamilyId, now)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+			}
 		}
 	}
 
-	reqProf := req.Profile
-	var birthDate *time.Time
-	if reqProf.Birthday != nil {
-		date := time.Date(int(reqProf.Birthday.Year), time.Month(reqProf.Birthday.Month), int(reqProf.Birthday.Day), 0, 0, 0, 0, time.UTC)
-		birthDate = &date
+	// Tokens issued before sessions were introduced don't carry sid
+	if sessionId, err := uuid.Parse(claims.SessionId); err == nil {
+		err = revokeSession(ctx, tx, sessionId, now)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+		}
 	}
-	time := time.Now()
 
-	profile := storage.Profile{
-		UserId:         userId,
-		Name:           reqProf.Name,
-		Surname:        reqProf.Surname,
-		PhoneNumber:    reqProf.PhoneNumber,
-		BirthDay:       birthDate,
-		CreationTime:   oldProfile.CreationTime,
-		LastUpdateTime: &time,
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
 	}
 
-	log.Printf("profile: %v", profile)
+	return &pb.LogoutResponse{}, nil
+}
 
-	err = tx.UpdateProfile(ctx, profile)
+func (s UserService) ListRevocations(ctx context.Context, req *pb.ListRevocationsRequest) (*pb.ListRevocationsResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "update profile failed: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
 	}
+	defer tx.Rollback(ctx)
 
-	err = tx.Commit(ctx)
+	var since time.Time
+	if req.Since != nil {
+		since = req.Since.AsTime()
+	}
+	syncTime := time.Now()
+
+	revocations, err := tx.ListRevocations(ctx, since, syncTime)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to list revocations: %v", err)
 	}
 
-	return &pb.UpdateProfileResponse{}, nil
+	response := pb.ListRevocationsResponse{SyncTime: timestamppb.New(syncTime)}
+	for _, revocation := range revocations {
+		response.Revocations = append(response.Revocations, &pb.Revocation{
+			Kind:           revocation.Kind,
+			Subject:        revocation.Subject,
+			RevocationTime: timestamppb.New(*revocation.RevocationTime),
+			ExpirationTime: timestamppb.New(*revocation.ExpirationTime),
+		})
+	}
+
+	return &response, nil
 }
 
-func (s UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+func (s UserService) GetJwks(ctx context.Context, req *pb.GetJwksRequest) (*pb.GetJwksResponse, error) {
+	return &pb.GetJwksResponse{Keys: s.jwtManager.jwks()}, nil
+}
+
+func (s UserService) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -299,6 +518,17 @@ func (s UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.G
 		return nil, status.Error(codes.Internal, "failed to parse passed id")
 	}
 
+	// Stolen session must not give unlimited attempts to guess the password
+	throttle := authThrottle{now: time.Now(), ip: clientIp(ctx)}
+	err = throttle.checkIp(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	err = throttle.checkAccount(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := tx.FindUserById(ctx, userId)
 	if err != nil {
 		if err == storage.ErrNoSuchUser {
@@ -308,10 +538,47 @@ func (s UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.G
 		}
 	}
 
-	return &pb.GetUserResponse{Login: string(user.Login[:]), Email: string(user.Email[:])}, nil
+	err = s.hasher.Verify(user, req.OldPassword)
+	if err == errPasswordMismatch {
+		return nil, throttle.fail(ctx, tx, status.Error(codes.PermissionDenied, "invalid old password"))
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to verify password: %v", err)
+	}
+
+	err = throttle.recordSuccess(ctx, tx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset auth failures: %v", err)
+	}
+
+	err = checkPasswordValidity(req.NewPassword)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid new password: %v", err)
+	}
+
+	hashedPass, passwordScheme, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to hash password: %v", err)
+	}
+
+	err = tx.UpdateUserPassword(ctx, user.UserId, hashedPass, passwordScheme)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update password: %v", err)
+	}
+
+	err = revokeUserSessions(ctx, tx, user.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke sessions: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.ChangePasswordResponse{}, nil
 }
 
-func (s UserService) GetProfile(ctx context.Context, req *pb.GetProfileRequest) (*pb.GetProfileResponse, error) {
+func (s UserService) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -321,58 +588,1757 @@ func (s UserService) GetProfile(ctx context.Context, req *pb.GetProfileRequest)
 	}
 	defer tx.Rollback(ctx)
 
-	userId, err := uuid.Parse(req.Id.Uuid)
+	user, err := findUserByLoginOrEmail(ctx, tx, req.Login, req.Email)
+	if status.Code(err) == codes.NotFound {
+		// Response must not reveal whether account exists
+		return &pb.RequestPasswordResetResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = tx.InvalidateUserPasswordResetTokens(ctx, user.UserId)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "failed to parse passed id")
+		return nil, status.Errorf(codes.Internal, "failed to invalidate previous reset tokens: %v", err)
 	}
 
-	profile, err := tx.FindProfileByUserId(ctx, userId)
+	token, err := generateOpaqueToken()
 	if err != nil {
-		if err == storage.ErrNoSuchUser {
-			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		return nil, status.Errorf(codes.Internal, "failed to generate reset token: %v", err)
+	}
+
+	creationTime := time.Now()
+	expirationTime := creationTime.Add(passwordResetTokenLifetime)
+	err = tx.InsertPasswordResetToken(ctx, storage.PasswordResetToken{
+		TokenHash:      hashOpaqueToken(token),
+		UserId:         user.UserId,
+		CreationTime:   &creationTime,
+		ExpirationTime: &expirationTime,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to insert reset token: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	body := fmt.Sprintf("Hello, %s!\n\nSomebody requested password reset for your account. If it was you, use the following token within %v:\n\n%s\n", user.Login, passwordResetTokenLifetime, token)
+	if s.passwordResetUrl != "" {
+		body += fmt.Sprintf("\nOr follow the link: %s%s\n", s.passwordResetUrl, token)
+	}
+	body += "\nIf it wasn't you, just ignore this message.\n"
+
+	err = s.mailer.Send(ctx, Message{To: user.Email, Subject: "Password reset", Body: body})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to send reset message: %v", err)
+	}
+
+	return &pb.RequestPasswordResetResponse{}, nil
+}
+
+func (s UserService) ConfirmPasswordReset(ctx context.Context, req *pb.ConfirmPasswordResetRequest) (*pb.ConfirmPasswordResetResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	token, err := tx.FindPasswordResetTokenByHash(ctx, hashOpaqueToken(req.Token))
+	if err != nil {
+		if err == storage.ErrNoSuchPasswordResetToken {
+			return nil, status.Error(codes.PermissionDenied, "invalid reset token")
 		} else {
-			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+			return nil, status.Errorf(codes.Internal, "failed to find reset token: %v", err)
 		}
 	}
 
-	var birthday *shared.Date = nil
-	if profile.BirthDay != nil {
-		year, month, day := profile.BirthDay.Date()
-		birthday = &shared.Date{Year: int32(year), Month: int32(month), Day: int32(day)}
+	if token.Used || token.ExpirationTime.Before(time.Now()) {
+		return nil, status.Error(codes.PermissionDenied, "reset token was already used or expired")
 	}
 
-	respProfile := shared.Profile{
-		Name:           profile.Name,
-		Surname:        profile.Surname,
-		PhoneNumber:    profile.PhoneNumber,
-		Birthday:       birthday,
-		CreationTime:   timestamppb.New(*profile.CreationTime),
-		LastUpdateTime: timestamppb.New(*profile.LastUpdateTime),
+	err = checkPasswordValidity(req.NewPassword)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid new password: %v", err)
+	}
+
+	hashedPass, passwordScheme, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to hash password: %v", err)
+	}
+
+	err = tx.UpdateUserPassword(ctx, token.UserId, hashedPass, passwordScheme)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update password: %v", err)
+	}
+
+	err = tx.InvalidateUserPasswordResetTokens(ctx, token.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to invalidate reset tokens: %v", err)
+	}
+
+	err = revokeUserSessions(ctx, tx, token.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke sessions: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
 	}
 
-	return &pb.GetProfileResponse{Profile: &respProfile}, nil
+	return &pb.ConfirmPasswordResetResponse{}, nil
 }
 
-func NewUserService(jwtPrivateFile string, databaseUrl string) (*UserService, error) {
-	private, err := os.ReadFile(jwtPrivateFile)
+func (s UserService) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	claims, err := s.jwtManager.parseActionToken(req.Code, purposeEmailVerification)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "invalid verification code: %v", err)
+	}
+
+	userId, err := uuid.Parse(claims.UserId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize UserService: failed to read jwtPrivateFile: %w", err)
+		return nil, status.Error(codes.PermissionDenied, "invalid verification code: invalid user id")
 	}
-	jwtPrivate, err := jwt.ParseRSAPrivateKeyFromPEM(private)
+
+	tx, err := s.storage.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize UserService: failed to parse private key: %w", err)
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
 	}
+	defer tx.Rollback(ctx)
 
-	storage, err := storage.NewStorage(databaseUrl)
+	err = tx.SetUserEmailVerified(ctx, userId, claims.Email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize UserService: failed to initialize storage: %w", err)
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.FailedPrecondition, "user no longer exists or email was changed")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to mark email verified: %v", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.VerifyEmailResponse{Id: &shared.Id{Uuid: userId.String()}}, nil
+}
+
+func (s UserService) ResendVerificationEmail(ctx context.Context, req *pb.ResendVerificationEmailRequest) (*pb.ResendVerificationEmailResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	if user.EmailVerified {
+		return nil, status.Error(codes.FailedPrecondition, "email is already verified")
+	}
+
+	prev, err := tx.FindEmailVerificationByUserId(ctx, userId)
+	if err == storage.ErrNoSuchEmailVerification {
+		prev = nil
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find email verification: %v", err)
+	}
+
+	verification, delay := nextEmailVerification(prev, user, time.Now())
+	if delay > 0 {
+		return nil, statusWithRetryDelay(codes.ResourceExhausted, "verification email was requested too often", delay)
+	}
+
+	err = tx.UpsertEmailVerification(ctx, verification)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update email verification: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	err = s.sendVerificationEmail(ctx, user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to send verification email: %v", err)
+	}
+
+	return &pb.ResendVerificationEmailResponse{}, nil
+}
+
+func (s UserService) EnrollTotp(ctx context.Context, req *pb.EnrollTotpRequest) (*pb.EnrollTotpResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	enabled, err := hasSecondFactor(ctx, tx, user.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find second factor: %v", err)
+	}
+	if enabled {
+		return nil, status.Error(codes.FailedPrecondition, "second factor is already enabled")
+	}
+
+	secret, err := generateTotpSecret()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate totp secret: %v", err)
+	}
+
+	// Enrollment restarted before confirmation simply replaces the secret
+	creationTime := time.Now()
+	err = tx.UpsertTotpSecret(ctx, storage.TotpSecret{
+		UserId:       user.UserId,
+		Secret:       secret,
+		CreationTime: &creationTime,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to insert totp secret: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.EnrollTotpResponse{Secret: totpEncoding.EncodeToString(secret), Uri: totpUri(secret, user.Login)}, nil
+}
+
+func (s UserService) ConfirmTotp(ctx context.Context, req *pb.ConfirmTotpRequest) (*pb.ConfirmTotpResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	secret, err := tx.FindTotpSecretByUserId(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchTotpSecret {
+			return nil, status.Error(codes.FailedPrecondition, "totp enrollment wasn't started")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find totp secret: %v", err)
+		}
+	}
+	if secret.Confirmed {
+		return nil, status.Error(codes.FailedPrecondition, "second factor is already enabled")
+	}
+
+	step, ok := verifyTotp(secret.Secret, strings.TrimSpace(req.Code), time.Now(), secret.LastUsedStep)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "invalid totp code")
+	}
+
+	err = tx.UpdateTotpLastUsedStep(ctx, userId, step)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update totp step: %v", err)
+	}
+
+	err = tx.ConfirmTotpSecret(ctx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to confirm totp secret: %v", err)
+	}
+
+	recoveryCodes, err := replaceRecoveryCodes(ctx, tx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to issue recovery codes: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.ConfirmTotpResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s UserService) DisableTotp(ctx context.Context, req *pb.DisableTotpRequest) (*pb.DisableTotpResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	// Stolen session must not give unlimited attempts to guess the password and the codes
+	throttle := authThrottle{now: time.Now(), ip: clientIp(ctx)}
+	err = throttle.checkIp(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	err = throttle.checkAccount(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	err = s.hasher.Verify(user, req.Password)
+	if err == errPasswordMismatch {
+		return nil, throttle.fail(ctx, tx, status.Error(codes.PermissionDenied, "invalid password"))
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to verify password: %v", err)
+	}
+
+	secret, err := tx.FindTotpSecretByUserId(ctx, userId)
+	if err != nil && err != storage.ErrNoSuchTotpSecret {
+		return nil, status.Errorf(codes.Internal, "failed to find totp secret: %v", err)
+	}
+	if secret == nil || !secret.Confirmed {
+		return nil, status.Error(codes.FailedPrecondition, "second factor is not enabled")
+	}
+
+	ok, err := checkSecondFactor(ctx, tx, secret, req.Code, throttle.now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check second factor: %v", err)
+	}
+	if !ok {
+		return nil, throttle.fail(ctx, tx, status.Error(codes.PermissionDenied, "invalid second factor code"))
+	}
+
+	err = throttle.recordSuccess(ctx, tx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset auth failures: %v", err)
+	}
+
+	err = tx.DeleteTotpSecret(ctx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete totp secret: %v", err)
+	}
+
+	err = tx.DeleteUserRecoveryCodes(ctx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete recovery codes: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.DisableTotpResponse{}, nil
+}
+
+func (s UserService) VerifySecondFactor(ctx context.Context, req *pb.VerifySecondFactorRequest) (*pb.VerifySecondFactorResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	claims, err := s.jwtManager.parseActionToken(req.Challenge, purposeSecondFactor)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid second factor challenge: %v", err)
+	}
+
+	userId, err := uuid.Parse(claims.UserId)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid second factor challenge: malformed user id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Codes are short, so guessing is throttled in the same way as passwords are
+	throttle := authThrottle{now: time.Now(), ip: clientIp(ctx)}
+	err = throttle.checkIp(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	err = throttle.checkAccount(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	err = checkNotSuspended(user, throttle.now)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := tx.FindTotpSecretByUserId(ctx, userId)
+	if err != nil && err != storage.ErrNoSuchTotpSecret {
+		return nil, status.Errorf(codes.Internal, "failed to find totp secret: %v", err)
+	}
+	if secret == nil || !secret.Confirmed {
+		return nil, status.Error(codes.FailedPrecondition, "second factor is not enabled")
+	}
+
+	ok, err := checkSecondFactor(ctx, tx, secret, req.Code, throttle.now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check second factor: %v", err)
+	}
+	if !ok {
+		return nil, throttle.fail(ctx, tx, status.Error(codes.PermissionDenied, "invalid second factor code"))
+	}
+
+	err = throttle.recordSuccess(ctx, tx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset auth failures: %v", err)
+	}
+
+	accessToken, refreshToken, err := s.startSession(ctx, tx, user, throttle.now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start session: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.VerifySecondFactorResponse{Id: &shared.Id{Uuid: user.UserId.String()}, Jwt: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (s UserService) AssignRole(ctx context.Context, req *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse passed id")
+	}
+
+	_, err = tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	role, err := tx.FindRoleByName(ctx, req.Role)
+	if err != nil {
+		if err == storage.ErrNoSuchRole {
+			return nil, status.Errorf(codes.NotFound, "no role %v", req.Role)
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find role: %v", err)
+		}
+	}
+
+	_, err = tx.AssignRole(ctx, userId, role.RoleId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to assign role: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.AssignRoleResponse{}, nil
+}
+
+func (s UserService) RevokeRole(ctx context.Context, req *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse passed id")
+	}
+
+	role, err := tx.FindRoleByName(ctx, req.Role)
+	if err != nil {
+		if err == storage.ErrNoSuchRole {
+			return nil, status.Errorf(codes.NotFound, "no role %v", req.Role)
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find role: %v", err)
+		}
+	}
+
+	revoked, err := tx.RevokeRole(ctx, userId, role.RoleId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke role: %v", err)
+	}
+
+	if revoked {
+		// Tokens issued before carry the revoked role, so they must not be accepted anymore
+		err = revokeUserAccessTokens(ctx, tx, userId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to revoke access tokens: %v", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.RevokeRoleResponse{}, nil
+}
+
+func (s UserService) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var roles []storage.Role
+	if req.Id != nil && req.Id.Uuid != "" {
+		userId, err := uuid.Parse(req.Id.Uuid)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "failed to parse passed id")
+		}
+
+		_, err = tx.FindUserById(ctx, userId)
+		if err != nil {
+			if err == storage.ErrNoSuchUser {
+				return nil, status.Error(codes.NotFound, "no user for provided used id")
+			} else {
+				return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+			}
+		}
+
+		roles, err = tx.ListUserRoles(ctx, userId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list user roles: %v", err)
+		}
+	} else {
+		roles, err = tx.ListRoles(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list roles: %v", err)
+		}
+	}
+
+	response := &pb.ListRolesResponse{}
+	for _, role := range roles {
+		response.Roles = append(response.Roles, &pb.Role{Name: role.RoleName, Description: role.Description})
+	}
+
+	return response, nil
+}
+
+// BootstrapAdmin grants admin role to the user with provided login, so the very first
+// administrator may be appointed without direct access to the database.
+func (s UserService) BootstrapAdmin(ctx context.Context, login string) error {
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	user, err := tx.FindUserByLogin(ctx, login)
+	if err != nil {
+		return fmt.Errorf("failed to find user %v: %w", login, err)
+	}
+
+	role, err := tx.FindRoleByName(ctx, storage.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to find admin role: %w", err)
+	}
+
+	assigned, err := tx.AssignRole(ctx, user.UserId, role.RoleId)
+	if err != nil {
+		return fmt.Errorf("failed to assign admin role: %w", err)
+	}
+	if assigned {
+		log.Printf("admin role was granted to %v\n", login)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s UserService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var after *storage.UserCursor
+	if req.PageToken != "" {
+		var err error
+		after, err = decodeUsersPageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
+		}
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	pageSize := usersPageSize(req.PageSize)
+	filter := storage.UserFilter{Query: req.Query, Role: req.Role, SuspendedOnly: req.SuspendedOnly}
+
+	// One extra user tells whether there is the next page
+	users, err := tx.ListUsers(ctx, filter, after, pageSize+1, now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list users: %v", err)
+	}
+
+	response := &pb.ListUsersResponse{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		response.NextPageToken = encodeUsersPageToken(&users[pageSize-1])
+	}
+	for i := range users {
+		response.Users = append(response.Users, userSummary(&users[i], now))
+	}
+
+	return response, nil
+}
+
+func (s UserService) SuspendUser(ctx context.Context, req *pb.SuspendUserRequest) (*pb.SuspendUserResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse passed id")
+	}
+
+	now := time.Now()
+	var until *time.Time
+	if req.Until != nil {
+		t := req.Until.AsTime()
+		if !t.After(now) {
+			return nil, status.Error(codes.InvalidArgument, "suspension must end in the future")
+		}
+		until = &t
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.SuspendUser(ctx, userId, now, until, req.Reason)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to suspend user: %v", err)
+		}
+	}
+
+	err = revokeUserSessions(ctx, tx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke sessions: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.SuspendUserResponse{}, nil
+}
+
+func (s UserService) ReinstateUser(ctx context.Context, req *pb.ReinstateUserRequest) (*pb.ReinstateUserResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse passed id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.ReinstateUser(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to reinstate user: %v", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.ReinstateUserResponse{}, nil
+}
+
+func (s UserService) DeleteAccount(ctx context.Context, req *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	// Stolen session must not give unlimited attempts to guess the password and the codes
+	throttle := authThrottle{now: time.Now(), ip: clientIp(ctx)}
+	err = throttle.checkIp(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	err = throttle.checkAccount(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	err = s.hasher.Verify(user, req.Password)
+	if err == errPasswordMismatch {
+		return nil, throttle.fail(ctx, tx, status.Error(codes.PermissionDenied, "invalid password"))
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to verify password: %v", err)
+	}
+
+	now := throttle.now
+
+	secret, err := tx.FindTotpSecretByUserId(ctx, userId)
+	if err != nil && err != storage.ErrNoSuchTotpSecret {
+		return nil, status.Errorf(codes.Internal, "failed to find totp secret: %v", err)
+	}
+	if secret != nil && secret.Confirmed {
+		ok, err := checkSecondFactor(ctx, tx, secret, req.SecondFactorCode, now)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check second factor: %v", err)
+		}
+		if !ok {
+			return nil, throttle.fail(ctx, tx, status.Error(codes.PermissionDenied, "invalid second factor code"))
+		}
+	}
+
+	err = throttle.recordSuccess(ctx, tx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset auth failures: %v", err)
+	}
+
+	err = tx.SoftDeleteUser(ctx, userId, now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete user: %v", err)
+	}
+
+	err = revokeUserSessions(ctx, tx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke sessions: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.DeleteAccountResponse{PurgingTime: timestamppb.New(now.Add(s.deletionGracePeriod))}, nil
+}
+
+func (s UserService) ExportUserData(ctx context.Context, req *pb.ExportUserDataRequest) (*pb.ExportUserDataResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.BeginSnapshot(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	archive, err := buildExportArchive(ctx, tx, user, time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to build export archive: %v", err)
+	}
+
+	return &pb.ExportUserDataResponse{Archive: archive, FormatVersion: exportFormatVersion}, nil
+}
+
+func (s UserService) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	// Session without use for refresh token lifetime can't be continued anymore
+	sessions, err := tx.ListUserSessions(ctx, userId, time.Now().Add(-refreshTokenLifetime))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list sessions: %v", err)
+	}
+
+	response := &pb.ListSessionsResponse{}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, &pb.Session{
+			SessionId:    session.SessionId.String(),
+			CreationTime: timestamppb.New(*session.CreationTime),
+			LastUseTime:  timestamppb.New(*session.LastUseTime),
+			UserAgent:    session.UserAgent,
+			Ip:           session.Ip,
+		})
+	}
+
+	return response, nil
+}
+
+func (s UserService) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	sessionId, err := uuid.Parse(req.SessionId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse session id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	session, err := tx.FindSessionById(ctx, sessionId)
+	if err != nil && err != storage.ErrNoSuchSession {
+		return nil, status.Errorf(codes.Internal, "failed to find session: %v", err)
+	}
+	// Sessions of other users are indistinguishable from missing ones
+	if session == nil || session.UserId != userId || session.RevokedTime != nil {
+		return nil, status.Error(codes.NotFound, "no such session")
+	}
+
+	err = revokeSession(ctx, tx, sessionId, time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.RevokeSessionResponse{}, nil
+}
+
+func (s UserService) CreatePersonalAccessToken(ctx context.Context, req *pb.CreatePersonalAccessTokenRequest) (*pb.CreatePersonalAccessTokenResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	err = checkPersonalAccessTokenName(req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid token name: %v", err)
+	}
+
+	scopes, err := checkPersonalAccessTokenScopes(req.Scopes)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid scopes: %v", err)
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(defaultPersonalAccessTokenLifetime)
+	if req.ExpirationTime != nil {
+		expirationTime = req.ExpirationTime.AsTime()
+		if !expirationTime.After(now) {
+			return nil, status.Error(codes.InvalidArgument, "expiration time must be in the future")
+		}
+		if expirationTime.Sub(now) > maxPersonalAccessTokenLifetime {
+			return nil, status.Errorf(codes.InvalidArgument, "token may not live longer than %v days", int(maxPersonalAccessTokenLifetime.Hours()/24))
+		}
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	tokens, err := tx.ListUserPersonalAccessTokens(ctx, userId, now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list personal access tokens: %v", err)
+	}
+	if len(tokens) >= maxPersonalAccessTokensPerUser {
+		return nil, status.Errorf(codes.FailedPrecondition, "user may have at most %v active tokens", maxPersonalAccessTokensPerUser)
+	}
+
+	tokenId, err := uuid.NewRandom()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate uuid: %v", err)
+	}
+
+	secret, err := generatePersonalAccessToken()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate token: %v", err)
+	}
+
+	token := storage.PersonalAccessToken{
+		TokenId:        tokenId,
+		UserId:         userId,
+		Name:           req.Name,
+		TokenHash:      hashOpaqueToken(secret),
+		Scopes:         scopes,
+		CreationTime:   &now,
+		ExpirationTime: &expirationTime,
+	}
+	err = tx.InsertPersonalAccessToken(ctx, token)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to insert personal access token: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.CreatePersonalAccessTokenResponse{Token: personalAccessTokenToPb(&token), Secret: secret}, nil
+}
+
+func (s UserService) ListPersonalAccessTokens(ctx context.Context, req *pb.ListPersonalAccessTokensRequest) (*pb.ListPersonalAccessTokensResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tokens, err := tx.ListUserPersonalAccessTokens(ctx, userId, time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list personal access tokens: %v", err)
+	}
+
+	response := &pb.ListPersonalAccessTokensResponse{}
+	for i := range tokens {
+		response.Tokens = append(response.Tokens, personalAccessTokenToPb(&tokens[i]))
+	}
+
+	return response, nil
+}
+
+func (s UserService) RevokePersonalAccessToken(ctx context.Context, req *pb.RevokePersonalAccessTokenRequest) (*pb.RevokePersonalAccessTokenResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	tokenId, err := uuid.Parse(req.TokenId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse token id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	revoked, err := tx.RevokePersonalAccessToken(ctx, userId, tokenId, time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke personal access token: %v", err)
+	}
+	if !revoked {
+		return nil, status.Error(codes.NotFound, "no such personal access token")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.RevokePersonalAccessTokenResponse{}, nil
+}
+
+// VerifyPersonalAccessToken is called by gateway on every request authenticated with
+// personal access token, so revocation, suspension and role changes apply immediately.
+func (s UserService) VerifyPersonalAccessToken(ctx context.Context, req *pb.VerifyPersonalAccessTokenRequest) (*pb.VerifyPersonalAccessTokenResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if !strings.HasPrefix(req.Secret, personalAccessTokenPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "invalid personal access token")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	token, err := tx.FindPersonalAccessTokenByHash(ctx, hashOpaqueToken(req.Secret))
+	if err != nil {
+		if err == storage.ErrNoSuchPersonalAccessToken {
+			return nil, status.Error(codes.Unauthenticated, "invalid personal access token")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find personal access token: %v", err)
+		}
+	}
+	if token.RevokedTime != nil {
+		return nil, status.Error(codes.Unauthenticated, "personal access token was revoked")
+	}
+	if !token.ExpirationTime.After(now) {
+		return nil, status.Error(codes.Unauthenticated, "personal access token expired")
+	}
+
+	user, err := tx.FindUserById(ctx, token.UserId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.Unauthenticated, "personal access token owner doesn't exist")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	err = checkNotSuspended(user, now)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := tx.ListUserRoles(ctx, user.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list user roles: %v", err)
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.RoleName)
+	}
+
+	if token.LastUseTime == nil || now.Sub(*token.LastUseTime) >= personalAccessTokenTouchInterval {
+		err = tx.TouchPersonalAccessToken(ctx, token.TokenId, now)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to update personal access token: %v", err)
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+		}
+	}
+
+	return &pb.VerifyPersonalAccessTokenResponse{
+		Id:            &shared.Id{Uuid: user.UserId.String()},
+		TokenId:       token.TokenId.String(),
+		Scopes:        token.Scopes,
+		Roles:         roleNames,
+		EmailVerified: user.EmailVerified,
+	}, nil
+}
+
+func (s UserService) UploadAvatar(ctx context.Context, req *pb.UploadAvatarRequest) (*pb.UploadAvatarResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	img, contentType, err := decodeAvatar(req.Image)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image: %v", err)
+	}
+
+	images, err := encodeAvatar(img, contentType)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to process image: %v", err)
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	profile, err := tx.FindProfileByUserIdForUpdate(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		}
+	}
+
+	avatarId, err := newAvatarId()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate avatar id: %v", err)
+	}
+
+	// Images are stored before profile refers to them, so avatar URLs never lead nowhere
+	for _, image := range images {
+		err = s.blobs.Put(ctx, avatarKey(userId, avatarId, image.size), image.data)
+		if err != nil {
+			s.discardAvatar(ctx, userId, avatarId)
+			return nil, status.Errorf(codes.Internal, "failed to store image: %v", err)
+		}
+	}
+
+	now := time.Now()
+	err = tx.SetProfileAvatar(ctx, userId, avatarId, contentType, now)
+	if err != nil {
+		s.discardAvatar(ctx, userId, avatarId)
+		return nil, status.Errorf(codes.Internal, "failed to set avatar: %v", err)
+	}
+
+	updated := *profile
+	updated.AvatarId = avatarId
+	err = recordProfileChange(ctx, tx, *profile, updated, now)
+	if err != nil {
+		s.discardAvatar(ctx, userId, avatarId)
+		return nil, status.Errorf(codes.Internal, "failed to record profile change: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.discardAvatar(ctx, userId, avatarId)
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	if profile.AvatarId != "" {
+		s.discardAvatar(ctx, userId, profile.AvatarId)
+	}
+
+	return &pb.UploadAvatarResponse{Avatars: s.avatarsToPb(userId, avatarId)}, nil
+}
+
+func (s UserService) DeleteAvatar(ctx context.Context, req *pb.DeleteAvatarRequest) (*pb.DeleteAvatarResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	profile, err := tx.FindProfileByUserIdForUpdate(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		}
+	}
+
+	if profile.AvatarId == "" {
+		return &pb.DeleteAvatarResponse{}, nil
+	}
+
+	now := time.Now()
+	err = tx.SetProfileAvatar(ctx, userId, "", "", now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove avatar: %v", err)
+	}
+
+	updated := *profile
+	updated.AvatarId = ""
+	err = recordProfileChange(ctx, tx, *profile, updated, now)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record profile change: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	s.discardAvatar(ctx, userId, profile.AvatarId)
+
+	return &pb.DeleteAvatarResponse{}, nil
+}
+
+func (s UserService) GetAvatar(ctx context.Context, req *pb.GetAvatarRequest) (*pb.GetAvatarResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	viewerId, err := parseViewerId(req.ViewerId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed viewer id")
+	}
+
+	if !slices.Contains(avatarSizes, int(req.Size)) {
+		return nil, status.Errorf(codes.InvalidArgument, "avatar size must be one of %v", avatarSizes)
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	profile, err := tx.FindProfileByUserId(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		}
+	}
+
+	// Replaced avatars are gone, even if their images weren't deleted yet
+	if profile.AvatarId == "" || profile.AvatarId != req.AvatarId {
+		return nil, status.Error(codes.NotFound, "no such avatar")
+	}
+
+	// Hidden avatar is indistinguishable from absent one
+	viewer, err := s.resolveProfileViewer(ctx, profile, viewerId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check followers: %v", err)
+	}
+	if !viewer.sees(profile.Visibility.Avatar) {
+		return nil, status.Error(codes.NotFound, "no such avatar")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	image, err := s.blobs.Get(ctx, avatarKey(userId, profile.AvatarId, int(req.Size)))
+	if err != nil {
+		if err == errNoSuchBlob {
+			return nil, status.Error(codes.NotFound, "no such avatar")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to get image: %v", err)
+		}
+	}
+
+	return &pb.GetAvatarResponse{
+		Image:       image,
+		ContentType: profile.AvatarContentType,
+		Public:      profile.Visibility.Avatar == storage.VisibilityPublic,
+	}, nil
+}
+
+func (s UserService) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UpdateProfileResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	paths, err := profileUpdateFields(req.UpdateMask)
+	if err != nil {
+		return nil, statusWithFieldViolations(codes.InvalidArgument, "invalid update mask", []*errdetails.BadRequest_FieldViolation{
+			{Field: "update_mask", Description: err.Error()},
+		})
+	}
+
+	time := time.Now()
+	update, violations := normalizeProfileUpdate(req.Profile, paths, time)
+	if len(violations) > 0 {
+		return nil, statusWithFieldViolations(codes.InvalidArgument, "invalid profile", violations)
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	oldProfile, err := tx.FindProfileByUserIdForUpdate(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		}
+	}
+
+	profile := applyProfileUpdate(*oldProfile, update, paths)
+	profile.LastUpdateTime = &time
+
+	log.Printf("profile: %v", profile)
+
+	err = tx.UpdateProfile(ctx, profile)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "update profile failed: %v", err)
+	}
+
+	err = recordProfileChange(ctx, tx, *oldProfile, profile, time)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record profile change: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return &pb.UpdateProfileResponse{}, nil
+}
+
+func (s UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	user, err := tx.FindUserById(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no user for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find user by userId: %v", err)
+		}
+	}
+
+	return &pb.GetUserResponse{Login: string(user.Login[:]), Email: string(user.Email[:]), EmailVerified: user.EmailVerified}, nil
+}
+
+func (s UserService) GetProfile(ctx context.Context, req *pb.GetProfileRequest) (*pb.GetProfileResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	viewerId, err := parseViewerId(req.ViewerId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed viewer id")
+	}
+
+	profile, err := tx.FindProfileByUserId(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		}
+	}
+
+	viewer, err := s.resolveProfileViewer(ctx, profile, viewerId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check followers: %v", err)
+	}
+
+	return &pb.GetProfileResponse{Profile: s.profileToPb(profile, viewer)}, nil
+}
+
+func (s UserService) GetProfileHistory(ctx context.Context, req *pb.GetProfileHistoryRequest) (*pb.GetProfileHistoryResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	userId, err := uuid.Parse(req.Id.Uuid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed id")
+	}
+
+	var after *storage.ProfileChangeCursor
+	if req.PageToken != "" {
+		after, err = decodeProfileHistoryPageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
+		}
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.FindProfileByUserId(ctx, userId)
+	if err != nil {
+		if err == storage.ErrNoSuchUser {
+			return nil, status.Error(codes.NotFound, "no profile for provided used id")
+		} else {
+			return nil, status.Errorf(codes.Internal, "failed to find profile by userId: %v", err)
+		}
+	}
+
+	pageSize := profileHistoryPageSize(req.PageSize)
+
+	// One extra change tells whether there is the next page
+	changes, err := tx.ListProfileChanges(ctx, userId, after, pageSize+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list profile changes: %v", err)
+	}
+
+	response := &pb.GetProfileHistoryResponse{}
+	if len(changes) > pageSize {
+		changes = changes[:pageSize]
+		response.NextPageToken = encodeProfileHistoryPageToken(&changes[pageSize-1])
+	}
+	for i := range changes {
+		response.Changes = append(response.Changes, profileChangeToPb(&changes[i]))
+	}
+
+	return response, nil
+}
+
+// BatchGetUsers looks up several users with one query, e.g. to render a list of them.
+func (s UserService) BatchGetUsers(ctx context.Context, req *pb.BatchGetUsersRequest) (*pb.BatchGetUsersResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if len(req.Ids) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %v ids may be requested at once", maxBatchSize)
+	}
+	userIds, err := parseBatchIds(req.Ids)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to parse passed ids: %v", err)
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	users, err := tx.FindUsersByIds(ctx, userIds)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find users by ids: %v", err)
+	}
+
+	found := make(map[uuid.UUID]*storage.User, len(users))
+	for i := range users {
+		found[users[i].UserId] = &users[i]
+	}
+
+	response := &pb.BatchGetUsersResponse{}
+	for _, userId := range userIds {
+		user, ok := found[userId]
+		if !ok {
+			response.MissingIds = append(response.MissingIds, &shared.Id{Uuid: userId.String()})
+			continue
+		}
+		response.Users = append(response.Users, &pb.BatchUser{
+			Id:            &shared.Id{Uuid: userId.String()},
+			Login:         user.Login,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+		})
+	}
+
+	return response, nil
+}
+
+// BatchGetProfiles is GetProfile for several users, profiles are redacted for the viewer just the same.
+func (s UserService) BatchGetProfiles(ctx context.Context, req *pb.BatchGetProfilesRequest) (*pb.BatchGetProfilesResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if len(req.Ids) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %v ids may be requested at once", maxBatchSize)
+	}
+	userIds, err := parseBatchIds(req.Ids)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to parse passed ids: %v", err)
+	}
+
+	viewerId, err := parseViewerId(req.ViewerId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed viewer id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	profiles, err := tx.FindProfilesByUserIds(ctx, userIds)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find profiles by userIds: %v", err)
+	}
+
+	found := make(map[uuid.UUID]*storage.Profile, len(profiles))
+	for i := range profiles {
+		found[profiles[i].UserId] = &profiles[i]
+	}
+
+	response := &pb.BatchGetProfilesResponse{}
+	for _, userId := range userIds {
+		profile, ok := found[userId]
+		if !ok {
+			response.MissingIds = append(response.MissingIds, &shared.Id{Uuid: userId.String()})
+			continue
+		}
+
+		viewer, err := s.resolveProfileViewer(ctx, profile, viewerId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check followers: %v", err)
+		}
+		response.Profiles = append(response.Profiles, &pb.BatchProfile{
+			Id:      &shared.Id{Uuid: userId.String()},
+			Profile: s.profileToPb(profile, viewer),
+		})
+	}
+
+	return response, nil
+}
+
+// SearchUsers finds users by login and by names visible to everybody, the most relevant first.
+func (s UserService) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query, err := normalizeSearchQuery(req.Query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var after *storage.UserSearchCursor
+	if req.PageToken != "" {
+		after, err = decodeSearchPageToken(query, req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
+		}
+	}
+
+	viewerId, err := parseViewerId(req.ViewerId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to parse passed viewer id")
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	pageSize := searchPageSize(req.PageSize)
+
+	// One extra user tells whether there is the next page
+	users, err := tx.SearchUsers(ctx, query, after, pageSize+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to search users: %v", err)
+	}
+
+	response := &pb.SearchUsersResponse{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		response.NextPageToken = encodeSearchPageToken(query, &users[pageSize-1])
+	}
+
+	userIds := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		userIds = append(userIds, user.User.UserId)
+	}
+	profiles, err := tx.FindProfilesByUserIds(ctx, userIds)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find profiles by userIds: %v", err)
+	}
+	profileById := make(map[uuid.UUID]*storage.Profile, len(profiles))
+	for i := range profiles {
+		profileById[profiles[i].UserId] = &profiles[i]
+	}
+
+	for _, user := range users {
+		found := &pb.FoundUser{
+			Id:    &shared.Id{Uuid: user.User.UserId.String()},
+			Login: user.User.Login,
+		}
+		if profile, ok := profileById[user.User.UserId]; ok {
+			viewer, err := s.resolveProfileViewer(ctx, profile, viewerId)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to check followers: %v", err)
+			}
+			found.Profile = s.profileToPb(profile, viewer)
+		}
+		response.Users = append(response.Users, found)
+	}
+
+	return response, nil
+}
+
+func NewUserService(config Config) (*UserService, error) {
+	jwtManager, err := NewJwtManager(config.JwtPrivateFile, config.JwtRetiringFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize UserService: failed to initialize jwt manager: %w", err)
+	}
+
+	store := config.Store
+	if store == nil {
+		store, err = storage.NewStorage(config.DatabaseUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize UserService: failed to initialize storage: %w", err)
+		}
+	}
+
+	followers := config.FollowerChecker
+	if followers == nil {
+		followers = noFollowers{}
 	}
 
 	return &UserService{
-		storage: storage,
-		jwtManager: JwtManager{
-			jwtPrivate: jwtPrivate,
-			// jwtPublic:  jwtPublic,
-		},
+		storage:    store,
+		jwtManager: jwtManager,
+		hasher:     config.PasswordHasher,
+		mailer:     config.Mailer,
+		publisher:  config.EventPublisher,
+		blobs:      config.BlobStore,
+		followers:  followers,
+
+		passwordResetUrl:     config.PasswordResetUrl,
+		emailVerificationUrl: config.EmailVerificationUrl,
+		avatarUrl:            config.AvatarUrl,
+		deletionGracePeriod:  config.DeletionGracePeriod,
 	}, nil
 }
@@ -4,6 +4,11 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
 )
 
 func TestCheckLoginCorrectness(t *testing.T) {
@@ -48,3 +53,96 @@ func TestCheckLoginCorrectness(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		expected string
+	}{
+		{
+			name:     "Already normalized",
+			email:    "user@example.com",
+			expected: "user@example.com",
+		},
+		{
+			name:     "Mixed case",
+			email:    "User.Name@Example.COM",
+			expected: "user.name@example.com",
+		},
+		{
+			name:     "Surrounding spaces",
+			email:    "  user@example.com\t",
+			expected: "user@example.com",
+		},
+		{
+			name:     "Display name",
+			email:    "User <User@example.com>",
+			expected: "user@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if email := normalizeEmail(tt.email); email != tt.expected {
+				t.Errorf("normalizeEmail(%q) returned %q, where %q expected", tt.email, email, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNextEmailVerification(t *testing.T) {
+	now := time.Now()
+	user := &storage.User{UserId: uuid.New()}
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name          string
+		prev          *storage.EmailVerification
+		expectedDelay time.Duration
+		expectedSent  int
+	}{
+		{
+			name:          "First",
+			prev:          nil,
+			expectedDelay: 0,
+			expectedSent:  1,
+		},
+		{
+			name:          "Too often",
+			prev:          &storage.EmailVerification{LastSentTime: at(-time.Second * 20), WindowStartTime: at(-time.Hour), SentInWindow: 2},
+			expectedDelay: time.Second * 40,
+			expectedSent:  2,
+		},
+		{
+			name:          "Window exhausted",
+			prev:          &storage.EmailVerification{LastSentTime: at(-time.Hour), WindowStartTime: at(-time.Hour * 20), SentInWindow: verificationMaxInWindow},
+			expectedDelay: time.Hour * 4,
+			expectedSent:  verificationMaxInWindow,
+		},
+		{
+			name:          "Within window",
+			prev:          &storage.EmailVerification{LastSentTime: at(-time.Hour), WindowStartTime: at(-time.Hour * 2), SentInWindow: 2},
+			expectedDelay: 0,
+			expectedSent:  3,
+		},
+		{
+			name:          "New window",
+			prev:          &storage.EmailVerification{LastSentTime: at(-time.Hour * 25), WindowStartTime: at(-time.Hour * 30), SentInWindow: verificationMaxInWindow},
+			expectedDelay: 0,
+			expectedSent:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, delay := nextEmailVerification(tt.prev, user, now)
+			if delay != tt.expectedDelay || next.SentInWindow != tt.expectedSent {
+				t.Errorf("nextEmailVerification returned delay %v and %v sent, where %v and %v expected", delay, next.SentInWindow, tt.expectedDelay, tt.expectedSent)
+			}
+		})
+	}
+}
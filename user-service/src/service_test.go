@@ -0,0 +1,1011 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	shared "soa-project/shared/proto"
+	pb "soa-project/user-service/proto"
+	"soa-project/user-service/storage"
+	"soa-project/user-service/storage/memory"
+)
+
+// recordingMailer keeps sent messages instead of delivering them.
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// newTestService returns service backed by the in-memory store.
+func newTestService(t *testing.T) (*UserService, *recordingMailer) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := NewPasswordHasher(passwordSchemeBcrypt, bcrypt.MinCost, DefaultArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := NewDirectoryBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &recordingMailer{}
+	return &UserService{
+		storage:    memory.NewStore(),
+		jwtManager: newJwtManager(key, nil),
+		hasher:     hasher,
+		mailer:     mailer,
+		blobs:      blobs,
+		followers:  noFollowers{},
+		avatarUrl:  "/avatars/",
+	}, mailer
+}
+
+func register(t *testing.T, s *UserService, login string) string {
+	t.Helper()
+	resp, err := s.Register(context.Background(), &pb.RegisterRequest{Login: login, Email: login + "@example.com", Password: "ValidPass1!"})
+	if err != nil {
+		t.Fatalf("Register returned %v", err)
+	}
+	return resp.Id.Uuid
+}
+
+func TestRegisterAndAuth(t *testing.T) {
+	s, mailer := newTestService(t)
+	ctx := context.Background()
+	userId := register(t, s, "alice")
+
+	if len(mailer.messages) != 1 || mailer.messages[0].To != "alice@example.com" {
+		t.Errorf("Register sent %+v, where single verification message expected", mailer.messages)
+	}
+
+	registrations := []struct {
+		name     string
+		req      *pb.RegisterRequest
+		expected codes.Code
+	}{
+		{
+			name:     "Login in other case",
+			req:      &pb.RegisterRequest{Login: "ALICE", Email: "other@example.com", Password: "ValidPass1!"},
+			expected: codes.AlreadyExists,
+		},
+		{
+			name:     "Email in other case",
+			req:      &pb.RegisterRequest{Login: "bob", Email: "Alice@Example.com", Password: "ValidPass1!"},
+			expected: codes.AlreadyExists,
+		},
+		{
+			name:     "Invalid password",
+			req:      &pb.RegisterRequest{Login: "bob", Email: "bob@example.com", Password: "short"},
+			expected: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range registrations {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Register(ctx, tt.req)
+			if status.Code(err) != tt.expected {
+				t.Errorf("Register returned %v, where %v expected", err, tt.expected)
+			}
+		})
+	}
+
+	auths := []struct {
+		name     string
+		req      *pb.AuthRequest
+		expected codes.Code
+	}{
+		{
+			name:     "By login",
+			req:      &pb.AuthRequest{Login: "alice", Password: "ValidPass1!"},
+			expected: codes.OK,
+		},
+		{
+			name:     "By email",
+			req:      &pb.AuthRequest{Email: "ALICE@example.com", Password: "ValidPass1!"},
+			expected: codes.OK,
+		},
+		{
+			name:     "Wrong password",
+			req:      &pb.AuthRequest{Login: "alice", Password: "WrongPass1!"},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name:     "Unknown user",
+			req:      &pb.AuthRequest{Login: "bob", Password: "ValidPass1!"},
+			expected: codes.NotFound,
+		},
+	}
+	for _, tt := range auths {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Auth(ctx, tt.req)
+			if status.Code(err) != tt.expected {
+				t.Fatalf("Auth returned %v, where %v expected", err, tt.expected)
+			}
+			if err == nil && (resp.Id.Uuid != userId || resp.Jwt == "" || resp.RefreshToken == "") {
+				t.Errorf("Auth returned %v, where tokens of %v expected", resp, userId)
+			}
+		})
+	}
+
+	profile, err := s.GetProfile(ctx, &pb.GetProfileRequest{Id: &shared.Id{Uuid: userId}})
+	if err != nil {
+		t.Fatalf("GetProfile returned %v", err)
+	}
+	if profile.Profile.CreationTime == nil {
+		t.Errorf("GetProfile returned %v, where profile created on registration expected", profile)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	register(t, s, "alice")
+
+	auth, err := s.Auth(ctx, &pb.AuthRequest{Login: "alice", Password: "ValidPass1!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: auth.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshToken returned %v", err)
+	}
+
+	sessions, err := s.ListSessions(ctx, &pb.ListSessionsRequest{Id: auth.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions.Sessions) != 1 {
+		t.Errorf("ListSessions returned %v sessions, where 1 expected", len(sessions.Sessions))
+	}
+
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: auth.RefreshToken})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken with used token returned %v, where %v expected", err, codes.Unauthenticated)
+	}
+
+	// Reuse revokes the whole family, including the token issued on rotation
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: rotated.RefreshToken})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken after reuse returned %v, where %v expected", err, codes.Unauthenticated)
+	}
+}
+
+func TestChangePasswordThrottling(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+
+	for i := range accountFailurePolicy.backoffAfter {
+		_, err := s.ChangePassword(ctx, &pb.ChangePasswordRequest{Id: id, OldPassword: "WrongPass1!", NewPassword: "NewValidPass1!"})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("ChangePassword with wrong old password #%v returned %v, where %v expected", i+1, err, codes.PermissionDenied)
+		}
+	}
+
+	// Even the right password is refused until the back-off is over
+	for _, oldPassword := range []string{"WrongPass1!", "ValidPass1!"} {
+		_, err := s.ChangePassword(ctx, &pb.ChangePasswordRequest{Id: id, OldPassword: oldPassword, NewPassword: "NewValidPass1!"})
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("ChangePassword with old password %q after repeated failures returned %v, where %v expected", oldPassword, err, codes.ResourceExhausted)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	register(t, s, "alice")
+	bob := &shared.Id{Uuid: register(t, s, "bob")}
+
+	first, err := s.Auth(metadata.NewIncomingContext(ctx, metadata.Pairs(clientUserAgentMetadataKey, "first")), &pb.AuthRequest{Login: "alice", Password: "ValidPass1!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Auth(metadata.NewIncomingContext(ctx, metadata.Pairs(clientUserAgentMetadataKey, "second")), &pb.AuthRequest{Login: "alice", Password: "ValidPass1!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := s.jwtManager.parseAccessToken(first.Jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := s.ListSessions(ctx, &pb.ListSessionsRequest{Id: first.Id})
+	if err != nil {
+		t.Fatalf("ListSessions returned %v", err)
+	}
+	if len(sessions.Sessions) != 2 {
+		t.Fatalf("ListSessions returned %v, where 2 sessions expected", sessions.Sessions)
+	}
+	for _, session := range sessions.Sessions {
+		if (session.SessionId == claims.SessionId) != (session.UserAgent == "first") {
+			t.Errorf("ListSessions returned %v, where only session %v has user agent \"first\"", session, claims.SessionId)
+		}
+	}
+
+	// Sessions of other users look like missing ones
+	_, err = s.RevokeSession(ctx, &pb.RevokeSessionRequest{Id: bob, SessionId: claims.SessionId})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("RevokeSession of another user's session returned %v, where %v expected", err, codes.NotFound)
+	}
+
+	_, err = s.RevokeSession(ctx, &pb.RevokeSessionRequest{Id: first.Id, SessionId: claims.SessionId})
+	if err != nil {
+		t.Fatalf("RevokeSession returned %v", err)
+	}
+	_, err = s.RevokeSession(ctx, &pb.RevokeSessionRequest{Id: first.Id, SessionId: claims.SessionId})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("RevokeSession of revoked session returned %v, where %v expected", err, codes.NotFound)
+	}
+
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: first.RefreshToken})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken of revoked session returned %v, where %v expected", err, codes.Unauthenticated)
+	}
+
+	revocations, err := s.ListRevocations(ctx, &pb.ListRevocationsRequest{})
+	if err != nil {
+		t.Fatalf("ListRevocations returned %v", err)
+	}
+	revoked := slices.ContainsFunc(revocations.Revocations, func(r *pb.Revocation) bool {
+		return r.Kind == storage.RevocationKindSession && r.Subject == claims.SessionId
+	})
+	if !revoked {
+		t.Errorf("ListRevocations returned %v, where revocation of session %v expected", revocations.Revocations, claims.SessionId)
+	}
+
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: second.RefreshToken})
+	if err != nil {
+		t.Errorf("RefreshToken of another session returned %v", err)
+	}
+	sessions, err = s.ListSessions(ctx, &pb.ListSessionsRequest{Id: first.Id})
+	if err != nil {
+		t.Fatalf("ListSessions returned %v", err)
+	}
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].UserAgent != "second" {
+		t.Errorf("ListSessions returned %v after revocation, where only the second session expected", sessions.Sessions)
+	}
+}
+
+func TestAvatarLifecycle(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+
+	_, err := s.UploadAvatar(ctx, &pb.UploadAvatarRequest{Id: id, Image: []byte("not an image")})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UploadAvatar of text returned %v, where %v expected", err, codes.InvalidArgument)
+	}
+
+	first, err := s.UploadAvatar(ctx, &pb.UploadAvatarRequest{Id: id, Image: encodeTestImage(t, halves(100, 80), "png")})
+	if err != nil {
+		t.Fatalf("UploadAvatar returned %v", err)
+	}
+	if len(first.Avatars) != len(avatarSizes) || first.Avatars[0].Url == "" {
+		t.Fatalf("UploadAvatar returned %v, where URLs of every size expected", first.Avatars)
+	}
+
+	profile, err := s.GetProfile(ctx, &pb.GetProfileRequest{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Profile.Avatars) != len(avatarSizes) || profile.Profile.Avatars[0].Url != first.Avatars[0].Url {
+		t.Errorf("GetProfile returned avatars %v, where %v expected", profile.Profile.Avatars, first.Avatars)
+	}
+
+	// URL ends with /<avatar id>/<size>
+	parts := strings.Split(first.Avatars[0].Url, "/")
+	firstId := parts[len(parts)-2]
+	avatar, err := s.GetAvatar(ctx, &pb.GetAvatarRequest{Id: id, AvatarId: firstId, Size: 64})
+	if err != nil {
+		t.Fatalf("GetAvatar returned %v", err)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(avatar.Image))
+	if err != nil || avatar.ContentType != "image/png" || config.Width != 64 {
+		t.Errorf("GetAvatar returned %v image %vpx wide (%v), where 64px png expected", avatar.ContentType, config.Width, err)
+	}
+
+	if !avatar.Public {
+		t.Errorf("GetAvatar returned avatar with default visibility as not public")
+	}
+
+	_, err = s.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+		Id:         id,
+		Profile:    &shared.Profile{Visibility: &shared.ProfileVisibility{Avatars: shared.Visibility_VISIBILITY_ONLY_ME}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"visibility.avatars"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+	_, err = s.GetAvatar(ctx, &pb.GetAvatarRequest{Id: id, AvatarId: firstId, Size: 64})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetAvatar of hidden avatar returned %v to anonymous viewer, where %v expected", err, codes.NotFound)
+	}
+	avatar, err = s.GetAvatar(ctx, &pb.GetAvatarRequest{Id: id, AvatarId: firstId, Size: 64, ViewerId: id})
+	if err != nil || avatar.Public {
+		t.Errorf("GetAvatar of hidden avatar returned %v to the owner, where not public image expected", err)
+	}
+
+	// The same image gets a new id, so URLs can't be derived from the image
+	again, err := s.UploadAvatar(ctx, &pb.UploadAvatarRequest{Id: id, Image: encodeTestImage(t, halves(100, 80), "png")})
+	if err != nil {
+		t.Fatalf("UploadAvatar returned %v", err)
+	}
+	if again.Avatars[0].Url == first.Avatars[0].Url {
+		t.Errorf("UploadAvatar of the same image returned the same URL %v", again.Avatars[0].Url)
+	}
+	_, err = s.GetAvatar(ctx, &pb.GetAvatarRequest{Id: id, AvatarId: firstId, Size: 64, ViewerId: id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetAvatar of replaced avatar returned %v, where %v expected", err, codes.NotFound)
+	}
+
+	_, err = s.DeleteAvatar(ctx, &pb.DeleteAvatarRequest{Id: id})
+	if err != nil {
+		t.Fatalf("DeleteAvatar returned %v", err)
+	}
+	profile, err = s.GetProfile(ctx, &pb.GetProfileRequest{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Profile.Avatars) != 0 {
+		t.Errorf("GetProfile returned avatars %v after deletion", profile.Profile.Avatars)
+	}
+}
+
+func TestUpdateProfileMask(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+
+	full := &shared.Profile{Name: "Alice", Surname: "Smith", PhoneNumber: "+15550100", Birthday: &shared.Date{Year: 2000, Month: 1, Day: 2}}
+	_, err := s.UpdateProfile(ctx, &pb.UpdateProfileRequest{Id: id, Profile: full})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		profile  *shared.Profile
+		paths    []string
+		code     codes.Code
+		expected *shared.Profile
+	}{
+		{
+			name:     "single field",
+			profile:  &shared.Profile{Name: "Alicia"},
+			paths:    []string{"name"},
+			code:     codes.OK,
+			expected: &shared.Profile{Name: "Alicia", Surname: "Smith", PhoneNumber: "+15550100", Birthday: full.Birthday},
+		},
+		{
+			name:     "listed but absent field is cleared",
+			profile:  &shared.Profile{Surname: "Ignored"},
+			paths:    []string{"phone_number", "birthday"},
+			code:     codes.OK,
+			expected: &shared.Profile{Name: "Alicia", Surname: "Smith"},
+		},
+		{
+			name:     "unknown field",
+			profile:  &shared.Profile{},
+			paths:    []string{"avatars"},
+			code:     codes.InvalidArgument,
+			expected: &shared.Profile{Name: "Alicia", Surname: "Smith"},
+		},
+		{
+			name:     "empty mask replaces everything",
+			profile:  &shared.Profile{Name: "Alice"},
+			code:     codes.OK,
+			expected: &shared.Profile{Name: "Alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.UpdateProfile(ctx, &pb.UpdateProfileRequest{Id: id, Profile: tt.profile, UpdateMask: &fieldmaskpb.FieldMask{Paths: tt.paths}})
+			if status.Code(err) != tt.code {
+				t.Fatalf("UpdateProfile returned %v, where %v expected", err, tt.code)
+			}
+
+			resp, err := s.GetProfile(ctx, &pb.GetProfileRequest{Id: id, ViewerId: id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := resp.Profile
+			if got.Name != tt.expected.Name || got.Surname != tt.expected.Surname || got.PhoneNumber != tt.expected.PhoneNumber ||
+				got.Birthday.GetYear() != tt.expected.Birthday.GetYear() || got.Birthday.GetDay() != tt.expected.Birthday.GetDay() {
+				t.Errorf("GetProfile returned %v, where %v expected", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProfileHistory(t *testing.T) {
+	s, _ := newTestService(t)
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+	admin := uuid.New()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientIpMetadataKey, "10.0.0.1", actorIdMetadataKey, admin.String()))
+
+	updates := []*pb.UpdateProfileRequest{
+		{Id: id, Profile: &shared.Profile{Name: "Alice", Birthday: &shared.Date{Year: 2000, Month: 1, Day: 2}}},
+		// Unchanged profile leaves no record
+		{Id: id, Profile: &shared.Profile{Name: "Alice", Birthday: &shared.Date{Year: 2000, Month: 1, Day: 2}}},
+		{Id: id, Profile: &shared.Profile{Name: "Alicia"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}}},
+	}
+	for _, update := range updates {
+		if _, err := s.UpdateProfile(ctx, update); err != nil {
+			t.Fatalf("UpdateProfile returned %v", err)
+		}
+	}
+	if _, err := s.UploadAvatar(context.Background(), &pb.UploadAvatarRequest{Id: id, Image: encodeTestImage(t, halves(50, 50), "png")}); err != nil {
+		t.Fatalf("UploadAvatar returned %v", err)
+	}
+
+	var changes []*pb.ProfileChange
+	token := ""
+	for {
+		resp, err := s.GetProfileHistory(context.Background(), &pb.GetProfileHistoryRequest{Id: id, PageSize: 1, PageToken: token})
+		if err != nil {
+			t.Fatalf("GetProfileHistory returned %v", err)
+		}
+		changes = append(changes, resp.Changes...)
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("GetProfileHistory returned %v, where 3 changes expected", changes)
+	}
+	if fields := changes[0].Fields; len(fields) != 1 || fields[0].Field != "avatar" || changes[0].ActorId.Uuid != id.Uuid {
+		t.Errorf("latest change is %v, where avatar upload by the owner expected", changes[0])
+	}
+	if fields := changes[1].Fields; len(fields) != 1 || fields[0].OldValue != "Alice" || fields[0].NewValue != "Alicia" {
+		t.Errorf("second change is %v, where name change expected", changes[1])
+	}
+	first := changes[2]
+	if len(first.Fields) != 2 || first.Fields[1].Field != "birthday" || first.Fields[1].NewValue != "2000-01-02" ||
+		first.ActorId.Uuid != admin.String() || first.Ip != "10.0.0.1" {
+		t.Errorf("first change is %v, where name and birthday set by %v from 10.0.0.1 expected", first, admin)
+	}
+
+	_, err := s.GetProfileHistory(context.Background(), &pb.GetProfileHistoryRequest{Id: id, PageToken: "garbage"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetProfileHistory with malformed page token returned %v, where %v expected", err, codes.InvalidArgument)
+	}
+}
+
+func TestUpdateProfileValidation(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+
+	_, err := s.UpdateProfile(ctx, &pb.UpdateProfileRequest{Id: id, Profile: &shared.Profile{
+		Name:        "Alice",
+		PhoneNumber: "12345678901234567890123",
+		Birthday:    &shared.Date{Year: 2000, Month: 13, Day: 1},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("UpdateProfile returned %v, where %v expected", err, codes.InvalidArgument)
+	}
+
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				fields = append(fields, violation.Field)
+			}
+		}
+	}
+	if !slices.Equal(fields, []string{"phone_number", "birthday"}) {
+		t.Errorf("UpdateProfile reported violations of %v, where phone_number and birthday expected", fields)
+	}
+
+	_, err = s.UpdateProfile(ctx, &pb.UpdateProfileRequest{Id: id, Profile: &shared.Profile{Name: " Alice ", PhoneNumber: "+1 555 010-00-00"}})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+	resp, err := s.GetProfile(ctx, &pb.GetProfileRequest{Id: id, ViewerId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile.Name != "Alice" || resp.Profile.PhoneNumber != "+15550100000" {
+		t.Errorf("GetProfile returned %v, where normalized name and phone number expected", resp.Profile)
+	}
+}
+
+// staticFollowers knows followers of every user in advance.
+type staticFollowers map[uuid.UUID][]uuid.UUID
+
+func (f staticFollowers) IsFollower(ctx context.Context, userId uuid.UUID, followerId uuid.UUID) (bool, error) {
+	return slices.Contains(f[userId], followerId), nil
+}
+
+func TestProfileVisibility(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	alice := &shared.Id{Uuid: register(t, s, "alice")}
+	bob := &shared.Id{Uuid: register(t, s, "bob")}
+	carol := &shared.Id{Uuid: register(t, s, "carol")}
+	s.followers = staticFollowers{uuid.MustParse(alice.Uuid): {uuid.MustParse(bob.Uuid)}}
+
+	_, err := s.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+		Id: alice,
+		Profile: &shared.Profile{
+			Name:        "Alice",
+			PhoneNumber: "+15550100",
+			Birthday:    &shared.Date{Year: 2000, Month: 1, Day: 2},
+			Visibility:  &shared.ProfileVisibility{PhoneNumber: shared.Visibility_VISIBILITY_FOLLOWERS},
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name", "phone_number", "birthday", "visibility.phone_number"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		viewer     *shared.Id
+		phone      bool
+		birthday   bool
+		visibility bool
+	}{
+		{
+			name:   "Anonymous",
+			viewer: nil,
+		},
+		{
+			name:   "Stranger",
+			viewer: carol,
+		},
+		{
+			name:   "Follower",
+			viewer: bob,
+			phone:  true,
+		},
+		{
+			name:       "Owner",
+			viewer:     alice,
+			phone:      true,
+			birthday:   true,
+			visibility: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.GetProfile(ctx, &pb.GetProfileRequest{Id: alice, ViewerId: tt.viewer})
+			if err != nil {
+				t.Fatal(err)
+			}
+			profile := resp.Profile
+			if profile.Name != "Alice" || (profile.PhoneNumber != "") != tt.phone || (profile.Birthday != nil) != tt.birthday ||
+				(profile.Visibility != nil) != tt.visibility {
+				t.Errorf("GetProfile returned %v, where phone=%v, birthday=%v, visibility=%v expected", profile, tt.phone, tt.birthday, tt.visibility)
+			}
+		})
+	}
+
+	_, err = s.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+		Id:         alice,
+		Profile:    &shared.Profile{Visibility: &shared.ProfileVisibility{Name: shared.Visibility(42)}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"visibility"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateProfile with unknown visibility returned %v, where %v expected", err, codes.InvalidArgument)
+	}
+
+	// Unspecified visibility stands for the default
+	_, err = s.UpdateProfile(ctx, &pb.UpdateProfileRequest{Id: alice, Profile: &shared.Profile{}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"visibility"}}})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+	resp, err := s.GetProfile(ctx, &pb.GetProfileRequest{Id: alice, ViewerId: bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile.PhoneNumber != "" {
+		t.Errorf("GetProfile returned phone number %q to follower after visibility was reset", resp.Profile.PhoneNumber)
+	}
+}
+
+func TestBatchGet(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	alice := &shared.Id{Uuid: register(t, s, "alice")}
+	bob := &shared.Id{Uuid: register(t, s, "bob")}
+	unknown := &shared.Id{Uuid: uuid.NewString()}
+
+	_, err := s.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+		Id:      bob,
+		Profile: &shared.Profile{Name: "Bob", PhoneNumber: "+15550100"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+
+	ids := []*shared.Id{bob, unknown, alice, bob}
+	users, err := s.BatchGetUsers(ctx, &pb.BatchGetUsersRequest{Ids: ids})
+	if err != nil {
+		t.Fatalf("BatchGetUsers returned %v", err)
+	}
+	if len(users.Users) != 2 || users.Users[0].Login != "bob" || users.Users[1].Login != "alice" {
+		t.Errorf("BatchGetUsers returned %v, where bob and alice expected", users.Users)
+	}
+	if len(users.MissingIds) != 1 || users.MissingIds[0].Uuid != unknown.Uuid {
+		t.Errorf("BatchGetUsers returned missing ids %v, where [%v] expected", users.MissingIds, unknown.Uuid)
+	}
+
+	profiles, err := s.BatchGetProfiles(ctx, &pb.BatchGetProfilesRequest{Ids: ids})
+	if err != nil {
+		t.Fatalf("BatchGetProfiles returned %v", err)
+	}
+	if len(profiles.Profiles) != 2 || profiles.Profiles[0].Id.Uuid != bob.Uuid || profiles.Profiles[1].Id.Uuid != alice.Uuid {
+		t.Fatalf("BatchGetProfiles returned %v, where profiles of bob and alice expected", profiles.Profiles)
+	}
+	if bobProfile := profiles.Profiles[0].Profile; bobProfile.Name != "Bob" || bobProfile.PhoneNumber != "" || bobProfile.Visibility != nil {
+		t.Errorf("BatchGetProfiles returned %v to anonymous viewer, where phone number and visibility hidden expected", bobProfile)
+	}
+	if len(profiles.MissingIds) != 1 || profiles.MissingIds[0].Uuid != unknown.Uuid {
+		t.Errorf("BatchGetProfiles returned missing ids %v, where [%v] expected", profiles.MissingIds, unknown.Uuid)
+	}
+
+	profiles, err = s.BatchGetProfiles(ctx, &pb.BatchGetProfilesRequest{Ids: ids, ViewerId: bob})
+	if err != nil {
+		t.Fatalf("BatchGetProfiles returned %v", err)
+	}
+	if bobProfile := profiles.Profiles[0].Profile; bobProfile.PhoneNumber != "+15550100" || bobProfile.Visibility == nil {
+		t.Errorf("BatchGetProfiles returned %v to the owner, where full profile expected", bobProfile)
+	}
+
+	tooMany := make([]*shared.Id, maxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = &shared.Id{Uuid: uuid.NewString()}
+	}
+	_, err = s.BatchGetUsers(ctx, &pb.BatchGetUsersRequest{Ids: tooMany})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchGetUsers of %v ids returned %v, where %v expected", len(tooMany), err, codes.InvalidArgument)
+	}
+	_, err = s.BatchGetProfiles(ctx, &pb.BatchGetProfilesRequest{Ids: tooMany})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchGetProfiles of %v ids returned %v, where %v expected", len(tooMany), err, codes.InvalidArgument)
+	}
+
+	malformed := []*shared.Id{alice, {Uuid: "not-a-uuid"}}
+	_, err = s.BatchGetUsers(ctx, &pb.BatchGetUsersRequest{Ids: malformed})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchGetUsers of malformed id returned %v, where %v expected", err, codes.InvalidArgument)
+	}
+	_, err = s.BatchGetProfiles(ctx, &pb.BatchGetProfilesRequest{Ids: malformed})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchGetProfiles of malformed id returned %v, where %v expected", err, codes.InvalidArgument)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	alice := register(t, s, "alice")
+	alicia := register(t, s, "alicia")
+	bob := register(t, s, "bob")
+	register(t, s, "carol")
+
+	_, err := s.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+		Id:      &shared.Id{Uuid: bob},
+		Profile: &shared.Profile{Name: "Alice", PhoneNumber: "+15550100"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile returned %v", err)
+	}
+
+	var found []string
+	pageToken := ""
+	for range 4 {
+		resp, err := s.SearchUsers(ctx, &pb.SearchUsersRequest{Query: " Alic ", PageSize: 1, PageToken: pageToken})
+		if err != nil {
+			t.Fatalf("SearchUsers returned %v", err)
+		}
+		for _, user := range resp.Users {
+			found = append(found, user.Id.Uuid)
+			if user.Id.Uuid == bob && (user.Profile.GetName() != "Alice" || user.Profile.GetPhoneNumber() != "") {
+				t.Errorf("SearchUsers returned profile %v to anonymous viewer, where name only expected", user.Profile)
+			}
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	// Login prefixes go before similar names
+	expected := []string{min(alice, alicia), max(alice, alicia), bob}
+	if !slices.Equal(found, expected) {
+		t.Errorf("SearchUsers returned %v, where %v expected", found, expected)
+	}
+
+	resp, err := s.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "alic", PageSize: 1})
+	if err != nil {
+		t.Fatalf("SearchUsers returned %v", err)
+	}
+	requests := []struct {
+		name string
+		req  *pb.SearchUsersRequest
+	}{
+		{"Empty query", &pb.SearchUsersRequest{Query: "  "}},
+		{"Too long query", &pb.SearchUsersRequest{Query: strings.Repeat("a", maxSearchQueryLength+1)}},
+		{"Token of another query", &pb.SearchUsersRequest{Query: "bob", PageToken: resp.NextPageToken}},
+		{"Malformed token", &pb.SearchUsersRequest{Query: "alic", PageToken: "!"}},
+	}
+	for _, tt := range requests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.SearchUsers(ctx, tt.req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("SearchUsers returned %v, where %v expected", err, codes.InvalidArgument)
+			}
+		})
+	}
+}
+
+// enableTotp enrolls and confirms second factor of the user with code of the current step.
+func enableTotp(t *testing.T, s *UserService, id *shared.Id) ([]byte, *pb.ConfirmTotpResponse) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := s.EnrollTotp(ctx, &pb.EnrollTotpRequest{Id: id})
+	if err != nil {
+		t.Fatalf("EnrollTotp returned %v", err)
+	}
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmation, err := s.ConfirmTotp(ctx, &pb.ConfirmTotpRequest{Id: id, Code: totpCode(secret, totpStep(time.Now()), totpDigits)})
+	if err != nil {
+		t.Fatalf("ConfirmTotp returned %v", err)
+	}
+	return secret, confirmation
+}
+
+func TestDisableTotp(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+
+	// Every case gets its own user, so failures of one don't throttle the others
+	rejections := []struct {
+		name     string
+		password string
+		code     func(secret []byte) string
+	}{
+		{
+			name:     "Wrong password",
+			password: "WrongPass1!",
+			code:     func(secret []byte) string { return totpCode(secret, totpStep(time.Now())+1, totpDigits) },
+		},
+		{
+			name:     "Wrong code",
+			password: "ValidPass1!",
+			code:     func(secret []byte) string { return totpCode(secret, totpStep(time.Now())+100, totpDigits) },
+		},
+		{
+			name:     "Replayed code",
+			password: "ValidPass1!",
+			code:     func(secret []byte) string { return totpCode(secret, totpStep(time.Now()), totpDigits) },
+		},
+	}
+	for i, tt := range rejections {
+		t.Run(tt.name, func(t *testing.T) {
+			id := &shared.Id{Uuid: register(t, s, fmt.Sprintf("user%v", i))}
+			secret, _ := enableTotp(t, s, id)
+
+			_, err := s.DisableTotp(ctx, &pb.DisableTotpRequest{Id: id, Password: tt.password, Code: tt.code(secret)})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("DisableTotp returned %v, where %v expected", err, codes.PermissionDenied)
+			}
+		})
+	}
+
+	// Codes are short, so guessing them is throttled
+	guesser := &shared.Id{Uuid: register(t, s, "mallory")}
+	secret, _ := enableTotp(t, s, guesser)
+	for i := range accountFailurePolicy.backoffAfter + 1 {
+		_, err := s.DisableTotp(ctx, &pb.DisableTotpRequest{Id: guesser, Password: "ValidPass1!", Code: totpCode(secret, totpStep(time.Now())+100+int64(i), totpDigits)})
+		expected := codes.PermissionDenied
+		if i == accountFailurePolicy.backoffAfter {
+			expected = codes.ResourceExhausted
+		}
+		if status.Code(err) != expected {
+			t.Errorf("DisableTotp with wrong code #%v returned %v, where %v expected", i+1, err, expected)
+		}
+	}
+
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+	_, confirmation := enableTotp(t, s, id)
+	_, err := s.DisableTotp(ctx, &pb.DisableTotpRequest{Id: id, Password: "ValidPass1!", Code: confirmation.RecoveryCodes[0]})
+	if err != nil {
+		t.Fatalf("DisableTotp returned %v", err)
+	}
+
+	_, err = s.DisableTotp(ctx, &pb.DisableTotpRequest{Id: id, Password: "ValidPass1!", Code: confirmation.RecoveryCodes[1]})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DisableTotp of disabled second factor returned %v, where %v expected", err, codes.FailedPrecondition)
+	}
+
+	auth, err := s.Auth(ctx, &pb.AuthRequest{Login: "alice", Password: "ValidPass1!"})
+	if err != nil || auth.Jwt == "" {
+		t.Errorf("Auth after DisableTotp returned %v (%v), where tokens without second factor expected", auth, err)
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	unused, err := tx.CountUnusedRecoveryCodes(ctx, uuid.MustParse(id.Uuid))
+	if err != nil || unused != 0 {
+		t.Errorf("CountUnusedRecoveryCodes after DisableTotp returned %v (%v), where 0 expected", unused, err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s, _ := newTestService(t)
+	s.deletionGracePeriod = time.Hour
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+
+	_, err := s.DeleteAccount(ctx, &pb.DeleteAccountRequest{Id: id, Password: "WrongPass1!"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DeleteAccount with wrong password returned %v, where %v expected", err, codes.PermissionDenied)
+	}
+
+	secret, confirmation := enableTotp(t, s, id)
+
+	secondFactors := []struct {
+		name string
+		code string
+	}{
+		{name: "Missing code", code: ""},
+		{name: "Code of a distant step", code: totpCode(secret, totpStep(time.Now())+100, totpDigits)},
+	}
+	for _, secondFactor := range secondFactors {
+		t.Run(secondFactor.name, func(t *testing.T) {
+			_, err := s.DeleteAccount(ctx, &pb.DeleteAccountRequest{Id: id, Password: "ValidPass1!", SecondFactorCode: secondFactor.code})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("DeleteAccount returned %v, where %v expected", err, codes.PermissionDenied)
+			}
+		})
+	}
+
+	// Failures above are throttled like the ones of Auth
+	_, err = s.DeleteAccount(ctx, &pb.DeleteAccountRequest{Id: id, Password: "ValidPass1!", SecondFactorCode: confirmation.RecoveryCodes[0]})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("DeleteAccount after repeated failures returned %v, where %v expected", err, codes.ResourceExhausted)
+	}
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.DeleteAuthFailure(ctx, accountFailureKey(uuid.MustParse(id.Uuid)))
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		t.Fatalf("failed to reset auth failures: %v", err)
+	}
+
+	deletion, err := s.DeleteAccount(ctx, &pb.DeleteAccountRequest{Id: id, Password: "ValidPass1!", SecondFactorCode: confirmation.RecoveryCodes[0]})
+	if err != nil {
+		t.Fatalf("DeleteAccount returned %v", err)
+	}
+	if deletion.PurgingTime.AsTime().Before(time.Now()) {
+		t.Errorf("DeleteAccount returned purging time %v in the past", deletion.PurgingTime.AsTime())
+	}
+
+	_, err = s.Auth(ctx, &pb.AuthRequest{Login: "alice", Password: "ValidPass1!"})
+	if status.Code(err) == codes.OK {
+		t.Errorf("Auth of deleted user succeeded")
+	}
+	_, err = s.GetUser(ctx, &pb.GetUserRequest{Id: id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetUser of deleted user returned %v, where %v expected", err, codes.NotFound)
+	}
+	_, err = s.GetProfile(ctx, &pb.GetProfileRequest{Id: id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetProfile of deleted user returned %v, where %v expected", err, codes.NotFound)
+	}
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	s, _ := newTestService(t)
+	s.deletionGracePeriod = time.Hour
+	ctx := context.Background()
+	id := &shared.Id{Uuid: register(t, s, "alice")}
+	bob := &shared.Id{Uuid: register(t, s, "bob")}
+	userId := uuid.MustParse(id.Uuid)
+
+	upload, err := s.UploadAvatar(ctx, &pb.UploadAvatarRequest{Id: id, Image: encodeTestImage(t, halves(50, 50), "png")})
+	if err != nil {
+		t.Fatalf("UploadAvatar returned %v", err)
+	}
+	parts := strings.Split(upload.Avatars[0].Url, "/")
+	avatarId := parts[len(parts)-2]
+
+	_, err = s.DeleteAccount(ctx, &pb.DeleteAccountRequest{Id: id, Password: "ValidPass1!"})
+	if err != nil {
+		t.Fatalf("DeleteAccount returned %v", err)
+	}
+
+	purged, err := s.purgeDeletedAccounts(ctx, time.Now())
+	if err != nil || purged != 0 {
+		t.Fatalf("purgeDeletedAccounts within grace period returned %v (%v), where nothing purged expected", purged, err)
+	}
+	if _, err := s.blobs.Get(ctx, avatarKey(userId, avatarId, avatarSizes[0])); err != nil {
+		t.Errorf("Avatar of deleted user is gone within grace period: %v", err)
+	}
+
+	purged, err = s.purgeDeletedAccounts(ctx, time.Now().Add(s.deletionGracePeriod+time.Minute))
+	if err != nil || purged != 1 {
+		t.Fatalf("purgeDeletedAccounts returned %v (%v), where single purged user expected", purged, err)
+	}
+
+	tx, err := s.storage.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.FindUserById(ctx, userId); err != storage.ErrNoSuchUser {
+		t.Errorf("FindUserById of purged user returned %v, where %v expected", err, storage.ErrNoSuchUser)
+	}
+	if _, err := tx.FindUserById(ctx, uuid.MustParse(bob.Uuid)); err != nil {
+		t.Errorf("FindUserById of another user returned %v after purge", err)
+	}
+	if _, err := s.blobs.Get(ctx, avatarKey(userId, avatarId, avatarSizes[0])); err != errNoSuchBlob {
+		t.Errorf("Avatar of purged user returned %v, where %v expected", err, errNoSuchBlob)
+	}
+
+	events, err := tx.ListEvents(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := 0
+	for _, event := range events {
+		if event.Kind != eventKindUserDeleted {
+			continue
+		}
+		deleted++
+		var payload userDeletedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.UserId != id.Uuid {
+			t.Errorf("Event %v has payload %s, where user id %v expected", event.Kind, event.Payload, id.Uuid)
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("purgeDeletedAccounts emitted %v %v events, where 1 expected", deleted, eventKindUserDeleted)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+// startSession records a new session of the user and issues its first pair of tokens.
+func (s UserService) startSession(ctx context.Context, tx storage.Transaction, user *storage.User, now time.Time) (string, string, error) {
+	sessionId, err := uuid.NewRandom()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate uuid: %w", err)
+	}
+
+	err = tx.InsertSession(ctx, storage.Session{
+		SessionId:    sessionId,
+		UserId:       user.UserId,
+		CreationTime: &now,
+		LastUseTime:  &now,
+		UserAgent:    clientUserAgent(ctx),
+		Ip:           clientIp(ctx),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to insert session: %w", err)
+	}
+
+	return s.issueTokens(ctx, tx, user, sessionId)
+}
+
+// revokeSession makes refresh tokens of the session unusable and revokes its access tokens.
+func revokeSession(ctx context.Context, tx storage.Transaction, sessionId uuid.UUID, now time.Time) error {
+	err := tx.RevokeRefreshTokenFamily(ctx, sessionId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+
+	err = tx.RevokeSession(ctx, sessionId, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session record: %w", err)
+	}
+
+	expirationTime := now.Add(accessTokenLifetime)
+	err = tx.InsertRevocation(ctx, storage.Revocation{
+		Kind:           storage.RevocationKindSession,
+		Subject:        sessionId.String(),
+		RevocationTime: &now,
+		ExpirationTime: &expirationTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert revocation: %w", err)
+	}
+
+	return nil
+}
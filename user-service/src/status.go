@@ -0,0 +1,30 @@
+package main
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// statusWithRetryDelay attaches RetryInfo, so the gateway is able to tell client when to come back.
+func statusWithRetryDelay(code codes.Code, message string, delay time.Duration) error {
+	st := status.New(code, message)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// statusWithFieldViolations attaches BadRequest, so the gateway is able to tell client which fields are wrong.
+func statusWithFieldViolations(code codes.Code, message string, violations []*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(code, message)
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
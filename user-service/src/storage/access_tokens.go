@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchPersonalAccessToken = errors.New("no personal access token were found")
+
+// PersonalAccessToken is a long-lived credential for scripts. Like refresh tokens,
+// only the hash of the token is stored.
+type PersonalAccessToken struct {
+	TokenId        uuid.UUID
+	UserId         uuid.UUID
+	Name           string
+	TokenHash      []byte
+	Scopes         []string
+	CreationTime   *time.Time
+	ExpirationTime *time.Time
+	LastUseTime    *time.Time
+	RevokedTime    *time.Time
+}
+
+const personalAccessTokenColumns = "tokenId, userId, name, tokenHash, scopes, creationTime, expirationTime, lastUseTime, revokedTime"
+
+func (tx *Tx) InsertPersonalAccessToken(ctx context.Context, token PersonalAccessToken) error {
+	query := "INSERT INTO PersonalAccessTokens (tokenId, userId, name, tokenHash, scopes, creationTime, expirationTime) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := tx.tx.Exec(ctx, query, token.TokenId, token.UserId, token.Name, token.TokenHash, token.Scopes, token.CreationTime, token.ExpirationTime)
+	return err
+}
+
+func getPersonalAccessTokenFromRow(row pgx.Row) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := row.Scan(&token.TokenId, &token.UserId, &token.Name, &token.TokenHash, &token.Scopes,
+		&token.CreationTime, &token.ExpirationTime, &token.LastUseTime, &token.RevokedTime)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchPersonalAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (tx *Tx) FindPersonalAccessTokenByHash(ctx context.Context, tokenHash []byte) (*PersonalAccessToken, error) {
+	query := "SELECT " + personalAccessTokenColumns + " FROM PersonalAccessTokens WHERE tokenHash = $1"
+	return getPersonalAccessTokenFromRow(tx.tx.QueryRow(ctx, query, tokenHash))
+}
+
+// ListUserPersonalAccessTokens returns not revoked tokens of the user expiring after activeAt, the newest first.
+func (tx *Tx) ListUserPersonalAccessTokens(ctx context.Context, userId uuid.UUID, activeAt time.Time) ([]PersonalAccessToken, error) {
+	query := "SELECT " + personalAccessTokenColumns + ` FROM PersonalAccessTokens
+WHERE userId = $1 AND revokedTime IS NULL AND expirationTime > $2 ORDER BY creationTime DESC`
+	rows, err := tx.tx.Query(ctx, query, userId, activeAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []PersonalAccessToken
+	for rows.Next() {
+		token, err := getPersonalAccessTokenFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (tx *Tx) TouchPersonalAccessToken(ctx context.Context, tokenId uuid.UUID, lastUseTime time.Time) error {
+	query := "UPDATE PersonalAccessTokens SET lastUseTime = $2 WHERE tokenId = $1"
+	_, err := tx.tx.Exec(ctx, query, tokenId, lastUseTime)
+	return err
+}
+
+// RevokePersonalAccessToken reports whether active token with such id belonged to the user.
+func (tx *Tx) RevokePersonalAccessToken(ctx context.Context, userId uuid.UUID, tokenId uuid.UUID, revokedTime time.Time) (bool, error) {
+	query := "UPDATE PersonalAccessTokens SET revokedTime = $3 WHERE tokenId = $1 AND userId = $2 AND revokedTime IS NULL"
+	tag, err := tx.tx.Exec(ctx, query, tokenId, userId, revokedTime)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
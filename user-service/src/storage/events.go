@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a record of the transactional outbox. It is inserted in the same transaction
+// as the change it describes and is removed once published.
+type Event struct {
+	EventId      uuid.UUID
+	Kind         string
+	Payload      []byte
+	CreationTime *time.Time
+}
+
+func (tx *Tx) InsertEvent(ctx context.Context, event Event) error {
+	query := "INSERT INTO Events (eventId, kind, payload, creationTime) VALUES ($1, $2, $3, $4)"
+	_, err := tx.tx.Exec(ctx, query, event.EventId, event.Kind, event.Payload, event.CreationTime)
+	return err
+}
+
+// ListEvents returns the oldest events. Returned events are locked and skipped by
+// concurrent callers, so every event is handled by a single publisher at a time.
+func (tx *Tx) ListEvents(ctx context.Context, limit int) ([]Event, error) {
+	query := "SELECT eventId, kind, payload, creationTime FROM Events ORDER BY creationTime LIMIT $1 FOR UPDATE SKIP LOCKED"
+	rows, err := tx.tx.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		err = rows.Scan(&event.EventId, &event.Kind, &event.Payload, &event.CreationTime)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (tx *Tx) DeleteEvent(ctx context.Context, eventId uuid.UUID) error {
+	query := "DELETE FROM Events WHERE eventId = $1"
+	_, err := tx.tx.Exec(ctx, query, eventId)
+	return err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchAuthFailure = errors.New("no auth failures were found")
+
+// AuthFailure counts consecutive failed authentications of a single source,
+// e.g. "user:<id>" or "ip:<addr>".
+type AuthFailure struct {
+	Key             string
+	Failures        int
+	LastFailureTime *time.Time
+	LockedUntil     *time.Time
+}
+
+// FindAuthFailure locks the found row until the end of the transaction,
+// so concurrent attempts from the same source are serialized.
+func (tx *Tx) FindAuthFailure(ctx context.Context, key string) (*AuthFailure, error) {
+	query := "SELECT key, failures, lastFailureTime, lockedUntil FROM AuthFailures WHERE key = $1 FOR UPDATE"
+	var failure AuthFailure
+	err := tx.tx.QueryRow(ctx, query, key).Scan(&failure.Key, &failure.Failures, &failure.LastFailureTime, &failure.LockedUntil)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchAuthFailure
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+func (tx *Tx) UpsertAuthFailure(ctx context.Context, failure AuthFailure) error {
+	query := `INSERT INTO AuthFailures (key, failures, lastFailureTime, lockedUntil) VALUES ($1, $2, $3, $4)
+ON CONFLICT (key) DO UPDATE SET failures = EXCLUDED.failures, lastFailureTime = EXCLUDED.lastFailureTime, lockedUntil = EXCLUDED.lockedUntil`
+	_, err := tx.tx.Exec(ctx, query, failure.Key, failure.Failures, failure.LastFailureTime, failure.LockedUntil)
+	return err
+}
+
+func (tx *Tx) DeleteAuthFailure(ctx context.Context, key string) error {
+	query := "DELETE FROM AuthFailures WHERE key = $1"
+	_, err := tx.tx.Exec(ctx, query, key)
+	return err
+}
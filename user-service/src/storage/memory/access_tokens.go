@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertPersonalAccessToken(ctx context.Context, token storage.PersonalAccessToken) error {
+	for _, other := range tx.state.personalAccessTokens {
+		if other.TokenId == token.TokenId || bytes.Equal(other.TokenHash, token.TokenHash) {
+			return errDuplicateKey
+		}
+	}
+	token.Scopes = slices.Clone(token.Scopes)
+	token.LastUseTime, token.RevokedTime = nil, nil
+	tx.state.personalAccessTokens[token.TokenId] = token
+	return nil
+}
+
+func (tx *Tx) FindPersonalAccessTokenByHash(ctx context.Context, tokenHash []byte) (*storage.PersonalAccessToken, error) {
+	for _, token := range tx.state.personalAccessTokens {
+		if bytes.Equal(token.TokenHash, tokenHash) {
+			return &token, nil
+		}
+	}
+	return nil, storage.ErrNoSuchPersonalAccessToken
+}
+
+func (tx *Tx) ListUserPersonalAccessTokens(ctx context.Context, userId uuid.UUID, activeAt time.Time) ([]storage.PersonalAccessToken, error) {
+	var tokens []storage.PersonalAccessToken
+	for _, token := range tx.state.personalAccessTokens {
+		if token.UserId == userId && token.RevokedTime == nil && token.ExpirationTime.After(activeAt) {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b storage.PersonalAccessToken) int { return b.CreationTime.Compare(*a.CreationTime) })
+	return tokens, nil
+}
+
+func (tx *Tx) TouchPersonalAccessToken(ctx context.Context, tokenId uuid.UUID, lastUseTime time.Time) error {
+	if token, ok := tx.state.personalAccessTokens[tokenId]; ok {
+		token.LastUseTime = &lastUseTime
+		tx.state.personalAccessTokens[tokenId] = token
+	}
+	return nil
+}
+
+func (tx *Tx) RevokePersonalAccessToken(ctx context.Context, userId uuid.UUID, tokenId uuid.UUID, revokedTime time.Time) (bool, error) {
+	token, ok := tx.state.personalAccessTokens[tokenId]
+	if !ok || token.UserId != userId || token.RevokedTime != nil {
+		return false, nil
+	}
+	token.RevokedTime = &revokedTime
+	tx.state.personalAccessTokens[tokenId] = token
+	return true, nil
+}
@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertEvent(ctx context.Context, event storage.Event) error {
+	if _, ok := tx.state.events[event.EventId]; ok {
+		return errDuplicateKey
+	}
+	tx.state.events[event.EventId] = event
+	return nil
+}
+
+func (tx *Tx) ListEvents(ctx context.Context, limit int) ([]storage.Event, error) {
+	var events []storage.Event
+	for _, event := range tx.state.events {
+		events = append(events, event)
+	}
+	slices.SortFunc(events, func(a, b storage.Event) int { return a.CreationTime.Compare(*b.CreationTime) })
+	return events[:min(limit, len(events))], nil
+}
+
+func (tx *Tx) DeleteEvent(ctx context.Context, eventId uuid.UUID) error {
+	delete(tx.state.events, eventId)
+	return nil
+}
@@ -0,0 +1,25 @@
+package memory
+
+import (
+	"context"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) FindAuthFailure(ctx context.Context, key string) (*storage.AuthFailure, error) {
+	failure, ok := tx.state.authFailures[key]
+	if !ok {
+		return nil, storage.ErrNoSuchAuthFailure
+	}
+	return &failure, nil
+}
+
+func (tx *Tx) UpsertAuthFailure(ctx context.Context, failure storage.AuthFailure) error {
+	tx.state.authFailures[failure.Key] = failure
+	return nil
+}
+
+func (tx *Tx) DeleteAuthFailure(ctx context.Context, key string) error {
+	delete(tx.state.authFailures, key)
+	return nil
+}
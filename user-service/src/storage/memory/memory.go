@@ -0,0 +1,152 @@
+// Package memory implements storage.Store in process memory. It is meant for tests
+// of code depending on storage, behaviour follows the PostgreSQL implementation.
+package memory
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+var (
+	errDuplicateKey = errors.New("duplicate key value")
+	errTxClosed     = errors.New("transaction is already closed")
+)
+
+type revocationKey struct {
+	kind    string
+	subject string
+}
+
+type userRole struct {
+	userId uuid.UUID
+	roleId int
+}
+
+type recoveryCode struct {
+	userId uuid.UUID
+	used   bool
+}
+
+// state holds all tables. Byte slice keys are stored as strings.
+type state struct {
+	users                map[uuid.UUID]storage.User
+	profiles             map[uuid.UUID]storage.Profile
+	profileChanges       map[uuid.UUID]storage.ProfileChange
+	refreshTokens        map[string]storage.RefreshToken
+	revocations          map[revocationKey]storage.Revocation
+	passwordResetTokens  map[string]storage.PasswordResetToken
+	emailVerifications   map[uuid.UUID]storage.EmailVerification
+	authFailures         map[string]storage.AuthFailure
+	totpSecrets          map[uuid.UUID]storage.TotpSecret
+	recoveryCodes        map[string]recoveryCode
+	roles                map[int]storage.Role
+	userRoles            map[userRole]bool
+	events               map[uuid.UUID]storage.Event
+	sessions             map[uuid.UUID]storage.Session
+	personalAccessTokens map[uuid.UUID]storage.PersonalAccessToken
+}
+
+func newState() *state {
+	return &state{
+		users:                make(map[uuid.UUID]storage.User),
+		profiles:             make(map[uuid.UUID]storage.Profile),
+		profileChanges:       make(map[uuid.UUID]storage.ProfileChange),
+		refreshTokens:        make(map[string]storage.RefreshToken),
+		revocations:          make(map[revocationKey]storage.Revocation),
+		passwordResetTokens:  make(map[string]storage.PasswordResetToken),
+		emailVerifications:   make(map[uuid.UUID]storage.EmailVerification),
+		authFailures:         make(map[string]storage.AuthFailure),
+		totpSecrets:          make(map[uuid.UUID]storage.TotpSecret),
+		recoveryCodes:        make(map[string]recoveryCode),
+		roles:                make(map[int]storage.Role),
+		userRoles:            make(map[userRole]bool),
+		events:               make(map[uuid.UUID]storage.Event),
+		sessions:             make(map[uuid.UUID]storage.Session),
+		personalAccessTokens: make(map[uuid.UUID]storage.PersonalAccessToken),
+	}
+}
+
+// clone copies tables. Rows are copied shallowly, since they are always replaced as a whole.
+func (s *state) clone() *state {
+	return &state{
+		users:                maps.Clone(s.users),
+		profiles:             maps.Clone(s.profiles),
+		profileChanges:       maps.Clone(s.profileChanges),
+		refreshTokens:        maps.Clone(s.refreshTokens),
+		revocations:          maps.Clone(s.revocations),
+		passwordResetTokens:  maps.Clone(s.passwordResetTokens),
+		emailVerifications:   maps.Clone(s.emailVerifications),
+		authFailures:         maps.Clone(s.authFailures),
+		totpSecrets:          maps.Clone(s.totpSecrets),
+		recoveryCodes:        maps.Clone(s.recoveryCodes),
+		roles:                maps.Clone(s.roles),
+		userRoles:            maps.Clone(s.userRoles),
+		events:               maps.Clone(s.events),
+		sessions:             maps.Clone(s.sessions),
+		personalAccessTokens: maps.Clone(s.personalAccessTokens),
+	}
+}
+
+// Store keeps data in memory. Transactions are serialized: Begin waits until the previous
+// transaction is committed or rolled back, so a goroutine must not hold two of them at once.
+type Store struct {
+	mu    sync.Mutex
+	state *state
+}
+
+func NewStore() *Store {
+	s := newState()
+	// Seeded by migrations in PostgreSQL
+	s.roles[1] = storage.Role{RoleId: 1, RoleName: storage.RoleAdmin, Description: "Manages users and their roles"}
+	s.roles[2] = storage.Role{RoleId: 2, RoleName: storage.RoleModerator, Description: "Moderates user generated content"}
+	return &Store{state: s}
+}
+
+func (s *Store) Begin(ctx context.Context) (storage.Transaction, error) {
+	s.mu.Lock()
+	return &Tx{store: s, state: s.state.clone()}, nil
+}
+
+// BeginSnapshot is the same as Begin, since transactions don't run concurrently.
+func (s *Store) BeginSnapshot(ctx context.Context) (storage.Transaction, error) {
+	return s.Begin(ctx)
+}
+
+func (s *Store) Close() {}
+
+// Tx works on a private copy of the state, which replaces the state of the store on commit.
+type Tx struct {
+	store  *Store
+	state  *state
+	closed bool
+}
+
+var (
+	_ storage.Store       = (*Store)(nil)
+	_ storage.Transaction = (*Tx)(nil)
+)
+
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.closed {
+		return errTxClosed
+	}
+	tx.closed = true
+	tx.store.state = tx.state
+	tx.store.mu.Unlock()
+	return nil
+}
+
+func (tx *Tx) Rollback(ctx context.Context) error {
+	if tx.closed {
+		return nil
+	}
+	tx.closed = true
+	tx.store.mu.Unlock()
+	return nil
+}
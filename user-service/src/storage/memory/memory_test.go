@@ -0,0 +1,12 @@
+package memory
+
+import (
+	"testing"
+
+	"soa-project/user-service/storage"
+	"soa-project/user-service/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return NewStore() })
+}
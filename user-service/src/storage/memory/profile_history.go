@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertProfileChange(ctx context.Context, change storage.ProfileChange) error {
+	if _, ok := tx.state.profileChanges[change.ChangeId]; ok {
+		return errDuplicateKey
+	}
+	tx.state.profileChanges[change.ChangeId] = change
+	return nil
+}
+
+// compareProfileChanges orders changes the latest first.
+func compareProfileChanges(a, b storage.ProfileChange) int {
+	if c := b.ChangeTime.Compare(*a.ChangeTime); c != 0 {
+		return c
+	}
+	return bytes.Compare(b.ChangeId[:], a.ChangeId[:])
+}
+
+func (tx *Tx) ListProfileChanges(ctx context.Context, userId uuid.UUID, after *storage.ProfileChangeCursor, limit int) ([]storage.ProfileChange, error) {
+	var changes []storage.ProfileChange
+	for _, change := range tx.state.profileChanges {
+		if change.UserId != userId {
+			continue
+		}
+		if after != nil && compareProfileChanges(change, storage.ProfileChange{ChangeTime: &after.ChangeTime, ChangeId: after.ChangeId}) <= 0 {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	slices.SortFunc(changes, compareProfileChanges)
+
+	return changes[:min(limit, len(changes))], nil
+}
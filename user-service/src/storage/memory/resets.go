@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertPasswordResetToken(ctx context.Context, token storage.PasswordResetToken) error {
+	if _, ok := tx.state.passwordResetTokens[string(token.TokenHash)]; ok {
+		return errDuplicateKey
+	}
+	tx.state.passwordResetTokens[string(token.TokenHash)] = token
+	return nil
+}
+
+func (tx *Tx) FindPasswordResetTokenByHash(ctx context.Context, tokenHash []byte) (*storage.PasswordResetToken, error) {
+	token, ok := tx.state.passwordResetTokens[string(tokenHash)]
+	if !ok {
+		return nil, storage.ErrNoSuchPasswordResetToken
+	}
+	return &token, nil
+}
+
+func (tx *Tx) InvalidateUserPasswordResetTokens(ctx context.Context, userId uuid.UUID) error {
+	for key, token := range tx.state.passwordResetTokens {
+		if token.UserId == userId {
+			token.Used = true
+			tx.state.passwordResetTokens[key] = token
+		}
+	}
+	return nil
+}
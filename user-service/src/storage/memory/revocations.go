@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"soa-project/user-service/storage"
+)
+
+func later(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+func (tx *Tx) InsertRevocation(ctx context.Context, revocation storage.Revocation) error {
+	key := revocationKey{kind: revocation.Kind, subject: revocation.Subject}
+	if prev, ok := tx.state.revocations[key]; ok {
+		revocation.RevocationTime = later(prev.RevocationTime, revocation.RevocationTime)
+		revocation.ExpirationTime = later(prev.ExpirationTime, revocation.ExpirationTime)
+	}
+	tx.state.revocations[key] = revocation
+	return nil
+}
+
+func (tx *Tx) ListRevocations(ctx context.Context, since time.Time, now time.Time) ([]storage.Revocation, error) {
+	var revocations []storage.Revocation
+	for _, revocation := range tx.state.revocations {
+		if revocation.RevocationTime.After(since) && revocation.ExpirationTime.After(now) {
+			revocations = append(revocations, revocation)
+		}
+	}
+	slices.SortFunc(revocations, func(a, b storage.Revocation) int { return a.RevocationTime.Compare(*b.RevocationTime) })
+	return revocations, nil
+}
+
+func (tx *Tx) DeleteExpiredRevocations(ctx context.Context, now time.Time) error {
+	deleteWhere(tx.state.revocations, func(revocation storage.Revocation) bool { return !revocation.ExpirationTime.After(now) })
+	return nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+var errNoSuchRoleId = errors.New("role id violates foreign key constraint")
+
+func sortRoles(roles []storage.Role) []storage.Role {
+	slices.SortFunc(roles, func(a, b storage.Role) int { return strings.Compare(a.RoleName, b.RoleName) })
+	return roles
+}
+
+func (tx *Tx) ListRoles(ctx context.Context) ([]storage.Role, error) {
+	var roles []storage.Role
+	for _, role := range tx.state.roles {
+		roles = append(roles, role)
+	}
+	return sortRoles(roles), nil
+}
+
+func (tx *Tx) ListUserRoles(ctx context.Context, userId uuid.UUID) ([]storage.Role, error) {
+	var roles []storage.Role
+	for key := range tx.state.userRoles {
+		if key.userId == userId {
+			roles = append(roles, tx.state.roles[key.roleId])
+		}
+	}
+	return sortRoles(roles), nil
+}
+
+func (tx *Tx) FindRoleByName(ctx context.Context, roleName string) (*storage.Role, error) {
+	for _, role := range tx.state.roles {
+		if role.RoleName == roleName {
+			return &role, nil
+		}
+	}
+	return nil, storage.ErrNoSuchRole
+}
+
+func (tx *Tx) AssignRole(ctx context.Context, userId uuid.UUID, roleId int) (bool, error) {
+	if _, ok := tx.state.roles[roleId]; !ok {
+		return false, errNoSuchRoleId
+	}
+	key := userRole{userId: userId, roleId: roleId}
+	if tx.state.userRoles[key] {
+		return false, nil
+	}
+	tx.state.userRoles[key] = true
+	return true, nil
+}
+
+func (tx *Tx) RevokeRole(ctx context.Context, userId uuid.UUID, roleId int) (bool, error) {
+	key := userRole{userId: userId, roleId: roleId}
+	if !tx.state.userRoles[key] {
+		return false, nil
+	}
+	delete(tx.state.userRoles, key)
+	return true, nil
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+
+	"soa-project/user-service/storage"
+)
+
+// trigrams splits s into trigrams the way pg_trgm does: words of letters and digits are
+// lowercased and padded with two spaces in front and one behind.
+func trigrams(s string) map[string]bool {
+	result := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, word := range words {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			result[string(runes[i:i+3])] = true
+		}
+	}
+	return result
+}
+
+// similarity is pg_trgm similarity: shared trigrams to all trigrams of both strings.
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for trigram := range ta {
+		if tb[trigram] {
+			shared++
+		}
+	}
+	// PostgreSQL computes it in real
+	return float64(float32(shared) / float32(len(ta)+len(tb)-shared))
+}
+
+func nameScore(name string, visibility storage.Visibility, query string) float64 {
+	if visibility != storage.VisibilityPublic {
+		return 0
+	}
+	if score := similarity(name, query); score >= storage.SimilarityThreshold {
+		return score
+	}
+	return 0
+}
+
+// compareFoundUsers orders users the greatest score first.
+func compareFoundUsers(a, b storage.FoundUser) int {
+	if c := cmp.Compare(b.Score, a.Score); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.User.UserId[:], b.User.UserId[:])
+}
+
+func (tx *Tx) SearchUsers(ctx context.Context, query string, after *storage.UserSearchCursor, limit int) ([]storage.FoundUser, error) {
+	var users []storage.FoundUser
+	for _, user := range tx.state.users {
+		if user.DeletedTime != nil {
+			continue
+		}
+
+		var score float64
+		switch {
+		case strings.EqualFold(user.Login, query):
+			score = storage.ScoreExactLogin
+		case strings.HasPrefix(strings.ToLower(user.Login), strings.ToLower(query)):
+			score = storage.ScoreLoginPrefix
+		}
+		if profile, ok := tx.state.profiles[user.UserId]; ok {
+			score = max(score, nameScore(profile.Name, profile.Visibility.Name, query), nameScore(profile.Surname, profile.Visibility.Surname, query))
+		}
+		if score == 0 {
+			continue
+		}
+
+		found := storage.FoundUser{User: user, Score: score}
+		if after != nil && compareFoundUsers(found, storage.FoundUser{User: storage.User{UserId: after.UserId}, Score: after.Score}) <= 0 {
+			continue
+		}
+		users = append(users, found)
+	}
+	slices.SortFunc(users, compareFoundUsers)
+
+	return users[:min(limit, len(users))], nil
+}
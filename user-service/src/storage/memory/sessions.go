@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertSession(ctx context.Context, session storage.Session) error {
+	if _, ok := tx.state.sessions[session.SessionId]; ok {
+		return errDuplicateKey
+	}
+	session.RevokedTime = nil
+	tx.state.sessions[session.SessionId] = session
+	return nil
+}
+
+func (tx *Tx) TouchSession(ctx context.Context, sessionId uuid.UUID, lastUseTime time.Time, ip string, userAgent string) error {
+	session, ok := tx.state.sessions[sessionId]
+	if !ok {
+		return nil
+	}
+	session.LastUseTime = &lastUseTime
+	if ip != "" {
+		session.Ip = ip
+	}
+	if userAgent != "" {
+		session.UserAgent = userAgent
+	}
+	tx.state.sessions[sessionId] = session
+	return nil
+}
+
+func (tx *Tx) FindSessionById(ctx context.Context, sessionId uuid.UUID) (*storage.Session, error) {
+	session, ok := tx.state.sessions[sessionId]
+	if !ok {
+		return nil, storage.ErrNoSuchSession
+	}
+	return &session, nil
+}
+
+func (tx *Tx) ListUserSessions(ctx context.Context, userId uuid.UUID, activeSince time.Time) ([]storage.Session, error) {
+	var sessions []storage.Session
+	for _, session := range tx.state.sessions {
+		if session.UserId == userId && session.RevokedTime == nil && session.LastUseTime.After(activeSince) {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b storage.Session) int { return b.LastUseTime.Compare(*a.LastUseTime) })
+	return sessions, nil
+}
+
+func (tx *Tx) revokeSessions(match func(session storage.Session) bool, revokedTime time.Time) {
+	for sessionId, session := range tx.state.sessions {
+		if session.RevokedTime == nil && match(session) {
+			session.RevokedTime = &revokedTime
+			tx.state.sessions[sessionId] = session
+		}
+	}
+}
+
+func (tx *Tx) RevokeSession(ctx context.Context, sessionId uuid.UUID, revokedTime time.Time) error {
+	tx.revokeSessions(func(session storage.Session) bool { return session.SessionId == sessionId }, revokedTime)
+	return nil
+}
+
+func (tx *Tx) RevokeUserSessions(ctx context.Context, userId uuid.UUID, revokedTime time.Time) error {
+	tx.revokeSessions(func(session storage.Session) bool { return session.UserId == userId }, revokedTime)
+	return nil
+}
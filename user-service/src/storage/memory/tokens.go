@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertRefreshToken(ctx context.Context, token storage.RefreshToken) error {
+	if _, ok := tx.state.refreshTokens[string(token.TokenHash)]; ok {
+		return errDuplicateKey
+	}
+	tx.state.refreshTokens[string(token.TokenHash)] = token
+	return nil
+}
+
+func (tx *Tx) FindRefreshTokenByHash(ctx context.Context, tokenHash []byte) (*storage.RefreshToken, error) {
+	token, ok := tx.state.refreshTokens[string(tokenHash)]
+	if !ok {
+		return nil, storage.ErrNoSuchRefreshToken
+	}
+	return &token, nil
+}
+
+func (tx *Tx) MarkRefreshTokenUsed(ctx context.Context, tokenHash []byte) error {
+	if token, ok := tx.state.refreshTokens[string(tokenHash)]; ok {
+		token.Used = true
+		tx.state.refreshTokens[string(tokenHash)] = token
+	}
+	return nil
+}
+
+func (tx *Tx) revokeRefreshTokens(match func(token storage.RefreshToken) bool) {
+	for key, token := range tx.state.refreshTokens {
+		if match(token) {
+			token.Revoked = true
+			tx.state.refreshTokens[key] = token
+		}
+	}
+}
+
+func (tx *Tx) RevokeRefreshTokenFamily(ctx context.Context, familyId uuid.UUID) error {
+	tx.revokeRefreshTokens(func(token storage.RefreshToken) bool { return token.FamilyId == familyId })
+	return nil
+}
+
+func (tx *Tx) RevokeUserRefreshTokens(ctx context.Context, userId uuid.UUID) error {
+	tx.revokeRefreshTokens(func(token storage.RefreshToken) bool { return token.UserId == userId })
+	return nil
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) UpsertTotpSecret(ctx context.Context, secret storage.TotpSecret) error {
+	tx.state.totpSecrets[secret.UserId] = secret
+	return nil
+}
+
+func (tx *Tx) FindTotpSecretByUserId(ctx context.Context, userId uuid.UUID) (*storage.TotpSecret, error) {
+	secret, ok := tx.state.totpSecrets[userId]
+	if !ok {
+		return nil, storage.ErrNoSuchTotpSecret
+	}
+	return &secret, nil
+}
+
+func (tx *Tx) ConfirmTotpSecret(ctx context.Context, userId uuid.UUID) error {
+	if secret, ok := tx.state.totpSecrets[userId]; ok {
+		secret.Confirmed = true
+		tx.state.totpSecrets[userId] = secret
+	}
+	return nil
+}
+
+func (tx *Tx) UpdateTotpLastUsedStep(ctx context.Context, userId uuid.UUID, step int64) error {
+	if secret, ok := tx.state.totpSecrets[userId]; ok {
+		secret.LastUsedStep = step
+		tx.state.totpSecrets[userId] = secret
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteTotpSecret(ctx context.Context, userId uuid.UUID) error {
+	delete(tx.state.totpSecrets, userId)
+	return nil
+}
+
+func (tx *Tx) InsertRecoveryCodes(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error {
+	for _, codeHash := range codeHashes {
+		if _, ok := tx.state.recoveryCodes[string(codeHash)]; ok {
+			return errDuplicateKey
+		}
+	}
+	for _, codeHash := range codeHashes {
+		tx.state.recoveryCodes[string(codeHash)] = recoveryCode{userId: userId}
+	}
+	return nil
+}
+
+func (tx *Tx) UseRecoveryCode(ctx context.Context, userId uuid.UUID, codeHash []byte) (bool, error) {
+	code, ok := tx.state.recoveryCodes[string(codeHash)]
+	if !ok || code.userId != userId || code.used {
+		return false, nil
+	}
+	code.used = true
+	tx.state.recoveryCodes[string(codeHash)] = code
+	return true, nil
+}
+
+func (tx *Tx) CountUnusedRecoveryCodes(ctx context.Context, userId uuid.UUID) (int, error) {
+	count := 0
+	for _, code := range tx.state.recoveryCodes {
+		if code.userId == userId && !code.used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (tx *Tx) DeleteUserRecoveryCodes(ctx context.Context, userId uuid.UUID) error {
+	deleteWhere(tx.state.recoveryCodes, func(code recoveryCode) bool { return code.userId == userId })
+	return nil
+}
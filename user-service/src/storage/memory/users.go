@@ -0,0 +1,254 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) InsertUser(ctx context.Context, user storage.User) error {
+	if _, ok := tx.state.users[user.UserId]; ok {
+		return errDuplicateKey
+	}
+	for _, other := range tx.state.users {
+		if other.DeletedTime != nil {
+			continue
+		}
+		if strings.EqualFold(other.Login, user.Login) {
+			return storage.ErrLoginTaken
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return storage.ErrEmailTaken
+		}
+	}
+	user.SuspendedTime, user.SuspendedUntil, user.SuspensionReason, user.DeletedTime = nil, nil, "", nil
+	tx.state.users[user.UserId] = user
+	return nil
+}
+
+func (tx *Tx) findUser(match func(user *storage.User) bool) (*storage.User, error) {
+	for _, user := range tx.state.users {
+		if user.DeletedTime == nil && match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, storage.ErrNoSuchUser
+}
+
+func (tx *Tx) FindUserByLogin(ctx context.Context, login string) (*storage.User, error) {
+	return tx.findUser(func(user *storage.User) bool { return strings.EqualFold(user.Login, login) })
+}
+
+func (tx *Tx) FindUserByEmail(ctx context.Context, email string) (*storage.User, error) {
+	return tx.findUser(func(user *storage.User) bool { return strings.EqualFold(user.Email, email) })
+}
+
+func (tx *Tx) FindUserById(ctx context.Context, userId uuid.UUID) (*storage.User, error) {
+	return tx.findUser(func(user *storage.User) bool { return user.UserId == userId })
+}
+
+func (tx *Tx) FindUsersByIds(ctx context.Context, userIds []uuid.UUID) ([]storage.User, error) {
+	var users []storage.User
+	for _, user := range tx.state.users {
+		if user.DeletedTime == nil && slices.Contains(userIds, user.UserId) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (tx *Tx) UpdateUserPassword(ctx context.Context, userId uuid.UUID, hashedPassword []byte, passwordScheme string) error {
+	if user, ok := tx.state.users[userId]; ok {
+		user.HashedPassword = hashedPassword
+		user.PasswordScheme = passwordScheme
+		tx.state.users[userId] = user
+	}
+	return nil
+}
+
+// updateUser applies update to not deleted user matching the filter. Returns ErrNoSuchUser otherwise.
+func (tx *Tx) updateUser(userId uuid.UUID, match func(user *storage.User) bool, update func(user *storage.User)) error {
+	user, ok := tx.state.users[userId]
+	if !ok || user.DeletedTime != nil || !match(&user) {
+		return storage.ErrNoSuchUser
+	}
+	update(&user)
+	tx.state.users[userId] = user
+	return nil
+}
+
+func anyUser(user *storage.User) bool { return true }
+
+func (tx *Tx) SetUserEmailVerified(ctx context.Context, userId uuid.UUID, email string) error {
+	return tx.updateUser(userId, func(user *storage.User) bool { return user.Email == email }, func(user *storage.User) {
+		user.EmailVerified = true
+	})
+}
+
+func (tx *Tx) SuspendUser(ctx context.Context, userId uuid.UUID, suspendedTime time.Time, suspendedUntil *time.Time, reason string) error {
+	return tx.updateUser(userId, anyUser, func(user *storage.User) {
+		user.SuspendedTime = &suspendedTime
+		user.SuspendedUntil = suspendedUntil
+		user.SuspensionReason = reason
+	})
+}
+
+func (tx *Tx) ReinstateUser(ctx context.Context, userId uuid.UUID) error {
+	return tx.updateUser(userId, anyUser, func(user *storage.User) {
+		user.SuspendedTime = nil
+		user.SuspendedUntil = nil
+		user.SuspensionReason = ""
+	})
+}
+
+func (tx *Tx) SoftDeleteUser(ctx context.Context, userId uuid.UUID, deletedTime time.Time) error {
+	return tx.updateUser(userId, anyUser, func(user *storage.User) {
+		user.DeletedTime = &deletedTime
+	})
+}
+
+func (tx *Tx) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]uuid.UUID, error) {
+	var deleted []storage.User
+	for _, user := range tx.state.users {
+		if user.DeletedTime != nil && user.DeletedTime.Before(deletedBefore) {
+			deleted = append(deleted, user)
+		}
+	}
+	slices.SortFunc(deleted, func(a, b storage.User) int { return a.DeletedTime.Compare(*b.DeletedTime) })
+
+	var userIds []uuid.UUID
+	for _, user := range deleted[:min(limit, len(deleted))] {
+		userIds = append(userIds, user.UserId)
+	}
+	return userIds, nil
+}
+
+func (tx *Tx) PurgeUser(ctx context.Context, userId uuid.UUID) error {
+	s := tx.state
+	delete(s.profiles, userId)
+	deleteWhere(s.profileChanges, func(change storage.ProfileChange) bool { return change.UserId == userId })
+	deleteWhere(s.refreshTokens, func(token storage.RefreshToken) bool { return token.UserId == userId })
+	deleteWhere(s.sessions, func(session storage.Session) bool { return session.UserId == userId })
+	deleteWhere(s.personalAccessTokens, func(token storage.PersonalAccessToken) bool { return token.UserId == userId })
+	deleteWhere(s.passwordResetTokens, func(token storage.PasswordResetToken) bool { return token.UserId == userId })
+	delete(s.emailVerifications, userId)
+	delete(s.totpSecrets, userId)
+	deleteWhere(s.recoveryCodes, func(code recoveryCode) bool { return code.userId == userId })
+	for key := range s.userRoles {
+		if key.userId == userId {
+			delete(s.userRoles, key)
+		}
+	}
+	delete(s.authFailures, "user:"+userId.String())
+	delete(s.users, userId)
+	return nil
+}
+
+func deleteWhere[K comparable, V any](m map[K]V, match func(V) bool) {
+	for key, value := range m {
+		if match(value) {
+			delete(m, key)
+		}
+	}
+}
+
+func compareUsers(a, b storage.User) int {
+	if c := strings.Compare(a.Login, b.Login); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.UserId[:], b.UserId[:])
+}
+
+func (tx *Tx) hasRole(userId uuid.UUID, roleName string) bool {
+	for key := range tx.state.userRoles {
+		if key.userId == userId && tx.state.roles[key.roleId].RoleName == roleName {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *Tx) ListUsers(ctx context.Context, filter storage.UserFilter, after *storage.UserCursor, limit int, now time.Time) ([]storage.User, error) {
+	query := strings.ToLower(filter.Query)
+
+	var users []storage.User
+	for _, user := range tx.state.users {
+		if user.DeletedTime != nil {
+			continue
+		}
+		if query != "" && !strings.HasPrefix(strings.ToLower(user.Login), query) && !strings.HasPrefix(strings.ToLower(user.Email), query) {
+			continue
+		}
+		if filter.Role != "" && !tx.hasRole(user.UserId, filter.Role) {
+			continue
+		}
+		if filter.SuspendedOnly && !user.IsSuspended(now) {
+			continue
+		}
+		if after != nil && compareUsers(user, storage.User{Login: after.Login, UserId: after.UserId}) <= 0 {
+			continue
+		}
+		users = append(users, user)
+	}
+	slices.SortFunc(users, compareUsers)
+
+	return users[:min(limit, len(users))], nil
+}
+
+func (tx *Tx) InsertProfile(ctx context.Context, profile storage.Profile) error {
+	if _, ok := tx.state.profiles[profile.UserId]; !ok {
+		profile.AvatarId, profile.AvatarContentType = "", ""
+		profile.Visibility = storage.DefaultProfileVisibility
+		tx.state.profiles[profile.UserId] = profile
+	}
+	return nil
+}
+
+func (tx *Tx) FindProfileByUserId(ctx context.Context, userId uuid.UUID) (*storage.Profile, error) {
+	profile, ok := tx.state.profiles[userId]
+	user, exists := tx.state.users[userId]
+	if !ok || !exists || user.DeletedTime != nil {
+		return nil, storage.ErrNoSuchUser
+	}
+	return &profile, nil
+}
+
+func (tx *Tx) FindProfilesByUserIds(ctx context.Context, userIds []uuid.UUID) ([]storage.Profile, error) {
+	var profiles []storage.Profile
+	for _, profile := range tx.state.profiles {
+		if user, ok := tx.state.users[profile.UserId]; ok && user.DeletedTime == nil && slices.Contains(userIds, profile.UserId) {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+// Transactions are serialized, so there is nothing to lock.
+func (tx *Tx) FindProfileByUserIdForUpdate(ctx context.Context, userId uuid.UUID) (*storage.Profile, error) {
+	return tx.FindProfileByUserId(ctx, userId)
+}
+
+func (tx *Tx) UpdateProfile(ctx context.Context, profile storage.Profile) error {
+	if prev, ok := tx.state.profiles[profile.UserId]; ok {
+		profile.AvatarId, profile.AvatarContentType = prev.AvatarId, prev.AvatarContentType
+		tx.state.profiles[profile.UserId] = profile
+	}
+	return nil
+}
+
+func (tx *Tx) SetProfileAvatar(ctx context.Context, userId uuid.UUID, avatarId string, contentType string, updateTime time.Time) error {
+	profile, ok := tx.state.profiles[userId]
+	if !ok {
+		return storage.ErrNoSuchUser
+	}
+	profile.AvatarId, profile.AvatarContentType = avatarId, contentType
+	profile.LastUpdateTime = &updateTime
+	tx.state.profiles[userId] = profile
+	return nil
+}
@@ -0,0 +1,22 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+func (tx *Tx) FindEmailVerificationByUserId(ctx context.Context, userId uuid.UUID) (*storage.EmailVerification, error) {
+	verification, ok := tx.state.emailVerifications[userId]
+	if !ok {
+		return nil, storage.ErrNoSuchEmailVerification
+	}
+	return &verification, nil
+}
+
+func (tx *Tx) UpsertEmailVerification(ctx context.Context, verification storage.EmailVerification) error {
+	tx.state.emailVerifications[verification.UserId] = verification
+	return nil
+}
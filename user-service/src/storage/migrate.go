@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key of the advisory lock serializing migrations of concurrent replicas
+const migrationLockKey = 0x75736572
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	// Nil if migration isn't applied
+	AppliedTime *time.Time
+}
+
+// loadMigrations reads pairs of NNNN_name.up.sql and NNNN_name.down.sql files.
+// Versions must go one after another starting from 1.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %v", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %v has different names: %v and %v", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %v_%v must have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %v is missing", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+func embeddedMigrations() ([]Migration, error) {
+	fsys, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(fsys)
+}
+
+func schemaMigrationsTableSchema() string {
+	return `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	appliedTime TIMESTAMP WITH TIME ZONE NOT NULL
+);`
+}
+
+// rowQuerier is implemented by both pool and transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// schemaVersion returns the latest applied migration, 0 if there is none.
+func schemaVersion(ctx context.Context, q rowQuerier) (int, error) {
+	var exists bool
+	err := q.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = q.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// migrateStep applies the next migration up or rolls back the latest one in its own transaction.
+// Advisory lock held by the transaction makes concurrent callers wait and then observe
+// the result, so every migration is applied once. Returns nil if there is nothing to do.
+func (s *Storage) migrateStep(ctx context.Context, migrations []Migration, up bool) (*Migration, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, schemaMigrationsTableSchema())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create table schema_migrations in the database: %w", err)
+	}
+
+	version, err := schemaVersion(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("database schema version %v is newer than %v known to this build", version, len(migrations))
+	}
+
+	var migration Migration
+	if up {
+		if version == len(migrations) {
+			return nil, nil
+		}
+		migration = migrations[version]
+		_, err = tx.Exec(ctx, migration.Up)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply migration %v_%v: %w", migration.Version, migration.Name, err)
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name, appliedTime) VALUES ($1, $2, $3)", migration.Version, migration.Name, time.Now())
+	} else {
+		if version == 0 {
+			return nil, nil
+		}
+		migration = migrations[version-1]
+		_, err = tx.Exec(ctx, migration.Down)
+		if err != nil {
+			return nil, fmt.Errorf("failed to roll back migration %v_%v: %w", migration.Version, migration.Name, err)
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	return &migration, tx.Commit(ctx)
+}
+
+// MigrateUp applies all pending migrations and returns them.
+func (s *Storage) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for {
+		migration, err := s.migrateStep(ctx, migrations, true)
+		if err != nil {
+			return applied, err
+		}
+		if migration == nil {
+			return applied, nil
+		}
+		applied = append(applied, *migration)
+	}
+}
+
+// MigrateDown rolls back up to steps latest migrations and returns them.
+func (s *Storage) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for range steps {
+		migration, err := s.migrateStep(ctx, migrations, false)
+		if err != nil {
+			return rolledBack, err
+		}
+		if migration == nil {
+			break
+		}
+		rolledBack = append(rolledBack, *migration)
+	}
+	return rolledBack, nil
+}
+
+// MigrationStatus lists migrations known to this build along with the time they were applied.
+// Applied migrations unknown to this build are listed too, with empty Up and Down.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		result = append(result, MigrationStatus{Migration: migration})
+	}
+
+	version, err := schemaVersion(ctx, s.pool)
+	if err != nil || version == 0 {
+		return result, err
+	}
+
+	rows, err := s.pool.Query(ctx, "SELECT version, name, appliedTime FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status MigrationStatus
+		err = rows.Scan(&status.Version, &status.Name, &status.AppliedTime)
+		if err != nil {
+			return nil, err
+		}
+		if status.Version <= len(result) {
+			result[status.Version-1].AppliedTime = status.AppliedTime
+		} else {
+			result = append(result, status)
+		}
+	}
+
+	return result, rows.Err()
+}
+
+// CheckSchemaVersion fails unless database schema is exactly the one this build expects.
+func (s *Storage) CheckSchemaVersion(ctx context.Context) error {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(ctx, s.pool)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if version < len(migrations) {
+		return fmt.Errorf("database schema version %v is older than %v expected by this build, run `user-service migrate up`", version, len(migrations))
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %v is newer than %v known to this build, deploy newer build or roll the schema back with it", version, len(migrations))
+	}
+	return nil
+}
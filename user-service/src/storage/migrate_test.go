@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		expected int
+		wantErr  bool
+	}{
+		{
+			name: "Ordered pairs",
+			files: fstest.MapFS{
+				"0002_second.up.sql":   file("up 2"),
+				"0002_second.down.sql": file("down 2"),
+				"0001_first.up.sql":    file("up 1"),
+				"0001_first.down.sql":  file("down 1"),
+			},
+			expected: 2,
+		},
+		{
+			name: "Missing down",
+			files: fstest.MapFS{
+				"0001_first.up.sql": file("up 1"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Gap in versions",
+			files: fstest.MapFS{
+				"0001_first.up.sql":   file("up 1"),
+				"0001_first.down.sql": file("down 1"),
+				"0003_third.up.sql":   file("up 3"),
+				"0003_third.down.sql": file("down 3"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unexpected file",
+			files: fstest.MapFS{
+				"0001_first.sql": file("up 1"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMigrations returned error %v, where error expected: %v", err, tt.wantErr)
+			}
+			if len(migrations) != tt.expected {
+				t.Fatalf("loadMigrations returned %v migrations, where %v expected", len(migrations), tt.expected)
+			}
+			for i, migration := range migrations {
+				if migration.Version != i+1 {
+					t.Errorf("migration %v has version %v", i, migration.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS Profiles;
+DROP TABLE IF EXISTS Users;
@@ -0,0 +1,18 @@
+-- Tables existing before migrations were introduced. IF NOT EXISTS lets databases
+-- created by earlier builds adopt this and the following migrations.
+CREATE TABLE IF NOT EXISTS Users (
+	userId UUID PRIMARY KEY,
+	login VARCHAR(100) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	hashedPassword BYTEA NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS Profiles (
+	userId UUID PRIMARY KEY,
+	name VARCHAR(100),
+	surname VARCHAR(100),
+	phoneNumber VARCHAR(20),
+	birthDay DATE,
+	creationTime TIMESTAMP WITHOUT TIME ZONE,
+	lastUpdateTime TIMESTAMP WITHOUT TIME ZONE
+);
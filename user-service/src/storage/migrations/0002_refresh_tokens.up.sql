@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS RefreshTokens (
+	tokenHash BYTEA PRIMARY KEY,
+	familyId UUID NOT NULL,
+	userId UUID NOT NULL,
+	creationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	expirationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT FALSE,
+	revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS RefreshTokensFamilyIdx ON RefreshTokens (familyId);
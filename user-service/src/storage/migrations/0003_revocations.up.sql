@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS Revocations (
+	kind VARCHAR(16) NOT NULL,
+	subject VARCHAR(64) NOT NULL,
+	revocationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	expirationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	PRIMARY KEY (kind, subject)
+);
+CREATE INDEX IF NOT EXISTS RevocationsRevocationTimeIdx ON Revocations (revocationTime);
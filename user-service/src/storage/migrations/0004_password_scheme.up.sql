@@ -0,0 +1,2 @@
+-- Accounts created before configurable hashing use bcrypt over md5 digest
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS passwordScheme VARCHAR(16) NOT NULL DEFAULT 'md5-bcrypt';
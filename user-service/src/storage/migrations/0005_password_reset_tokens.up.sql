@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS PasswordResetTokens (
+	tokenHash BYTEA PRIMARY KEY,
+	userId UUID NOT NULL,
+	creationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	expirationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS PasswordResetTokensUserIdx ON PasswordResetTokens (userId);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS EmailVerifications;
+ALTER TABLE Users DROP COLUMN IF EXISTS emailVerified;
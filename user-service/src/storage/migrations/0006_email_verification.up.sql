@@ -0,0 +1,8 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS emailVerified BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS EmailVerifications (
+	userId UUID PRIMARY KEY,
+	lastSentTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	windowStartTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	sentInWindow INTEGER NOT NULL
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS AuthFailures (
+	key VARCHAR(128) PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	lastFailureTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	lockedUntil TIMESTAMP WITH TIME ZONE
+);
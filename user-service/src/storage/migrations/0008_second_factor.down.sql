@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS RecoveryCodes;
+DROP TABLE IF EXISTS TotpSecrets;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS TotpSecrets (
+	userId UUID PRIMARY KEY,
+	secret BYTEA NOT NULL,
+	confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+	lastUsedStep BIGINT NOT NULL DEFAULT 0,
+	creationTime TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+	codeHash BYTEA PRIMARY KEY,
+	userId UUID NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS RecoveryCodesUserIdx ON RecoveryCodes (userId);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS Roles (
+	roleId SERIAL PRIMARY KEY,
+	roleName VARCHAR(100) NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT ''
+);
+INSERT INTO Roles (roleName, description) VALUES
+	('admin', 'Manages users and their roles'),
+	('moderator', 'Moderates user generated content')
+ON CONFLICT (roleName) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS UserRoles (
+	userId UUID NOT NULL,
+	roleId INTEGER NOT NULL REFERENCES Roles (roleId),
+	PRIMARY KEY (userId, roleId)
+);
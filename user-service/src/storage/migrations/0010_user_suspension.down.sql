@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS UsersLoginIdx;
+ALTER TABLE Users DROP COLUMN IF EXISTS suspensionReason;
+ALTER TABLE Users DROP COLUMN IF EXISTS suspendedUntil;
+ALTER TABLE Users DROP COLUMN IF EXISTS suspendedTime;
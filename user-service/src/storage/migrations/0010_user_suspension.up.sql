@@ -0,0 +1,5 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS suspendedTime TIMESTAMP WITH TIME ZONE;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS suspendedUntil TIMESTAMP WITH TIME ZONE;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS suspensionReason TEXT NOT NULL DEFAULT '';
+-- Admin listing is paginated by (login, userId)
+CREATE INDEX IF NOT EXISTS UsersLoginIdx ON Users (login, userId);
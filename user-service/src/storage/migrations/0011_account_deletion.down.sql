@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS Events;
+DROP INDEX IF EXISTS UsersDeletedTimeIdx;
+ALTER TABLE Users DROP COLUMN IF EXISTS deletedTime;
@@ -0,0 +1,10 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS deletedTime TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS UsersDeletedTimeIdx ON Users (deletedTime) WHERE deletedTime IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS Events (
+	eventId UUID PRIMARY KEY,
+	kind VARCHAR(64) NOT NULL,
+	payload JSONB NOT NULL,
+	creationTime TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS EventsCreationTimeIdx ON Events (creationTime);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS Sessions (
+	sessionId UUID PRIMARY KEY,
+	userId UUID NOT NULL,
+	creationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	lastUseTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	userAgent TEXT NOT NULL DEFAULT '',
+	ip VARCHAR(64) NOT NULL DEFAULT '',
+	revokedTime TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS SessionsUserIdx ON Sessions (userId);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS PersonalAccessTokens (
+	tokenId UUID PRIMARY KEY,
+	userId UUID NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	tokenHash BYTEA NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL,
+	creationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	expirationTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	lastUseTime TIMESTAMP WITH TIME ZONE,
+	revokedTime TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS PersonalAccessTokensUserIdx ON PersonalAccessTokens (userId);
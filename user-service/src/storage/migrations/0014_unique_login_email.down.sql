@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS UsersEmailUniqueIdx;
+DROP INDEX IF EXISTS UsersLoginUniqueIdx;
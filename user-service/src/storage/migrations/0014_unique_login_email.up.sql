@@ -0,0 +1,22 @@
+-- Lookups used to be case sensitive, so logins and emails differing only in case may be taken
+-- by different users. Index creation would fail on them with no hint, so they are listed first.
+-- See "Дубликаты логинов и почт" in README for the cleanup.
+DO $$
+DECLARE
+	conflicts TEXT;
+BEGIN
+	SELECT string_agg(format('%s %L: %s', kind, value, userIds), E'\n' ORDER BY kind, value) INTO conflicts FROM (
+		SELECT 'login' AS kind, lower(login) AS value, string_agg(userId::TEXT, ', ' ORDER BY userId) AS userIds
+		FROM Users WHERE deletedTime IS NULL GROUP BY lower(login) HAVING count(*) > 1
+		UNION ALL
+		SELECT 'email' AS kind, lower(email) AS value, string_agg(userId::TEXT, ', ' ORDER BY userId) AS userIds
+		FROM Users WHERE deletedTime IS NULL GROUP BY lower(email) HAVING count(*) > 1
+	) duplicates;
+	IF conflicts IS NOT NULL THEN
+		RAISE EXCEPTION 'users share login or email differing only in case, resolve them before migrating:%', E'\n' || conflicts;
+	END IF;
+END $$;
+
+-- Deleted users don't hold their login and email during grace period.
+CREATE UNIQUE INDEX IF NOT EXISTS UsersLoginUniqueIdx ON Users (lower(login)) WHERE deletedTime IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS UsersEmailUniqueIdx ON Users (lower(email)) WHERE deletedTime IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE Profiles DROP COLUMN IF EXISTS avatarContentType;
+ALTER TABLE Profiles DROP COLUMN IF EXISTS avatarId;
@@ -0,0 +1,3 @@
+-- Images themselves live in the blob store under avatars/<userId>/<avatarId>/
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS avatarId VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS avatarContentType VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS ProfileChanges (
+	changeId UUID PRIMARY KEY,
+	userId UUID NOT NULL,
+	actorId UUID NOT NULL,
+	changeTime TIMESTAMP WITH TIME ZONE NOT NULL,
+	ip VARCHAR(64) NOT NULL DEFAULT '',
+	userAgent TEXT NOT NULL DEFAULT '',
+	fields JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ProfileChangesUserIdx ON ProfileChanges (userId, changeTime DESC, changeId DESC);
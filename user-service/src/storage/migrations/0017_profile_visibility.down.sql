@@ -0,0 +1,5 @@
+ALTER TABLE Profiles DROP COLUMN IF EXISTS avatarVisibility;
+ALTER TABLE Profiles DROP COLUMN IF EXISTS birthDayVisibility;
+ALTER TABLE Profiles DROP COLUMN IF EXISTS phoneNumberVisibility;
+ALTER TABLE Profiles DROP COLUMN IF EXISTS surnameVisibility;
+ALTER TABLE Profiles DROP COLUMN IF EXISTS nameVisibility;
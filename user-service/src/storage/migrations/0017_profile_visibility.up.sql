@@ -0,0 +1,5 @@
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS nameVisibility VARCHAR(16) NOT NULL DEFAULT 'public';
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS surnameVisibility VARCHAR(16) NOT NULL DEFAULT 'public';
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS phoneNumberVisibility VARCHAR(16) NOT NULL DEFAULT 'only_me';
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS birthDayVisibility VARCHAR(16) NOT NULL DEFAULT 'only_me';
+ALTER TABLE Profiles ADD COLUMN IF NOT EXISTS avatarVisibility VARCHAR(16) NOT NULL DEFAULT 'public';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS ProfilesSurnameTrgmIdx;
+DROP INDEX IF EXISTS ProfilesNameTrgmIdx;
+DROP INDEX IF EXISTS UsersLoginPatternIdx;
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,4 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS UsersLoginPatternIdx ON Users (lower(login) text_pattern_ops) WHERE deletedTime IS NULL;
+CREATE INDEX IF NOT EXISTS ProfilesNameTrgmIdx ON Profiles USING GIN (lower(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS ProfilesSurnameTrgmIdx ON Profiles USING GIN (lower(surname) gin_trgm_ops);
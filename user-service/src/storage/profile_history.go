@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProfileChange is a record of the append-only profile history. Records are never
+// changed, they are only removed together with the user.
+type ProfileChange struct {
+	ChangeId uuid.UUID
+	UserId   uuid.UUID
+	// User who made the change, not necessarily the owner
+	ActorId    uuid.UUID
+	ChangeTime *time.Time
+	Ip         string
+	UserAgent  string
+	// Only the fields that were actually changed
+	Fields []ProfileFieldChange
+}
+
+// ProfileFieldChange holds values of a single field in text form, empty value stands for absent one.
+type ProfileFieldChange struct {
+	Field    string `json:"field"`
+	OldValue string `json:"old"`
+	NewValue string `json:"new"`
+}
+
+// ProfileChangeCursor points to the last change of the previous page.
+// Changes are ordered by time and id, the latest first.
+type ProfileChangeCursor struct {
+	ChangeTime time.Time
+	ChangeId   uuid.UUID
+}
+
+func (tx *Tx) InsertProfileChange(ctx context.Context, change ProfileChange) error {
+	query := "INSERT INTO ProfileChanges (changeId, userId, actorId, changeTime, ip, userAgent, fields) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := tx.tx.Exec(ctx, query, change.ChangeId, change.UserId, change.ActorId, change.ChangeTime, change.Ip, change.UserAgent, change.Fields)
+	return err
+}
+
+func (tx *Tx) ListProfileChanges(ctx context.Context, userId uuid.UUID, after *ProfileChangeCursor, limit int) ([]ProfileChange, error) {
+	query := "SELECT changeId, userId, actorId, changeTime, ip, userAgent, fields FROM ProfileChanges WHERE userId = $1"
+	args := []any{userId}
+	if after != nil {
+		query += " AND (changeTime, changeId) < ($2, $3)"
+		args = append(args, after.ChangeTime, after.ChangeId)
+	}
+	query += fmt.Sprintf(" ORDER BY changeTime DESC, changeId DESC LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := tx.tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []ProfileChange
+	for rows.Next() {
+		var change ProfileChange
+		err = rows.Scan(&change.ChangeId, &change.UserId, &change.ActorId, &change.ChangeTime, &change.Ip, &change.UserAgent, &change.Fields)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
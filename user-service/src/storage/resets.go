@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchPasswordResetToken = errors.New("no password reset token were found")
+
+type PasswordResetToken struct {
+	TokenHash      []byte
+	UserId         uuid.UUID
+	CreationTime   *time.Time
+	ExpirationTime *time.Time
+	Used           bool
+}
+
+func (tx *Tx) InsertPasswordResetToken(ctx context.Context, token PasswordResetToken) error {
+	query := "INSERT INTO PasswordResetTokens (tokenHash, userId, creationTime, expirationTime, used) VALUES ($1, $2, $3, $4, $5)"
+	_, err := tx.tx.Exec(ctx, query, token.TokenHash, token.UserId, token.CreationTime, token.ExpirationTime, token.Used)
+	return err
+}
+
+func (tx *Tx) FindPasswordResetTokenByHash(ctx context.Context, tokenHash []byte) (*PasswordResetToken, error) {
+	query := "SELECT tokenHash, userId, creationTime, expirationTime, used FROM PasswordResetTokens WHERE tokenHash = $1 FOR UPDATE"
+	var token PasswordResetToken
+	err := tx.tx.QueryRow(ctx, query, tokenHash).Scan(&token.TokenHash, &token.UserId, &token.CreationTime, &token.ExpirationTime, &token.Used)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchPasswordResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// InvalidateUserPasswordResetTokens marks all tokens of the user as used, so only the latest
+// requested token or none of them can be used.
+func (tx *Tx) InvalidateUserPasswordResetTokens(ctx context.Context, userId uuid.UUID) error {
+	query := "UPDATE PasswordResetTokens SET used = TRUE WHERE userId = $1 AND NOT used"
+	_, err := tx.tx.Exec(ctx, query, userId)
+	return err
+}
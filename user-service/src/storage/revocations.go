@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// Subject is jti of the revoked token
+	RevocationKindToken = "token"
+	// Subject is user id, all tokens of the user issued before revocation time are revoked
+	RevocationKindUser = "user"
+	// Subject is session id, all tokens carrying it in sid claim are revoked
+	RevocationKindSession = "session"
+)
+
+type Revocation struct {
+	Kind           string
+	Subject        string
+	RevocationTime *time.Time
+	ExpirationTime *time.Time
+}
+
+// InsertRevocation stores revocation or, if subject is already revoked, moves its times forward.
+func (tx *Tx) InsertRevocation(ctx context.Context, revocation Revocation) error {
+	query := `INSERT INTO Revocations (kind, subject, revocationTime, expirationTime) VALUES ($1, $2, $3, $4)
+ON CONFLICT (kind, subject) DO UPDATE SET
+	revocationTime = GREATEST(Revocations.revocationTime, EXCLUDED.revocationTime),
+	expirationTime = GREATEST(Revocations.expirationTime, EXCLUDED.expirationTime)`
+	_, err := tx.tx.Exec(ctx, query, revocation.Kind, revocation.Subject, revocation.RevocationTime, revocation.ExpirationTime)
+	return err
+}
+
+// ListRevocations returns not yet expired revocations made after since.
+func (tx *Tx) ListRevocations(ctx context.Context, since time.Time, now time.Time) ([]Revocation, error) {
+	query := "SELECT kind, subject, revocationTime, expirationTime FROM Revocations WHERE revocationTime > $1 AND expirationTime > $2 ORDER BY revocationTime"
+	rows, err := tx.tx.Query(ctx, query, since, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revocations []Revocation
+	for rows.Next() {
+		var revocation Revocation
+		err = rows.Scan(&revocation.Kind, &revocation.Subject, &revocation.RevocationTime, &revocation.ExpirationTime)
+		if err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, revocation)
+	}
+
+	return revocations, rows.Err()
+}
+
+func (tx *Tx) DeleteExpiredRevocations(ctx context.Context, now time.Time) error {
+	query := "DELETE FROM Revocations WHERE expirationTime <= $1"
+	_, err := tx.tx.Exec(ctx, query, now)
+	return err
+}
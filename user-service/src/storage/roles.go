@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchRole = errors.New("no role were found")
+
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+type Role struct {
+	RoleId      int
+	RoleName    string
+	Description string
+}
+
+func (tx *Tx) ListRoles(ctx context.Context) ([]Role, error) {
+	query := "SELECT roleId, roleName, description FROM Roles ORDER BY roleName"
+	return collectRoles(tx.tx.Query(ctx, query))
+}
+
+func (tx *Tx) ListUserRoles(ctx context.Context, userId uuid.UUID) ([]Role, error) {
+	query := `SELECT Roles.roleId, Roles.roleName, Roles.description FROM Roles
+JOIN UserRoles ON UserRoles.roleId = Roles.roleId
+WHERE UserRoles.userId = $1 ORDER BY Roles.roleName`
+	return collectRoles(tx.tx.Query(ctx, query, userId))
+}
+
+func collectRoles(rows pgx.Rows, err error) ([]Role, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		err = rows.Scan(&role.RoleId, &role.RoleName, &role.Description)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (tx *Tx) FindRoleByName(ctx context.Context, roleName string) (*Role, error) {
+	query := "SELECT roleId, roleName, description FROM Roles WHERE roleName = $1"
+	var role Role
+	err := tx.tx.QueryRow(ctx, query, roleName).Scan(&role.RoleId, &role.RoleName, &role.Description)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchRole
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// AssignRole reports whether the role was actually added, i.e. user didn't have it before.
+func (tx *Tx) AssignRole(ctx context.Context, userId uuid.UUID, roleId int) (bool, error) {
+	query := "INSERT INTO UserRoles (userId, roleId) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+	tag, err := tx.tx.Exec(ctx, query, userId, roleId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeRole reports whether the role was actually removed, i.e. user had it before.
+func (tx *Tx) RevokeRole(ctx context.Context, userId uuid.UUID, roleId int) (bool, error) {
+	query := "DELETE FROM UserRoles WHERE userId = $1 AND roleId = $2"
+	tag, err := tx.tx.Exec(ctx, query, userId, roleId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Scores of login matches, trigram similarity of names is at most 1, so login matches go first.
+const (
+	ScoreExactLogin  = 2
+	ScoreLoginPrefix = 1
+	// Names less similar than this aren't matched, as pg_trgm.similarity_threshold by default
+	SimilarityThreshold = 0.3
+)
+
+// FoundUser is a user matched by SearchUsers along with relevance of the match, the greater the better.
+type FoundUser struct {
+	User  User
+	Score float64
+}
+
+// UserSearchCursor points to the last user of the previous page.
+// Users are ordered by score, the greatest first, and by id.
+type UserSearchCursor struct {
+	Score  float64
+	UserId uuid.UUID
+}
+
+// SearchUsers finds not deleted users by case insensitive prefix of login and by trigram similarity
+// of name or surname. Only names visible to everybody are matched, so search doesn't disclose hidden ones.
+func (tx *Tx) SearchUsers(ctx context.Context, query string, after *UserSearchCursor, limit int) ([]FoundUser, error) {
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	q := arg(strings.ToLower(query))
+	prefix := arg(escapeLike(strings.ToLower(query)) + "%")
+	public := arg(string(VisibilityPublic))
+	similarity := func(column, visibility string) string {
+		return "CASE WHEN Profiles." + visibility + " = " + public + " AND lower(Profiles." + column + ") % " + q +
+			" THEN similarity(lower(Profiles." + column + "), " + q + ") ELSE 0 END"
+	}
+	// Candidates are matched by each index separately, OR across joined tables would scan both of them.
+	// Scores are computed for the candidates only.
+	sql := `WITH Candidates AS (
+	SELECT userId FROM Users WHERE deletedTime IS NULL AND lower(login) LIKE ` + prefix + `
+	UNION
+	SELECT userId FROM Profiles WHERE nameVisibility = ` + public + ` AND lower(name) % ` + q + `
+	UNION
+	SELECT userId FROM Profiles WHERE surnameVisibility = ` + public + ` AND lower(surname) % ` + q + `
+)
+SELECT ` + userColumns + `, score FROM (
+	SELECT Users.*, GREATEST(
+		CASE WHEN lower(Users.login) = ` + q + ` THEN ` + fmt.Sprint(ScoreExactLogin) + `
+			WHEN lower(Users.login) LIKE ` + prefix + ` THEN ` + fmt.Sprint(ScoreLoginPrefix) + ` ELSE 0 END,
+		` + similarity("name", "nameVisibility") + `,
+		` + similarity("surname", "surnameVisibility") + `
+	)::float8 AS score
+	FROM Candidates
+	JOIN Users ON Users.userId = Candidates.userId
+	LEFT JOIN Profiles ON Profiles.userId = Users.userId
+	WHERE Users.deletedTime IS NULL
+) Ranked WHERE score > 0`
+	if after != nil {
+		score, userId := arg(after.Score), arg(after.UserId)
+		sql += " AND (score < " + score + " OR (score = " + score + " AND userId > " + userId + "))"
+	}
+	sql += " ORDER BY score DESC, userId LIMIT " + arg(limit)
+
+	rows, err := tx.tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []FoundUser
+	for rows.Next() {
+		var found FoundUser
+		user := &found.User
+		err := rows.Scan(&user.UserId, &user.Login, &user.Email, &user.HashedPassword, &user.PasswordScheme, &user.EmailVerified, &user.SuspendedTime,
+			&user.SuspendedUntil, &user.SuspensionReason, &user.DeletedTime, &found.Score)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, found)
+	}
+
+	return users, rows.Err()
+}
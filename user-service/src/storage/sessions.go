@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchSession = errors.New("no session were found")
+
+// Session is a single successful authentication. Its id is shared with the family
+// of refresh tokens it produces and with the sid claim of access tokens.
+type Session struct {
+	SessionId    uuid.UUID
+	UserId       uuid.UUID
+	CreationTime *time.Time
+	LastUseTime  *time.Time
+	UserAgent    string
+	Ip           string
+	RevokedTime  *time.Time
+}
+
+func (tx *Tx) InsertSession(ctx context.Context, session Session) error {
+	query := "INSERT INTO Sessions (sessionId, userId, creationTime, lastUseTime, userAgent, ip) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := tx.tx.Exec(ctx, query, session.SessionId, session.UserId, session.CreationTime, session.LastUseTime, session.UserAgent, session.Ip)
+	return err
+}
+
+// TouchSession records use of the session. Empty ip or user agent keep previous values.
+func (tx *Tx) TouchSession(ctx context.Context, sessionId uuid.UUID, lastUseTime time.Time, ip string, userAgent string) error {
+	query := `UPDATE Sessions SET lastUseTime = $2,
+	ip = COALESCE(NULLIF($3, ''), ip),
+	userAgent = COALESCE(NULLIF($4, ''), userAgent)
+WHERE sessionId = $1`
+	_, err := tx.tx.Exec(ctx, query, sessionId, lastUseTime, ip, userAgent)
+	return err
+}
+
+func getSessionFromRow(row pgx.Row) (*Session, error) {
+	var session Session
+	err := row.Scan(&session.SessionId, &session.UserId, &session.CreationTime, &session.LastUseTime, &session.UserAgent, &session.Ip, &session.RevokedTime)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (tx *Tx) FindSessionById(ctx context.Context, sessionId uuid.UUID) (*Session, error) {
+	query := "SELECT sessionId, userId, creationTime, lastUseTime, userAgent, ip, revokedTime FROM Sessions WHERE sessionId = $1"
+	return getSessionFromRow(tx.tx.QueryRow(ctx, query, sessionId))
+}
+
+// ListUserSessions returns not revoked sessions used after activeSince, the most recently used first.
+func (tx *Tx) ListUserSessions(ctx context.Context, userId uuid.UUID, activeSince time.Time) ([]Session, error) {
+	query := `SELECT sessionId, userId, creationTime, lastUseTime, userAgent, ip, revokedTime FROM Sessions
+WHERE userId = $1 AND revokedTime IS NULL AND lastUseTime > $2 ORDER BY lastUseTime DESC`
+	rows, err := tx.tx.Query(ctx, query, userId, activeSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := getSessionFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (tx *Tx) RevokeSession(ctx context.Context, sessionId uuid.UUID, revokedTime time.Time) error {
+	query := "UPDATE Sessions SET revokedTime = $2 WHERE sessionId = $1 AND revokedTime IS NULL"
+	_, err := tx.tx.Exec(ctx, query, sessionId, revokedTime)
+	return err
+}
+
+func (tx *Tx) RevokeUserSessions(ctx context.Context, userId uuid.UUID, revokedTime time.Time) error {
+	query := "UPDATE Sessions SET revokedTime = $2 WHERE userId = $1 AND revokedTime IS NULL"
+	_, err := tx.tx.Exec(ctx, query, userId, revokedTime)
+	return err
+}
@@ -2,43 +2,80 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const uniqueViolationCode = "23505"
+
+// uniqueViolation returns name of the unique constraint err violates, or empty string
+// if err isn't a unique violation.
+func uniqueViolation(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
+
+// Storage is Store backed by PostgreSQL.
 type Storage struct {
 	pool *pgxpool.Pool
 }
 
-func NewStorage(databaseUrl string) (*Storage, error) {
+// Connect opens connection pool without checking database schema. It is meant for migrations,
+// the service itself uses NewStorage.
+func Connect(databaseUrl string) (*Storage, error) {
 	conn, err := pgxpool.New(context.Background(), databaseUrl)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't connect to the database: %w", err)
 	}
-	_, err = conn.Exec(context.Background(), usersTableSchema())
+	return &Storage{pool: conn}, nil
+}
+
+// NewStorage connects to the database and makes sure its schema matches this build.
+// Schema is changed only by migrations, see MigrateUp.
+func NewStorage(databaseUrl string) (*Storage, error) {
+	storage, err := Connect(databaseUrl)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create table Users in the database: %w", err)
+		return nil, err
 	}
 
-	_, err = conn.Exec(context.Background(), profilesTableSchema())
+	err = storage.CheckSchemaVersion(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create table Profiles in the database: %w", err)
+		storage.Close()
+		return nil, err
 	}
 
-	return &Storage{pool: conn}, nil
+	return storage, nil
 }
 
-func (s *Storage) Begin(ctx context.Context) (Tx, error) {
+func (s *Storage) Begin(ctx context.Context) (Transaction, error) {
 	tx, err := s.pool.Begin(ctx)
-	return Tx{tx: tx}, err
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx}, nil
+}
+
+// BeginSnapshot isn't read only, since some lookups lock rows.
+func (s *Storage) BeginSnapshot(ctx context.Context) (Transaction, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx}, nil
 }
 
 func (s *Storage) Close() {
 	s.pool.Close()
 }
 
+// Tx is Transaction of Storage.
 type Tx struct {
 	tx pgx.Tx
 }
@@ -48,5 +85,9 @@ func (tx *Tx) Commit(ctx context.Context) error {
 }
 
 func (tx *Tx) Rollback(ctx context.Context) error {
-	return tx.tx.Rollback(ctx)
+	err := tx.tx.Rollback(ctx)
+	if err == pgx.ErrTxClosed {
+		return nil
+	}
+	return err
 }
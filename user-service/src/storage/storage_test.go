@@ -0,0 +1,35 @@
+package storage_test
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	"soa-project/user-service/storage"
+	"soa-project/user-service/storage/storagetest"
+)
+
+// TestStorage runs the conformance suite against PostgreSQL at TEST_DATABASE_URL.
+// The database is wiped before every test, so it must be dedicated to tests.
+func TestStorage(t *testing.T) {
+	databaseUrl := os.Getenv("TEST_DATABASE_URL")
+	if databaseUrl == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		ctx := context.Background()
+		s, err := storage.Connect(databaseUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.MigrateDown(ctx, math.MaxInt); err != nil {
+			t.Fatalf("failed to reset database: %v", err)
+		}
+		if _, err := s.MigrateUp(ctx); err != nil {
+			t.Fatalf("failed to migrate database: %v", err)
+		}
+		return s
+	})
+}
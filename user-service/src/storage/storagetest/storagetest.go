@@ -0,0 +1,928 @@
+// Package storagetest contains tests every storage.Store implementation has to pass,
+// so the in-memory store can stand in for PostgreSQL in tests of the service.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+// Run runs the suite. newStore must return an empty store, i.e. with no users,
+// events or revocations, but with roles seeded by migrations.
+func Run(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Store)
+	}{
+		{"Transactions", testTransactions},
+		{"Users", testUsers},
+		{"UserUniqueness", testUserUniqueness},
+		{"UserLifecycle", testUserLifecycle},
+		{"ListUsers", testListUsers},
+		{"Profiles", testProfiles},
+		{"ProfileHistory", testProfileHistory},
+		{"BatchLookups", testBatchLookups},
+		{"SearchUsers", testSearchUsers},
+		{"RefreshTokens", testRefreshTokens},
+		{"Revocations", testRevocations},
+		{"PasswordResetTokens", testPasswordResetTokens},
+		{"EmailVerifications", testEmailVerifications},
+		{"AuthFailures", testAuthFailures},
+		{"SecondFactor", testSecondFactor},
+		{"Roles", testRoles},
+		{"Events", testEvents},
+		{"Sessions", testSessions},
+		{"PersonalAccessTokens", testPersonalAccessTokens},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(s.Close)
+			tt.test(t, s)
+		})
+	}
+}
+
+// now is truncated to the precision of PostgreSQL timestamps.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// inTx runs f in a transaction and commits it.
+func inTx(t *testing.T, s storage.Store, f func(ctx context.Context, tx storage.Transaction)) {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatalf("failed to begin tx: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	f(ctx, tx)
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatalf("failed to commit tx: %v", err)
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newUser(login string) storage.User {
+	return storage.User{
+		UserId:         uuid.New(),
+		Login:          login,
+		Email:          login + "@example.com",
+		HashedPassword: []byte("hash"),
+		PasswordScheme: "bcrypt",
+	}
+}
+
+func insertUser(t *testing.T, s storage.Store, login string) storage.User {
+	t.Helper()
+	user := newUser(login)
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertUser(ctx, user))
+	})
+	return user
+}
+
+func testTransactions(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	tx, err := s.Begin(ctx)
+	check(t, err)
+	check(t, tx.InsertUser(ctx, newUser("discarded")))
+	check(t, tx.Rollback(ctx))
+
+	committed := insertUser(t, s, "committed")
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		if _, err := tx.FindUserByLogin(ctx, "discarded"); err != storage.ErrNoSuchUser {
+			t.Errorf("FindUserByLogin of rolled back user returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+		if _, err := tx.FindUserById(ctx, committed.UserId); err != nil {
+			t.Errorf("FindUserById of committed user returned %v", err)
+		}
+	})
+
+	tx, err = s.BeginSnapshot(ctx)
+	check(t, err)
+	check(t, tx.Commit(ctx))
+	if err := tx.Rollback(ctx); err != nil {
+		t.Errorf("Rollback after Commit returned %v, where nil expected", err)
+	}
+}
+
+func testUsers(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "Alice")
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		lookups := []struct {
+			name string
+			find func() (*storage.User, error)
+		}{
+			{"By login", func() (*storage.User, error) { return tx.FindUserByLogin(ctx, "alice") }},
+			{"By email", func() (*storage.User, error) { return tx.FindUserByEmail(ctx, "ALICE@example.com") }},
+			{"By id", func() (*storage.User, error) { return tx.FindUserById(ctx, user.UserId) }},
+		}
+		for _, lookup := range lookups {
+			found, err := lookup.find()
+			if err != nil {
+				t.Errorf("%s: lookup returned %v", lookup.name, err)
+				continue
+			}
+			if found.UserId != user.UserId || found.Login != user.Login || found.PasswordScheme != user.PasswordScheme {
+				t.Errorf("%s: lookup returned %+v, where %+v expected", lookup.name, *found, user)
+			}
+		}
+
+		if _, err := tx.FindUserByLogin(ctx, "bob"); err != storage.ErrNoSuchUser {
+			t.Errorf("FindUserByLogin returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+
+		check(t, tx.UpdateUserPassword(ctx, user.UserId, []byte("new hash"), "argon2id"))
+		if err := tx.SetUserEmailVerified(ctx, user.UserId, "other@example.com"); err != storage.ErrNoSuchUser {
+			t.Errorf("SetUserEmailVerified with stale email returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+		check(t, tx.SetUserEmailVerified(ctx, user.UserId, user.Email))
+
+		found, err := tx.FindUserById(ctx, user.UserId)
+		check(t, err)
+		if string(found.HashedPassword) != "new hash" || found.PasswordScheme != "argon2id" || !found.EmailVerified {
+			t.Errorf("FindUserById returned %+v after updates", *found)
+		}
+	})
+}
+
+func testUserUniqueness(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+
+	tests := []struct {
+		name     string
+		login    string
+		email    string
+		expected error
+	}{
+		{"Same login", "alice", "other@example.com", storage.ErrLoginTaken},
+		{"Login in other case", "ALICE", "other@example.com", storage.ErrLoginTaken},
+		{"Email in other case", "bob", "Alice@Example.com", storage.ErrEmailTaken},
+		{"Different", "bob", "bob@example.com", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tx, err := s.Begin(ctx)
+			check(t, err)
+			defer tx.Rollback(ctx)
+
+			other := newUser(tt.login)
+			other.Email = tt.email
+			if err := tx.InsertUser(ctx, other); err != tt.expected {
+				t.Errorf("InsertUser returned %v, where %v expected", err, tt.expected)
+			}
+		})
+	}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.SoftDeleteUser(ctx, user.UserId, now()))
+		if err := tx.InsertUser(ctx, newUser("alice")); err != nil {
+			t.Errorf("InsertUser with login of deleted user returned %v, where nil expected", err)
+		}
+	})
+}
+
+func testUserLifecycle(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	t0 := now()
+	until := t0.Add(time.Hour)
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.SuspendUser(ctx, user.UserId, t0, &until, "spam"))
+		found, err := tx.FindUserById(ctx, user.UserId)
+		check(t, err)
+		if !found.IsSuspended(t0) || found.IsSuspended(until) || found.SuspensionReason != "spam" {
+			t.Errorf("FindUserById returned %+v after suspension", *found)
+		}
+
+		check(t, tx.ReinstateUser(ctx, user.UserId))
+		found, err = tx.FindUserById(ctx, user.UserId)
+		check(t, err)
+		if found.IsSuspended(t0) || found.SuspensionReason != "" {
+			t.Errorf("FindUserById returned %+v after reinstatement", *found)
+		}
+
+		if err := tx.SuspendUser(ctx, uuid.New(), t0, nil, ""); err != storage.ErrNoSuchUser {
+			t.Errorf("SuspendUser of unknown user returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: user.UserId, CreationTime: &t0, LastUpdateTime: &t0}))
+		admin, err := tx.FindRoleByName(ctx, storage.RoleAdmin)
+		check(t, err)
+		_, err = tx.AssignRole(ctx, user.UserId, admin.RoleId)
+		check(t, err)
+		check(t, tx.SoftDeleteUser(ctx, user.UserId, t0))
+		if err := tx.SoftDeleteUser(ctx, user.UserId, t0); err != storage.ErrNoSuchUser {
+			t.Errorf("second SoftDeleteUser returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+		if _, err := tx.FindUserById(ctx, user.UserId); err != storage.ErrNoSuchUser {
+			t.Errorf("FindUserById of deleted user returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+		if _, err := tx.FindProfileByUserId(ctx, user.UserId); err != storage.ErrNoSuchUser {
+			t.Errorf("FindProfileByUserId of deleted user returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+
+		deleted, err := tx.ListDeletedUsers(ctx, t0, 10)
+		check(t, err)
+		if len(deleted) != 0 {
+			t.Errorf("ListDeletedUsers before deletion returned %v, where none expected", deleted)
+		}
+		deleted, err = tx.ListDeletedUsers(ctx, t0.Add(time.Second), 10)
+		check(t, err)
+		if len(deleted) != 1 || deleted[0] != user.UserId {
+			t.Errorf("ListDeletedUsers returned %v, where [%v] expected", deleted, user.UserId)
+		}
+
+		check(t, tx.PurgeUser(ctx, user.UserId))
+		deleted, err = tx.ListDeletedUsers(ctx, t0.Add(time.Second), 10)
+		check(t, err)
+		if len(deleted) != 0 {
+			t.Errorf("ListDeletedUsers after purge returned %v, where none expected", deleted)
+		}
+		roles, err := tx.ListUserRoles(ctx, user.UserId)
+		check(t, err)
+		if len(roles) != 0 {
+			t.Errorf("ListUserRoles after purge returned %v, where none expected", roles)
+		}
+	})
+}
+
+func testListUsers(t *testing.T, s storage.Store) {
+	t0 := now()
+	logins := []string{"carol", "alice", "bob", "alex"}
+	users := make(map[string]storage.User)
+	for _, login := range logins {
+		users[login] = insertUser(t, s, login)
+	}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.SuspendUser(ctx, users["bob"].UserId, t0, nil, ""))
+		admin, err := tx.FindRoleByName(ctx, storage.RoleAdmin)
+		check(t, err)
+		_, err = tx.AssignRole(ctx, users["carol"].UserId, admin.RoleId)
+		check(t, err)
+	})
+
+	tests := []struct {
+		name     string
+		filter   storage.UserFilter
+		after    *storage.UserCursor
+		limit    int
+		expected []string
+	}{
+		{name: "All", limit: 10, expected: []string{"alex", "alice", "bob", "carol"}},
+		{name: "Limit", limit: 2, expected: []string{"alex", "alice"}},
+		{name: "After", after: &storage.UserCursor{Login: "alice", UserId: users["alice"].UserId}, limit: 10, expected: []string{"bob", "carol"}},
+		{name: "Prefix", filter: storage.UserFilter{Query: "AL"}, limit: 10, expected: []string{"alex", "alice"}},
+		{name: "Like characters are literal", filter: storage.UserFilter{Query: "a%"}, limit: 10, expected: nil},
+		{name: "Role", filter: storage.UserFilter{Role: storage.RoleAdmin}, limit: 10, expected: []string{"carol"}},
+		{name: "Suspended", filter: storage.UserFilter{SuspendedOnly: true}, limit: 10, expected: []string{"bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+				found, err := tx.ListUsers(ctx, tt.filter, tt.after, tt.limit, t0)
+				check(t, err)
+				var got []string
+				for _, user := range found {
+					got = append(got, user.Login)
+				}
+				if !equal(got, tt.expected) {
+					t.Errorf("ListUsers returned %v, where %v expected", got, tt.expected)
+				}
+			})
+		})
+	}
+}
+
+func equal[T comparable](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testProfiles(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	t0 := now()
+	birthDay := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		if _, err := tx.FindProfileByUserId(ctx, user.UserId); err != storage.ErrNoSuchUser {
+			t.Errorf("FindProfileByUserId without profile returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+
+		profile := storage.Profile{UserId: user.UserId, Name: "Alice", CreationTime: &t0, LastUpdateTime: &t0}
+		check(t, tx.InsertProfile(ctx, profile))
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: user.UserId, Name: "Ignored", CreationTime: &t0, LastUpdateTime: &t0}))
+
+		found, err := tx.FindProfileByUserId(ctx, user.UserId)
+		check(t, err)
+		if found.Visibility != storage.DefaultProfileVisibility {
+			t.Errorf("new profile has visibility %+v, where %+v expected", found.Visibility, storage.DefaultProfileVisibility)
+		}
+
+		profile.Surname = "Smith"
+		profile.PhoneNumber = "+15550100"
+		profile.BirthDay = &birthDay
+		profile.Visibility = found.Visibility
+		profile.Visibility.PhoneNumber = storage.VisibilityFollowers
+		check(t, tx.UpdateProfile(ctx, profile))
+
+		found, err = tx.FindProfileByUserId(ctx, user.UserId)
+		check(t, err)
+		if found.Name != "Alice" || found.Surname != "Smith" || found.PhoneNumber != "+15550100" ||
+			found.BirthDay == nil || !found.BirthDay.Equal(birthDay) || !found.CreationTime.Equal(t0) || found.Visibility != profile.Visibility {
+			t.Errorf("FindProfileByUserId returned %+v, where %+v expected", *found, profile)
+		}
+
+		locked, err := tx.FindProfileByUserIdForUpdate(ctx, user.UserId)
+		check(t, err)
+		if locked.UserId != found.UserId || locked.Surname != found.Surname || locked.BirthDay == nil || !locked.BirthDay.Equal(birthDay) {
+			t.Errorf("FindProfileByUserIdForUpdate returned %+v, where %+v expected", *locked, *found)
+		}
+		if _, err := tx.FindProfileByUserIdForUpdate(ctx, uuid.New()); err != storage.ErrNoSuchUser {
+			t.Errorf("FindProfileByUserIdForUpdate without profile returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+
+		later := t0.Add(time.Second)
+		check(t, tx.SetProfileAvatar(ctx, user.UserId, "avatar", "image/png", later))
+		// Avatar isn't part of regular updates
+		check(t, tx.UpdateProfile(ctx, profile))
+		found, err = tx.FindProfileByUserId(ctx, user.UserId)
+		check(t, err)
+		if found.AvatarId != "avatar" || found.AvatarContentType != "image/png" {
+			t.Errorf("FindProfileByUserId returned %+v, where avatar expected", *found)
+		}
+
+		if err := tx.SetProfileAvatar(ctx, uuid.New(), "", "", later); err != storage.ErrNoSuchUser {
+			t.Errorf("SetProfileAvatar without profile returned %v, where %v expected", err, storage.ErrNoSuchUser)
+		}
+	})
+}
+
+func testBatchLookups(t *testing.T, s storage.Store) {
+	alice := insertUser(t, s, "alice")
+	bob := insertUser(t, s, "bob")
+	carol := insertUser(t, s, "carol")
+	t0 := now()
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: alice.UserId, Name: "Alice", CreationTime: &t0, LastUpdateTime: &t0}))
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: carol.UserId, Name: "Carol", CreationTime: &t0, LastUpdateTime: &t0}))
+		check(t, tx.SoftDeleteUser(ctx, carol.UserId, t0))
+
+		ids := []uuid.UUID{bob.UserId, alice.UserId, carol.UserId, uuid.New()}
+		users, err := tx.FindUsersByIds(ctx, ids)
+		check(t, err)
+		var found []uuid.UUID
+		for _, user := range users {
+			found = append(found, user.UserId)
+		}
+		slices.SortFunc(found, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+		expected := []uuid.UUID{alice.UserId, bob.UserId}
+		slices.SortFunc(expected, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+		if !equal(found, expected) {
+			t.Errorf("FindUsersByIds returned %v, where %v expected", found, expected)
+		}
+
+		profiles, err := tx.FindProfilesByUserIds(ctx, ids)
+		check(t, err)
+		if len(profiles) != 1 || profiles[0].UserId != alice.UserId || profiles[0].Name != "Alice" {
+			t.Errorf("FindProfilesByUserIds returned %+v, where profile of %v expected", profiles, alice.UserId)
+		}
+
+		users, err = tx.FindUsersByIds(ctx, nil)
+		check(t, err)
+		if len(users) != 0 {
+			t.Errorf("FindUsersByIds without ids returned %+v, where none expected", users)
+		}
+	})
+}
+
+func testSearchUsers(t *testing.T, s storage.Store) {
+	alice := insertUser(t, s, "Alice")
+	alice2 := insertUser(t, s, "alice2")
+	dave := insertUser(t, s, "dave")
+	erin := insertUser(t, s, "erin")
+	frank := insertUser(t, s, "frank")
+	t0 := now()
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: alice.UserId, Name: "Bob", CreationTime: &t0, LastUpdateTime: &t0}))
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: dave.UserId, Surname: "Alicee", CreationTime: &t0, LastUpdateTime: &t0}))
+
+		hidden := storage.Profile{UserId: erin.UserId, Name: "Alice", CreationTime: &t0, LastUpdateTime: &t0, Visibility: storage.DefaultProfileVisibility}
+		hidden.Visibility.Name = storage.VisibilityOnlyMe
+		check(t, tx.InsertProfile(ctx, hidden))
+		check(t, tx.UpdateProfile(ctx, hidden))
+
+		check(t, tx.InsertProfile(ctx, storage.Profile{UserId: frank.UserId, Name: "Alice", CreationTime: &t0, LastUpdateTime: &t0}))
+		check(t, tx.SoftDeleteUser(ctx, frank.UserId, t0))
+	})
+
+	expected := []uuid.UUID{alice.UserId, alice2.UserId, dave.UserId}
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		found, err := tx.SearchUsers(ctx, "alice", nil, 10)
+		check(t, err)
+		var ids []uuid.UUID
+		for _, user := range found {
+			ids = append(ids, user.User.UserId)
+		}
+		if !equal(ids, expected) {
+			t.Fatalf("SearchUsers returned %v, where %v expected", ids, expected)
+		}
+		if found[0].Score != storage.ScoreExactLogin || found[1].Score != storage.ScoreLoginPrefix || found[2].Score <= 0 || found[2].Score >= storage.ScoreLoginPrefix {
+			t.Errorf("SearchUsers returned scores %v, %v, %v", found[0].Score, found[1].Score, found[2].Score)
+		}
+
+		page, err := tx.SearchUsers(ctx, "alice", nil, 2)
+		check(t, err)
+		if len(page) != 2 {
+			t.Fatalf("SearchUsers returned %v users, where 2 expected", len(page))
+		}
+		last := page[1]
+		page, err = tx.SearchUsers(ctx, "alice", &storage.UserSearchCursor{Score: last.Score, UserId: last.User.UserId}, 2)
+		check(t, err)
+		if len(page) != 1 || page[0].User.UserId != dave.UserId {
+			t.Errorf("SearchUsers after %v returned %+v, where only %v expected", last.User.UserId, page, dave.UserId)
+		}
+
+		found, err = tx.SearchUsers(ctx, "a%", nil, 10)
+		check(t, err)
+		if len(found) != 0 {
+			t.Errorf("SearchUsers with wildcard returned %+v, where none expected", found)
+		}
+	})
+}
+
+func testProfileHistory(t *testing.T, s storage.Store) {
+	alice := insertUser(t, s, "alice")
+	bob := insertUser(t, s, "bob")
+	t0 := now()
+	t1 := t0.Add(time.Second)
+
+	change := func(userId uuid.UUID, at *time.Time, name string) storage.ProfileChange {
+		return storage.ProfileChange{
+			ChangeId:   uuid.New(),
+			UserId:     userId,
+			ActorId:    userId,
+			ChangeTime: at,
+			Ip:         "127.0.0.1",
+			UserAgent:  "test",
+			Fields:     []storage.ProfileFieldChange{{Field: "name", OldValue: "", NewValue: name}},
+		}
+	}
+	// Changes made at the same time are told apart by id
+	first, second, third := change(alice.UserId, &t0, "first"), change(alice.UserId, &t0, "second"), change(alice.UserId, &t1, "third")
+	if bytes.Compare(first.ChangeId[:], second.ChangeId[:]) < 0 {
+		first, second = second, first
+	}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertProfileChange(ctx, first))
+		check(t, tx.InsertProfileChange(ctx, third))
+		check(t, tx.InsertProfileChange(ctx, second))
+		check(t, tx.InsertProfileChange(ctx, change(bob.UserId, &t1, "bob")))
+
+		page, err := tx.ListProfileChanges(ctx, alice.UserId, nil, 2)
+		check(t, err)
+		if len(page) != 2 || page[0].ChangeId != third.ChangeId || page[1].ChangeId != first.ChangeId {
+			t.Fatalf("ListProfileChanges returned %+v, where the latest two changes expected", page)
+		}
+		if got := page[0]; got.ActorId != alice.UserId || got.Ip != "127.0.0.1" || got.UserAgent != "test" || !got.ChangeTime.Equal(t1) ||
+			len(got.Fields) != 1 || got.Fields[0] != third.Fields[0] {
+			t.Errorf("ListProfileChanges returned %+v, where %+v expected", got, third)
+		}
+
+		page, err = tx.ListProfileChanges(ctx, alice.UserId, &storage.ProfileChangeCursor{ChangeTime: *page[1].ChangeTime, ChangeId: page[1].ChangeId}, 2)
+		check(t, err)
+		if len(page) != 1 || page[0].ChangeId != second.ChangeId {
+			t.Errorf("ListProfileChanges after cursor returned %+v, where only %v expected", page, second.ChangeId)
+		}
+
+		check(t, tx.PurgeUser(ctx, alice.UserId))
+		page, err = tx.ListProfileChanges(ctx, alice.UserId, nil, 10)
+		check(t, err)
+		if len(page) != 0 {
+			t.Errorf("ListProfileChanges returned %+v after user was purged", page)
+		}
+		page, err = tx.ListProfileChanges(ctx, bob.UserId, nil, 10)
+		check(t, err)
+		if len(page) != 1 {
+			t.Errorf("ListProfileChanges returned %+v for other user, where single change expected", page)
+		}
+	})
+}
+
+func testRefreshTokens(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	t0 := now()
+	expiration := t0.Add(time.Hour)
+	familyId := uuid.New()
+	first := storage.RefreshToken{TokenHash: []byte("first"), FamilyId: familyId, UserId: user.UserId, CreationTime: &t0, ExpirationTime: &expiration}
+	other := storage.RefreshToken{TokenHash: []byte("other"), FamilyId: uuid.New(), UserId: user.UserId, CreationTime: &t0, ExpirationTime: &expiration}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertRefreshToken(ctx, first))
+		check(t, tx.InsertRefreshToken(ctx, other))
+		if _, err := tx.FindRefreshTokenByHash(ctx, []byte("unknown")); err != storage.ErrNoSuchRefreshToken {
+			t.Errorf("FindRefreshTokenByHash returned %v, where %v expected", err, storage.ErrNoSuchRefreshToken)
+		}
+
+		check(t, tx.MarkRefreshTokenUsed(ctx, first.TokenHash))
+		check(t, tx.RevokeRefreshTokenFamily(ctx, familyId))
+
+		found, err := tx.FindRefreshTokenByHash(ctx, first.TokenHash)
+		check(t, err)
+		if !found.Used || !found.Revoked || found.FamilyId != familyId || !found.ExpirationTime.Equal(expiration) {
+			t.Errorf("FindRefreshTokenByHash returned %+v after rotation", *found)
+		}
+		found, err = tx.FindRefreshTokenByHash(ctx, other.TokenHash)
+		check(t, err)
+		if found.Used || found.Revoked {
+			t.Errorf("token of other family was changed: %+v", *found)
+		}
+
+		check(t, tx.RevokeUserRefreshTokens(ctx, user.UserId))
+		found, err = tx.FindRefreshTokenByHash(ctx, other.TokenHash)
+		check(t, err)
+		if !found.Revoked {
+			t.Errorf("RevokeUserRefreshTokens didn't revoke %+v", *found)
+		}
+	})
+}
+
+func testRevocations(t *testing.T, s storage.Store) {
+	t0 := now()
+	at := func(d time.Duration) *time.Time {
+		t := t0.Add(d)
+		return &t
+	}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertRevocation(ctx, storage.Revocation{Kind: storage.RevocationKindUser, Subject: "u", RevocationTime: at(2 * time.Second), ExpirationTime: at(time.Hour)}))
+		// Older revocation of the same subject doesn't move times back
+		check(t, tx.InsertRevocation(ctx, storage.Revocation{Kind: storage.RevocationKindUser, Subject: "u", RevocationTime: at(time.Second), ExpirationTime: at(2 * time.Hour)}))
+		check(t, tx.InsertRevocation(ctx, storage.Revocation{Kind: storage.RevocationKindToken, Subject: "t", RevocationTime: at(time.Second), ExpirationTime: at(time.Minute)}))
+		check(t, tx.InsertRevocation(ctx, storage.Revocation{Kind: storage.RevocationKindSession, Subject: "s", RevocationTime: at(3 * time.Second), ExpirationTime: at(time.Second)}))
+
+		revocations, err := tx.ListRevocations(ctx, t0, t0.Add(2*time.Second))
+		check(t, err)
+		if len(revocations) != 2 || revocations[0].Subject != "t" || revocations[1].Subject != "u" {
+			t.Fatalf("ListRevocations returned %+v, where t and u expected", revocations)
+		}
+		if !revocations[1].RevocationTime.Equal(*at(2 * time.Second)) || !revocations[1].ExpirationTime.Equal(*at(2 * time.Hour)) {
+			t.Errorf("ListRevocations returned %+v, where merged times expected", revocations[1])
+		}
+
+		revocations, err = tx.ListRevocations(ctx, t0.Add(time.Second), t0)
+		check(t, err)
+		if len(revocations) != 2 {
+			t.Errorf("ListRevocations since %v returned %+v, where u and s expected", t0.Add(time.Second), revocations)
+		}
+
+		check(t, tx.DeleteExpiredRevocations(ctx, t0.Add(time.Minute)))
+		revocations, err = tx.ListRevocations(ctx, t0, t0)
+		check(t, err)
+		if len(revocations) != 1 || revocations[0].Subject != "u" {
+			t.Errorf("ListRevocations after cleanup returned %+v, where only u expected", revocations)
+		}
+	})
+}
+
+func testPasswordResetTokens(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	t0 := now()
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertPasswordResetToken(ctx, storage.PasswordResetToken{TokenHash: []byte("reset"), UserId: user.UserId, CreationTime: &t0, ExpirationTime: &t0}))
+		if _, err := tx.FindPasswordResetTokenByHash(ctx, []byte("unknown")); err != storage.ErrNoSuchPasswordResetToken {
+			t.Errorf("FindPasswordResetTokenByHash returned %v, where %v expected", err, storage.ErrNoSuchPasswordResetToken)
+		}
+
+		check(t, tx.InvalidateUserPasswordResetTokens(ctx, user.UserId))
+		found, err := tx.FindPasswordResetTokenByHash(ctx, []byte("reset"))
+		check(t, err)
+		if !found.Used || found.UserId != user.UserId {
+			t.Errorf("FindPasswordResetTokenByHash returned %+v after invalidation", *found)
+		}
+	})
+}
+
+func testEmailVerifications(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	t0 := now()
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		if _, err := tx.FindEmailVerificationByUserId(ctx, user.UserId); err != storage.ErrNoSuchEmailVerification {
+			t.Errorf("FindEmailVerificationByUserId returned %v, where %v expected", err, storage.ErrNoSuchEmailVerification)
+		}
+
+		check(t, tx.UpsertEmailVerification(ctx, storage.EmailVerification{UserId: user.UserId, LastSentTime: &t0, WindowStartTime: &t0, SentInWindow: 1}))
+		check(t, tx.UpsertEmailVerification(ctx, storage.EmailVerification{UserId: user.UserId, LastSentTime: &t0, WindowStartTime: &t0, SentInWindow: 2}))
+
+		found, err := tx.FindEmailVerificationByUserId(ctx, user.UserId)
+		check(t, err)
+		if found.SentInWindow != 2 || !found.LastSentTime.Equal(t0) {
+			t.Errorf("FindEmailVerificationByUserId returned %+v after upsert", *found)
+		}
+	})
+}
+
+func testAuthFailures(t *testing.T, s storage.Store) {
+	t0 := now()
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.UpsertAuthFailure(ctx, storage.AuthFailure{Key: "ip:127.0.0.1", Failures: 1, LastFailureTime: &t0}))
+		check(t, tx.UpsertAuthFailure(ctx, storage.AuthFailure{Key: "ip:127.0.0.1", Failures: 2, LastFailureTime: &t0, LockedUntil: &t0}))
+
+		found, err := tx.FindAuthFailure(ctx, "ip:127.0.0.1")
+		check(t, err)
+		if found.Failures != 2 || found.LockedUntil == nil || !found.LockedUntil.Equal(t0) {
+			t.Errorf("FindAuthFailure returned %+v after upsert", *found)
+		}
+
+		check(t, tx.DeleteAuthFailure(ctx, "ip:127.0.0.1"))
+		if _, err := tx.FindAuthFailure(ctx, "ip:127.0.0.1"); err != storage.ErrNoSuchAuthFailure {
+			t.Errorf("FindAuthFailure after delete returned %v, where %v expected", err, storage.ErrNoSuchAuthFailure)
+		}
+	})
+}
+
+func testSecondFactor(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	other := insertUser(t, s, "bob")
+	t0 := now()
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.UpsertTotpSecret(ctx, storage.TotpSecret{UserId: user.UserId, Secret: []byte("old"), Confirmed: true, CreationTime: &t0}))
+		check(t, tx.UpsertTotpSecret(ctx, storage.TotpSecret{UserId: user.UserId, Secret: []byte("new"), CreationTime: &t0}))
+		check(t, tx.ConfirmTotpSecret(ctx, user.UserId))
+		check(t, tx.UpdateTotpLastUsedStep(ctx, user.UserId, 42))
+
+		secret, err := tx.FindTotpSecretByUserId(ctx, user.UserId)
+		check(t, err)
+		if string(secret.Secret) != "new" || !secret.Confirmed || secret.LastUsedStep != 42 {
+			t.Errorf("FindTotpSecretByUserId returned %+v", *secret)
+		}
+
+		check(t, tx.DeleteTotpSecret(ctx, user.UserId))
+		if _, err := tx.FindTotpSecretByUserId(ctx, user.UserId); err != storage.ErrNoSuchTotpSecret {
+			t.Errorf("FindTotpSecretByUserId after delete returned %v, where %v expected", err, storage.ErrNoSuchTotpSecret)
+		}
+
+		check(t, tx.InsertRecoveryCodes(ctx, user.UserId, [][]byte{[]byte("a"), []byte("b")}))
+		uses := []struct {
+			userId   uuid.UUID
+			code     string
+			expected bool
+		}{
+			{other.UserId, "a", false},
+			{user.UserId, "a", true},
+			{user.UserId, "a", false},
+			{user.UserId, "c", false},
+		}
+		for _, use := range uses {
+			used, err := tx.UseRecoveryCode(ctx, use.userId, []byte(use.code))
+			check(t, err)
+			if used != use.expected {
+				t.Errorf("UseRecoveryCode(%v) returned %v, where %v expected", use.code, used, use.expected)
+			}
+		}
+
+		count, err := tx.CountUnusedRecoveryCodes(ctx, user.UserId)
+		check(t, err)
+		if count != 1 {
+			t.Errorf("CountUnusedRecoveryCodes returned %v, where 1 expected", count)
+		}
+
+		check(t, tx.DeleteUserRecoveryCodes(ctx, user.UserId))
+		count, err = tx.CountUnusedRecoveryCodes(ctx, user.UserId)
+		check(t, err)
+		if count != 0 {
+			t.Errorf("CountUnusedRecoveryCodes after delete returned %v, where 0 expected", count)
+		}
+	})
+}
+
+func testRoles(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		roles, err := tx.ListRoles(ctx)
+		check(t, err)
+		if len(roles) != 2 || roles[0].RoleName != storage.RoleAdmin || roles[1].RoleName != storage.RoleModerator {
+			t.Fatalf("ListRoles returned %+v, where seeded roles expected", roles)
+		}
+
+		if _, err := tx.FindRoleByName(ctx, "unknown"); err != storage.ErrNoSuchRole {
+			t.Errorf("FindRoleByName returned %v, where %v expected", err, storage.ErrNoSuchRole)
+		}
+		moderator, err := tx.FindRoleByName(ctx, storage.RoleModerator)
+		check(t, err)
+
+		changes := []struct {
+			name     string
+			change   func() (bool, error)
+			expected bool
+		}{
+			{"Assign", func() (bool, error) { return tx.AssignRole(ctx, user.UserId, moderator.RoleId) }, true},
+			{"Assign again", func() (bool, error) { return tx.AssignRole(ctx, user.UserId, moderator.RoleId) }, false},
+			{"Assign admin", func() (bool, error) { return tx.AssignRole(ctx, user.UserId, roles[0].RoleId) }, true},
+			{"Revoke admin", func() (bool, error) { return tx.RevokeRole(ctx, user.UserId, roles[0].RoleId) }, true},
+			{"Revoke admin again", func() (bool, error) { return tx.RevokeRole(ctx, user.UserId, roles[0].RoleId) }, false},
+		}
+		for _, change := range changes {
+			changed, err := change.change()
+			check(t, err)
+			if changed != change.expected {
+				t.Errorf("%s returned %v, where %v expected", change.name, changed, change.expected)
+			}
+		}
+
+		userRoles, err := tx.ListUserRoles(ctx, user.UserId)
+		check(t, err)
+		if len(userRoles) != 1 || userRoles[0] != *moderator {
+			t.Errorf("ListUserRoles returned %+v, where [%+v] expected", userRoles, *moderator)
+		}
+	})
+
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	check(t, err)
+	defer tx.Rollback(ctx)
+	if _, err := tx.AssignRole(ctx, user.UserId, -1); err == nil {
+		t.Errorf("AssignRole of unknown role succeeded")
+	}
+}
+
+func testEvents(t *testing.T, s storage.Store) {
+	t0 := now()
+	var eventIds []uuid.UUID
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		for i := range 3 {
+			creationTime := t0.Add(time.Duration(2-i) * time.Second)
+			event := storage.Event{EventId: uuid.New(), Kind: "kind", Payload: []byte("{}"), CreationTime: &creationTime}
+			check(t, tx.InsertEvent(ctx, event))
+			eventIds = append(eventIds, event.EventId)
+		}
+	})
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		events, err := tx.ListEvents(ctx, 2)
+		check(t, err)
+		if len(events) != 2 || events[0].EventId != eventIds[2] || events[1].EventId != eventIds[1] {
+			t.Fatalf("ListEvents returned %+v, where the oldest two expected", events)
+		}
+
+		check(t, tx.DeleteEvent(ctx, eventIds[2]))
+		events, err = tx.ListEvents(ctx, 10)
+		check(t, err)
+		if len(events) != 2 || string(events[0].Payload) != "{}" {
+			t.Errorf("ListEvents after delete returned %+v", events)
+		}
+	})
+}
+
+func testSessions(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	t0 := now()
+	first := storage.Session{SessionId: uuid.New(), UserId: user.UserId, CreationTime: &t0, LastUseTime: &t0, UserAgent: "curl", Ip: "10.0.0.1"}
+	second := storage.Session{SessionId: uuid.New(), UserId: user.UserId, CreationTime: &t0, LastUseTime: &t0}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertSession(ctx, first))
+		check(t, tx.InsertSession(ctx, second))
+
+		check(t, tx.TouchSession(ctx, first.SessionId, t0.Add(time.Minute), "", "firefox"))
+		found, err := tx.FindSessionById(ctx, first.SessionId)
+		check(t, err)
+		if found.Ip != "10.0.0.1" || found.UserAgent != "firefox" || !found.LastUseTime.Equal(t0.Add(time.Minute)) || found.RevokedTime != nil {
+			t.Errorf("FindSessionById returned %+v after touch", *found)
+		}
+
+		sessions, err := tx.ListUserSessions(ctx, user.UserId, t0.Add(-time.Second))
+		check(t, err)
+		if len(sessions) != 2 || sessions[0].SessionId != first.SessionId {
+			t.Errorf("ListUserSessions returned %+v, where recently used first expected", sessions)
+		}
+		sessions, err = tx.ListUserSessions(ctx, user.UserId, t0)
+		check(t, err)
+		if len(sessions) != 1 {
+			t.Errorf("ListUserSessions since %v returned %+v, where one session expected", t0, sessions)
+		}
+
+		check(t, tx.RevokeSession(ctx, first.SessionId, t0))
+		check(t, tx.RevokeSession(ctx, first.SessionId, t0.Add(time.Hour)))
+		found, err = tx.FindSessionById(ctx, first.SessionId)
+		check(t, err)
+		if found.RevokedTime == nil || !found.RevokedTime.Equal(t0) {
+			t.Errorf("FindSessionById returned %+v, where revocation time %v expected", *found, t0)
+		}
+
+		check(t, tx.RevokeUserSessions(ctx, user.UserId, t0))
+		sessions, err = tx.ListUserSessions(ctx, user.UserId, t0.Add(-time.Second))
+		check(t, err)
+		if len(sessions) != 0 {
+			t.Errorf("ListUserSessions after revocation returned %+v", sessions)
+		}
+
+		if _, err := tx.FindSessionById(ctx, uuid.New()); err != storage.ErrNoSuchSession {
+			t.Errorf("FindSessionById returned %v, where %v expected", err, storage.ErrNoSuchSession)
+		}
+	})
+}
+
+func testPersonalAccessTokens(t *testing.T, s storage.Store) {
+	user := insertUser(t, s, "alice")
+	other := insertUser(t, s, "bob")
+	t0 := now()
+	later := t0.Add(time.Second)
+	expiration := t0.Add(time.Hour)
+	older := storage.PersonalAccessToken{TokenId: uuid.New(), UserId: user.UserId, Name: "ci", TokenHash: []byte("older"),
+		Scopes: []string{"account:read"}, CreationTime: &t0, ExpirationTime: &expiration}
+	newer := storage.PersonalAccessToken{TokenId: uuid.New(), UserId: user.UserId, Name: "backup", TokenHash: []byte("newer"),
+		Scopes: []string{"account:read", "profile:write"}, CreationTime: &later, ExpirationTime: &expiration}
+
+	inTx(t, s, func(ctx context.Context, tx storage.Transaction) {
+		check(t, tx.InsertPersonalAccessToken(ctx, older))
+		check(t, tx.InsertPersonalAccessToken(ctx, newer))
+
+		found, err := tx.FindPersonalAccessTokenByHash(ctx, newer.TokenHash)
+		check(t, err)
+		if found.TokenId != newer.TokenId || !equal(found.Scopes, newer.Scopes) || found.LastUseTime != nil {
+			t.Errorf("FindPersonalAccessTokenByHash returned %+v, where %+v expected", *found, newer)
+		}
+		if _, err := tx.FindPersonalAccessTokenByHash(ctx, []byte("unknown")); err != storage.ErrNoSuchPersonalAccessToken {
+			t.Errorf("FindPersonalAccessTokenByHash returned %v, where %v expected", err, storage.ErrNoSuchPersonalAccessToken)
+		}
+
+		check(t, tx.TouchPersonalAccessToken(ctx, older.TokenId, later))
+		tokens, err := tx.ListUserPersonalAccessTokens(ctx, user.UserId, t0)
+		check(t, err)
+		if len(tokens) != 2 || tokens[0].TokenId != newer.TokenId || tokens[1].LastUseTime == nil || !tokens[1].LastUseTime.Equal(later) {
+			t.Errorf("ListUserPersonalAccessTokens returned %+v, where the newest first expected", tokens)
+		}
+		tokens, err = tx.ListUserPersonalAccessTokens(ctx, user.UserId, expiration)
+		check(t, err)
+		if len(tokens) != 0 {
+			t.Errorf("ListUserPersonalAccessTokens at expiration returned %+v", tokens)
+		}
+
+		revocations := []struct {
+			name     string
+			userId   uuid.UUID
+			expected bool
+		}{
+			{"Other user", other.UserId, false},
+			{"Owner", user.UserId, true},
+			{"Owner again", user.UserId, false},
+		}
+		for _, revocation := range revocations {
+			revoked, err := tx.RevokePersonalAccessToken(ctx, revocation.userId, older.TokenId, t0)
+			check(t, err)
+			if revoked != revocation.expected {
+				t.Errorf("%s: RevokePersonalAccessToken returned %v, where %v expected", revocation.name, revoked, revocation.expected)
+			}
+		}
+
+		tokens, err = tx.ListUserPersonalAccessTokens(ctx, user.UserId, t0)
+		check(t, err)
+		if len(tokens) != 1 || tokens[0].TokenId != newer.TokenId {
+			t.Errorf("ListUserPersonalAccessTokens after revocation returned %+v", tokens)
+		}
+	})
+}
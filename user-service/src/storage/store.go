@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store is a transactional storage of the service. Storage implements it on top of
+// PostgreSQL, storage/memory keeps everything in process memory for tests.
+type Store interface {
+	Begin(ctx context.Context) (Transaction, error)
+	// BeginSnapshot starts transaction, all reads of which observe the same state.
+	BeginSnapshot(ctx context.Context) (Transaction, error)
+	Close()
+}
+
+// Transaction gives access to the data. Changes are visible to other transactions only
+// after Commit. Rollback after Commit is a no-op, so it may always be deferred.
+type Transaction interface {
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+
+	InsertUser(ctx context.Context, user User) error
+	FindUserByLogin(ctx context.Context, login string) (*User, error)
+	FindUserByEmail(ctx context.Context, email string) (*User, error)
+	FindUserById(ctx context.Context, userId uuid.UUID) (*User, error)
+	FindUsersByIds(ctx context.Context, userIds []uuid.UUID) ([]User, error)
+	UpdateUserPassword(ctx context.Context, userId uuid.UUID, hashedPassword []byte, passwordScheme string) error
+	SetUserEmailVerified(ctx context.Context, userId uuid.UUID, email string) error
+	SuspendUser(ctx context.Context, userId uuid.UUID, suspendedTime time.Time, suspendedUntil *time.Time, reason string) error
+	ReinstateUser(ctx context.Context, userId uuid.UUID) error
+	SoftDeleteUser(ctx context.Context, userId uuid.UUID, deletedTime time.Time) error
+	ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]uuid.UUID, error)
+	PurgeUser(ctx context.Context, userId uuid.UUID) error
+	ListUsers(ctx context.Context, filter UserFilter, after *UserCursor, limit int, now time.Time) ([]User, error)
+	SearchUsers(ctx context.Context, query string, after *UserSearchCursor, limit int) ([]FoundUser, error)
+
+	InsertProfile(ctx context.Context, profile Profile) error
+	FindProfileByUserId(ctx context.Context, userId uuid.UUID) (*Profile, error)
+	FindProfilesByUserIds(ctx context.Context, userIds []uuid.UUID) ([]Profile, error)
+	FindProfileByUserIdForUpdate(ctx context.Context, userId uuid.UUID) (*Profile, error)
+	UpdateProfile(ctx context.Context, profile Profile) error
+	SetProfileAvatar(ctx context.Context, userId uuid.UUID, avatarId string, contentType string, updateTime time.Time) error
+
+	InsertProfileChange(ctx context.Context, change ProfileChange) error
+	ListProfileChanges(ctx context.Context, userId uuid.UUID, after *ProfileChangeCursor, limit int) ([]ProfileChange, error)
+
+	InsertRefreshToken(ctx context.Context, token RefreshToken) error
+	FindRefreshTokenByHash(ctx context.Context, tokenHash []byte) (*RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, tokenHash []byte) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyId uuid.UUID) error
+	RevokeUserRefreshTokens(ctx context.Context, userId uuid.UUID) error
+
+	InsertRevocation(ctx context.Context, revocation Revocation) error
+	ListRevocations(ctx context.Context, since time.Time, now time.Time) ([]Revocation, error)
+	DeleteExpiredRevocations(ctx context.Context, now time.Time) error
+
+	InsertPasswordResetToken(ctx context.Context, token PasswordResetToken) error
+	FindPasswordResetTokenByHash(ctx context.Context, tokenHash []byte) (*PasswordResetToken, error)
+	InvalidateUserPasswordResetTokens(ctx context.Context, userId uuid.UUID) error
+
+	FindEmailVerificationByUserId(ctx context.Context, userId uuid.UUID) (*EmailVerification, error)
+	UpsertEmailVerification(ctx context.Context, verification EmailVerification) error
+
+	FindAuthFailure(ctx context.Context, key string) (*AuthFailure, error)
+	UpsertAuthFailure(ctx context.Context, failure AuthFailure) error
+	DeleteAuthFailure(ctx context.Context, key string) error
+
+	UpsertTotpSecret(ctx context.Context, secret TotpSecret) error
+	FindTotpSecretByUserId(ctx context.Context, userId uuid.UUID) (*TotpSecret, error)
+	ConfirmTotpSecret(ctx context.Context, userId uuid.UUID) error
+	UpdateTotpLastUsedStep(ctx context.Context, userId uuid.UUID, step int64) error
+	DeleteTotpSecret(ctx context.Context, userId uuid.UUID) error
+	InsertRecoveryCodes(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, userId uuid.UUID, codeHash []byte) (bool, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userId uuid.UUID) (int, error)
+	DeleteUserRecoveryCodes(ctx context.Context, userId uuid.UUID) error
+
+	ListRoles(ctx context.Context) ([]Role, error)
+	ListUserRoles(ctx context.Context, userId uuid.UUID) ([]Role, error)
+	FindRoleByName(ctx context.Context, roleName string) (*Role, error)
+	AssignRole(ctx context.Context, userId uuid.UUID, roleId int) (bool, error)
+	RevokeRole(ctx context.Context, userId uuid.UUID, roleId int) (bool, error)
+
+	InsertEvent(ctx context.Context, event Event) error
+	ListEvents(ctx context.Context, limit int) ([]Event, error)
+	DeleteEvent(ctx context.Context, eventId uuid.UUID) error
+
+	InsertSession(ctx context.Context, session Session) error
+	TouchSession(ctx context.Context, sessionId uuid.UUID, lastUseTime time.Time, ip string, userAgent string) error
+	FindSessionById(ctx context.Context, sessionId uuid.UUID) (*Session, error)
+	ListUserSessions(ctx context.Context, userId uuid.UUID, activeSince time.Time) ([]Session, error)
+	RevokeSession(ctx context.Context, sessionId uuid.UUID, revokedTime time.Time) error
+	RevokeUserSessions(ctx context.Context, userId uuid.UUID, revokedTime time.Time) error
+
+	InsertPersonalAccessToken(ctx context.Context, token PersonalAccessToken) error
+	FindPersonalAccessTokenByHash(ctx context.Context, tokenHash []byte) (*PersonalAccessToken, error)
+	ListUserPersonalAccessTokens(ctx context.Context, userId uuid.UUID, activeAt time.Time) ([]PersonalAccessToken, error)
+	TouchPersonalAccessToken(ctx context.Context, tokenId uuid.UUID, lastUseTime time.Time) error
+	RevokePersonalAccessToken(ctx context.Context, userId uuid.UUID, tokenId uuid.UUID, revokedTime time.Time) (bool, error)
+}
+
+var (
+	_ Store       = (*Storage)(nil)
+	_ Transaction = (*Tx)(nil)
+)
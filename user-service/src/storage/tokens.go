@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchRefreshToken = errors.New("no refresh token were found")
+
+type RefreshToken struct {
+	TokenHash      []byte
+	FamilyId       uuid.UUID
+	UserId         uuid.UUID
+	CreationTime   *time.Time
+	ExpirationTime *time.Time
+	Used           bool
+	Revoked        bool
+}
+
+func (tx *Tx) InsertRefreshToken(ctx context.Context, token RefreshToken) error {
+	query := "INSERT INTO RefreshTokens (tokenHash, familyId, userId, creationTime, expirationTime, used, revoked) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := tx.tx.Exec(ctx, query, token.TokenHash, token.FamilyId, token.UserId, token.CreationTime, token.ExpirationTime, token.Used, token.Revoked)
+	return err
+}
+
+func getRefreshTokenFromRow(row pgx.Row) (*RefreshToken, error) {
+	var token RefreshToken
+	err := row.Scan(&token.TokenHash, &token.FamilyId, &token.UserId, &token.CreationTime, &token.ExpirationTime, &token.Used, &token.Revoked)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// FindRefreshTokenByHash locks the found row until the end of the transaction,
+// so concurrent rotations of the same token are serialized.
+func (tx *Tx) FindRefreshTokenByHash(ctx context.Context, tokenHash []byte) (*RefreshToken, error) {
+	query := "SELECT tokenHash, familyId, userId, creationTime, expirationTime, used, revoked FROM RefreshTokens WHERE tokenHash = $1 FOR UPDATE"
+	return getRefreshTokenFromRow(tx.tx.QueryRow(ctx, query, tokenHash))
+}
+
+func (tx *Tx) MarkRefreshTokenUsed(ctx context.Context, tokenHash []byte) error {
+	query := "UPDATE RefreshTokens SET used = TRUE WHERE tokenHash = $1"
+	_, err := tx.tx.Exec(ctx, query, tokenHash)
+	return err
+}
+
+func (tx *Tx) RevokeRefreshTokenFamily(ctx context.Context, familyId uuid.UUID) error {
+	query := "UPDATE RefreshTokens SET revoked = TRUE WHERE familyId = $1"
+	_, err := tx.tx.Exec(ctx, query, familyId)
+	return err
+}
+
+func (tx *Tx) RevokeUserRefreshTokens(ctx context.Context, userId uuid.UUID) error {
+	query := "UPDATE RefreshTokens SET revoked = TRUE WHERE userId = $1 AND NOT revoked"
+	_, err := tx.tx.Exec(ctx, query, userId)
+	return err
+}
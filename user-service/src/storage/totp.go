@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchTotpSecret = errors.New("no totp secret were found")
+
+// TotpSecret is second factor of the user. Until it is confirmed with a valid code
+// it doesn't take part in authentication.
+type TotpSecret struct {
+	UserId       uuid.UUID
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+	CreationTime *time.Time
+}
+
+// UpsertTotpSecret stores new unconfirmed secret, replacing the previous one.
+func (tx *Tx) UpsertTotpSecret(ctx context.Context, secret TotpSecret) error {
+	query := `INSERT INTO TotpSecrets (userId, secret, confirmed, lastUsedStep, creationTime) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (userId) DO UPDATE SET secret = EXCLUDED.secret, confirmed = EXCLUDED.confirmed, lastUsedStep = EXCLUDED.lastUsedStep, creationTime = EXCLUDED.creationTime`
+	_, err := tx.tx.Exec(ctx, query, secret.UserId, secret.Secret, secret.Confirmed, secret.LastUsedStep, secret.CreationTime)
+	return err
+}
+
+// FindTotpSecretByUserId locks the found row until the end of the transaction,
+// so the same code can't be accepted twice by concurrent requests.
+func (tx *Tx) FindTotpSecretByUserId(ctx context.Context, userId uuid.UUID) (*TotpSecret, error) {
+	query := "SELECT userId, secret, confirmed, lastUsedStep, creationTime FROM TotpSecrets WHERE userId = $1 FOR UPDATE"
+	var secret TotpSecret
+	err := tx.tx.QueryRow(ctx, query, userId).Scan(&secret.UserId, &secret.Secret, &secret.Confirmed, &secret.LastUsedStep, &secret.CreationTime)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchTotpSecret
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &secret, nil
+}
+
+func (tx *Tx) ConfirmTotpSecret(ctx context.Context, userId uuid.UUID) error {
+	query := "UPDATE TotpSecrets SET confirmed = TRUE WHERE userId = $1"
+	_, err := tx.tx.Exec(ctx, query, userId)
+	return err
+}
+
+func (tx *Tx) UpdateTotpLastUsedStep(ctx context.Context, userId uuid.UUID, step int64) error {
+	query := "UPDATE TotpSecrets SET lastUsedStep = $2 WHERE userId = $1"
+	_, err := tx.tx.Exec(ctx, query, userId, step)
+	return err
+}
+
+func (tx *Tx) DeleteTotpSecret(ctx context.Context, userId uuid.UUID) error {
+	query := "DELETE FROM TotpSecrets WHERE userId = $1"
+	_, err := tx.tx.Exec(ctx, query, userId)
+	return err
+}
+
+func (tx *Tx) InsertRecoveryCodes(ctx context.Context, userId uuid.UUID, codeHashes [][]byte) error {
+	query := "INSERT INTO RecoveryCodes (codeHash, userId) SELECT unnest($2::BYTEA[]), $1"
+	_, err := tx.tx.Exec(ctx, query, userId, codeHashes)
+	return err
+}
+
+// UseRecoveryCode marks code as used and reports whether it was valid and unused.
+func (tx *Tx) UseRecoveryCode(ctx context.Context, userId uuid.UUID, codeHash []byte) (bool, error) {
+	query := "UPDATE RecoveryCodes SET used = TRUE WHERE userId = $1 AND codeHash = $2 AND NOT used"
+	tag, err := tx.tx.Exec(ctx, query, userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (tx *Tx) CountUnusedRecoveryCodes(ctx context.Context, userId uuid.UUID) (int, error) {
+	query := "SELECT COUNT(*) FROM RecoveryCodes WHERE userId = $1 AND NOT used"
+	var count int
+	err := tx.tx.QueryRow(ctx, query, userId).Scan(&count)
+	return count, err
+}
+
+func (tx *Tx) DeleteUserRecoveryCodes(ctx context.Context, userId uuid.UUID) error {
+	query := "DELETE FROM RecoveryCodes WHERE userId = $1"
+	_, err := tx.tx.Exec(ctx, query, userId)
+	return err
+}
@@ -3,40 +3,58 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
-var ErrNoSuchUser = errors.New("no user were found")
+var (
+	ErrNoSuchUser = errors.New("no user were found")
+	ErrLoginTaken = errors.New("login is already used")
+	ErrEmailTaken = errors.New("email is already used")
+)
 
 type User struct {
 	UserId         uuid.UUID
 	Login          string
 	Email          string
 	HashedPassword []byte
+	PasswordScheme string
+	EmailVerified  bool
+	// Suspension is in effect since SuspendedTime until SuspendedUntil, nil SuspendedUntil means indefinitely
+	SuspendedTime    *time.Time
+	SuspendedUntil   *time.Time
+	SuspensionReason string
+	// Deleted users are invisible for lookups until they are purged
+	DeletedTime *time.Time
 }
 
-func usersTableSchema() string {
-	return `
-CREATE TABLE IF NOT EXISTS Users (
-	userId UUID PRIMARY KEY,
-	login VARCHAR(100) NOT NULL,
-	email VARCHAR(255) NOT NULL,
-	hashedPassword BYTEA NOT NULL
-);`
+func (u *User) IsSuspended(now time.Time) bool {
+	return u.SuspendedTime != nil && (u.SuspendedUntil == nil || now.Before(*u.SuspendedUntil))
 }
 
+const userColumns = "userId, login, email, hashedPassword, passwordScheme, emailVerified, suspendedTime, suspendedUntil, suspensionReason, deletedTime"
+
+// InsertUser returns ErrLoginTaken or ErrEmailTaken if another not deleted user has the same
+// login or email, compared case insensitively.
 func (tx *Tx) InsertUser(ctx context.Context, user User) error {
-	query := "INSERT INTO Users (userId, login, email, hashedPassword) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
-	_, err := tx.tx.Exec(ctx, query, user.UserId, user.Login, user.Email, user.HashedPassword)
+	query := "INSERT INTO Users (userId, login, email, hashedPassword, passwordScheme, emailVerified) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := tx.tx.Exec(ctx, query, user.UserId, user.Login, user.Email, user.HashedPassword, user.PasswordScheme, user.EmailVerified)
+	switch uniqueViolation(err) {
+	case "usersloginuniqueidx":
+		return ErrLoginTaken
+	case "usersemailuniqueidx":
+		return ErrEmailTaken
+	}
 	return err
 }
 
 func getUserFromRow(row pgx.Row) (*User, error) {
 	var user User
-	err := row.Scan(&user.UserId, &user.Login, &user.Email, &user.HashedPassword)
+	err := row.Scan(&user.UserId, &user.Login, &user.Email, &user.HashedPassword, &user.PasswordScheme, &user.EmailVerified, &user.SuspendedTime, &user.SuspendedUntil, &user.SuspensionReason, &user.DeletedTime)
 	if err == pgx.ErrNoRows {
 		return nil, ErrNoSuchUser
 	}
@@ -48,20 +66,209 @@ func getUserFromRow(row pgx.Row) (*User, error) {
 }
 
 func (tx *Tx) FindUserByLogin(ctx context.Context, login string) (*User, error) {
-	query := "SELECT userId, login, email, hashedPassword FROM Users WHERE login = $1"
+	query := "SELECT " + userColumns + " FROM Users WHERE lower(login) = lower($1) AND deletedTime IS NULL"
 	return getUserFromRow(tx.tx.QueryRow(ctx, query, login))
 }
 
 func (tx *Tx) FindUserByEmail(ctx context.Context, email string) (*User, error) {
-	query := "SELECT userId, login, email, hashedPassword FROM Users WHERE email = $1"
+	query := "SELECT " + userColumns + " FROM Users WHERE lower(email) = lower($1) AND deletedTime IS NULL"
 	return getUserFromRow(tx.tx.QueryRow(ctx, query, email))
 }
 
 func (tx *Tx) FindUserById(ctx context.Context, userId uuid.UUID) (*User, error) {
-	query := "SELECT userId, login, email, hashedPassword FROM Users WHERE userId = $1"
+	query := "SELECT " + userColumns + " FROM Users WHERE userId = $1 AND deletedTime IS NULL"
 	return getUserFromRow(tx.tx.QueryRow(ctx, query, userId))
 }
 
+// FindUsersByIds returns not deleted users among userIds, in no particular order. Missing ids are skipped.
+func (tx *Tx) FindUsersByIds(ctx context.Context, userIds []uuid.UUID) ([]User, error) {
+	query := "SELECT " + userColumns + " FROM Users WHERE userId = ANY($1) AND deletedTime IS NULL"
+	rows, err := tx.tx.Query(ctx, query, userIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := getUserFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
+}
+
+func (tx *Tx) UpdateUserPassword(ctx context.Context, userId uuid.UUID, hashedPassword []byte, passwordScheme string) error {
+	query := "UPDATE Users SET hashedPassword = $1, passwordScheme = $2 WHERE userId = $3"
+	_, err := tx.tx.Exec(ctx, query, hashedPassword, passwordScheme, userId)
+	return err
+}
+
+// SetUserEmailVerified marks email verified only if user still has the same email.
+// Returns ErrNoSuchUser otherwise.
+func (tx *Tx) SetUserEmailVerified(ctx context.Context, userId uuid.UUID, email string) error {
+	query := "UPDATE Users SET emailVerified = TRUE WHERE userId = $1 AND email = $2 AND deletedTime IS NULL"
+	tag, err := tx.tx.Exec(ctx, query, userId, email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoSuchUser
+	}
+	return nil
+}
+
+// SuspendUser stores suspension of the user. Returns ErrNoSuchUser if there is no such user.
+func (tx *Tx) SuspendUser(ctx context.Context, userId uuid.UUID, suspendedTime time.Time, suspendedUntil *time.Time, reason string) error {
+	query := "UPDATE Users SET suspendedTime = $2, suspendedUntil = $3, suspensionReason = $4 WHERE userId = $1 AND deletedTime IS NULL"
+	tag, err := tx.tx.Exec(ctx, query, userId, suspendedTime, suspendedUntil, reason)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoSuchUser
+	}
+	return nil
+}
+
+// ReinstateUser lifts suspension of the user. Returns ErrNoSuchUser if there is no such user.
+func (tx *Tx) ReinstateUser(ctx context.Context, userId uuid.UUID) error {
+	query := "UPDATE Users SET suspendedTime = NULL, suspendedUntil = NULL, suspensionReason = '' WHERE userId = $1 AND deletedTime IS NULL"
+	tag, err := tx.tx.Exec(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoSuchUser
+	}
+	return nil
+}
+
+// SoftDeleteUser hides the user from lookups. Returns ErrNoSuchUser if there is no such user.
+func (tx *Tx) SoftDeleteUser(ctx context.Context, userId uuid.UUID, deletedTime time.Time) error {
+	query := "UPDATE Users SET deletedTime = $2 WHERE userId = $1 AND deletedTime IS NULL"
+	tag, err := tx.tx.Exec(ctx, query, userId, deletedTime)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoSuchUser
+	}
+	return nil
+}
+
+// ListDeletedUsers returns users deleted before the given time. Returned users are locked
+// and skipped by concurrent callers, so several purging instances don't interfere.
+func (tx *Tx) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]uuid.UUID, error) {
+	query := "SELECT userId FROM Users WHERE deletedTime < $1 ORDER BY deletedTime LIMIT $2 FOR UPDATE SKIP LOCKED"
+	rows, err := tx.tx.Query(ctx, query, deletedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIds []uuid.UUID
+	for rows.Next() {
+		var userId uuid.UUID
+		err = rows.Scan(&userId)
+		if err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+
+	return userIds, rows.Err()
+}
+
+// PurgeUser removes every row related to the user.
+func (tx *Tx) PurgeUser(ctx context.Context, userId uuid.UUID) error {
+	queries := []string{
+		"DELETE FROM Profiles WHERE userId = $1",
+		"DELETE FROM ProfileChanges WHERE userId = $1",
+		"DELETE FROM RefreshTokens WHERE userId = $1",
+		"DELETE FROM Sessions WHERE userId = $1",
+		"DELETE FROM PersonalAccessTokens WHERE userId = $1",
+		"DELETE FROM PasswordResetTokens WHERE userId = $1",
+		"DELETE FROM EmailVerifications WHERE userId = $1",
+		"DELETE FROM TotpSecrets WHERE userId = $1",
+		"DELETE FROM RecoveryCodes WHERE userId = $1",
+		"DELETE FROM UserRoles WHERE userId = $1",
+		"DELETE FROM AuthFailures WHERE key = 'user:' || $1::TEXT",
+		"DELETE FROM Users WHERE userId = $1",
+	}
+	for _, query := range queries {
+		_, err := tx.tx.Exec(ctx, query, userId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type UserFilter struct {
+	// Case insensitive prefix of login or email
+	Query string
+	// Only users having this role
+	Role string
+	// Only users suspended at the moment
+	SuspendedOnly bool
+}
+
+// UserCursor points to the last user of the previous page. Users are ordered by login and id.
+type UserCursor struct {
+	Login  string
+	UserId uuid.UUID
+}
+
+func (tx *Tx) ListUsers(ctx context.Context, filter UserFilter, after *UserCursor, limit int, now time.Time) ([]User, error) {
+	query := "SELECT " + userColumns + " FROM Users WHERE deletedTime IS NULL"
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Query != "" {
+		pattern := arg(escapeLike(filter.Query) + "%")
+		query += " AND (lower(login) LIKE lower(" + pattern + ") OR lower(email) LIKE lower(" + pattern + "))"
+	}
+	if filter.Role != "" {
+		query += " AND EXISTS (SELECT 1 FROM UserRoles JOIN Roles ON Roles.roleId = UserRoles.roleId WHERE UserRoles.userId = Users.userId AND Roles.roleName = " + arg(filter.Role) + ")"
+	}
+	if filter.SuspendedOnly {
+		nowArg := arg(now)
+		query += " AND suspendedTime IS NOT NULL AND (suspendedUntil IS NULL OR suspendedUntil > " + nowArg + ")"
+	}
+	if after != nil {
+		query += " AND (login, userId) > (" + arg(after.Login) + ", " + arg(after.UserId) + ")"
+	}
+	query += " ORDER BY login, userId LIMIT " + arg(limit)
+
+	rows, err := tx.tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := getUserFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 type Profile struct {
 	UserId         uuid.UUID
 	Name           string
@@ -70,19 +277,38 @@ type Profile struct {
 	BirthDay       *time.Time
 	CreationTime   *time.Time
 	LastUpdateTime *time.Time
+	// Empty if user has no avatar. Changed only by SetProfileAvatar
+	AvatarId          string
+	AvatarContentType string
+	// New profiles get DefaultProfileVisibility
+	Visibility ProfileVisibility
+}
+
+// Visibility tells who may see a profile field besides its owner.
+type Visibility string
+
+const (
+	VisibilityPublic    Visibility = "public"
+	VisibilityFollowers Visibility = "followers"
+	VisibilityOnlyMe    Visibility = "only_me"
+)
+
+type ProfileVisibility struct {
+	Name        Visibility
+	Surname     Visibility
+	PhoneNumber Visibility
+	BirthDay    Visibility
+	Avatar      Visibility
 }
 
-func profilesTableSchema() string {
-	return `
-CREATE TABLE IF NOT EXISTS Profiles (
-	userId UUID PRIMARY KEY,
-	name VARCHAR(100),
-	surname VARCHAR(100),
-	phoneNumber VARCHAR(20),
-	birthDay DATE,
-	creationTime TIMESTAMP WITHOUT TIME ZONE,
-	lastUpdateTime TIMESTAMP WITHOUT TIME ZONE
-);`
+// DefaultProfileVisibility keeps contacts and age private until the owner decides otherwise.
+// It must match column defaults in the migrations.
+var DefaultProfileVisibility = ProfileVisibility{
+	Name:        VisibilityPublic,
+	Surname:     VisibilityPublic,
+	PhoneNumber: VisibilityOnlyMe,
+	BirthDay:    VisibilityOnlyMe,
+	Avatar:      VisibilityPublic,
 }
 
 func (tx *Tx) InsertProfile(ctx context.Context, profile Profile) error {
@@ -91,9 +317,18 @@ func (tx *Tx) InsertProfile(ctx context.Context, profile Profile) error {
 	return err
 }
 
+// profileColumns are the columns following userId, that getProfileFromRow expects.
+const profileColumns = `name, surname, phoneNumber, birthDay, creationTime, lastUpdateTime, avatarId, avatarContentType,
+nameVisibility, surnameVisibility, phoneNumberVisibility, birthDayVisibility, avatarVisibility`
+
 func getProfileFromRow(row pgx.Row) (*Profile, error) {
 	var profile Profile
-	err := row.Scan(&profile.UserId, &profile.Name, &profile.Surname, &profile.PhoneNumber, &profile.BirthDay, &profile.CreationTime, &profile.LastUpdateTime)
+	err := row.Scan(&profile.UserId, &profile.Name, &profile.Surname, &profile.PhoneNumber, &profile.BirthDay, &profile.CreationTime, &profile.LastUpdateTime,
+		&profile.AvatarId, &profile.AvatarContentType, &profile.Visibility.Name, &profile.Visibility.Surname, &profile.Visibility.PhoneNumber,
+		&profile.Visibility.BirthDay, &profile.Visibility.Avatar)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchUser
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -101,12 +336,65 @@ func getProfileFromRow(row pgx.Row) (*Profile, error) {
 }
 
 func (tx *Tx) FindProfileByUserId(ctx context.Context, userId uuid.UUID) (*Profile, error) {
-	query := "SELECT * FROM Profiles WHERE userId = $1"
+	query := `SELECT Profiles.userId, ` + profileColumns + ` FROM Profiles
+JOIN Users ON Users.userId = Profiles.userId
+WHERE Profiles.userId = $1 AND Users.deletedTime IS NULL`
+	return getProfileFromRow(tx.tx.QueryRow(ctx, query, userId))
+}
+
+// FindProfilesByUserIds returns profiles of not deleted users among userIds, in no particular order.
+// Missing ids are skipped.
+func (tx *Tx) FindProfilesByUserIds(ctx context.Context, userIds []uuid.UUID) ([]Profile, error) {
+	query := `SELECT Profiles.userId, ` + profileColumns + ` FROM Profiles
+JOIN Users ON Users.userId = Profiles.userId
+WHERE Profiles.userId = ANY($1) AND Users.deletedTime IS NULL`
+	rows, err := tx.tx.Query(ctx, query, userIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []Profile
+	for rows.Next() {
+		profile, err := getProfileFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *profile)
+	}
+
+	return profiles, rows.Err()
+}
+
+// FindProfileByUserIdForUpdate is FindProfileByUserId that also locks the profile until the end of tx,
+// so the profile may be changed based on what was read.
+func (tx *Tx) FindProfileByUserIdForUpdate(ctx context.Context, userId uuid.UUID) (*Profile, error) {
+	query := `SELECT Profiles.userId, ` + profileColumns + ` FROM Profiles
+JOIN Users ON Users.userId = Profiles.userId
+WHERE Profiles.userId = $1 AND Users.deletedTime IS NULL
+FOR UPDATE OF Profiles`
 	return getProfileFromRow(tx.tx.QueryRow(ctx, query, userId))
 }
 
 func (tx *Tx) UpdateProfile(ctx context.Context, profile Profile) error {
-	query := "UPDATE Profiles SET name = $1, surname = $2, phoneNumber = $3, BirthDay = $4, creationTime = $5, lastUpdateTime = $6 WHERE userId = $7"
-	_, err := tx.tx.Exec(ctx, query, profile.Name, profile.Surname, profile.PhoneNumber, profile.BirthDay, profile.CreationTime, profile.LastUpdateTime, profile.UserId)
+	query := `UPDATE Profiles SET name = $1, surname = $2, phoneNumber = $3, BirthDay = $4, creationTime = $5, lastUpdateTime = $6,
+	nameVisibility = $8, surnameVisibility = $9, phoneNumberVisibility = $10, birthDayVisibility = $11, avatarVisibility = $12
+WHERE userId = $7`
+	_, err := tx.tx.Exec(ctx, query, profile.Name, profile.Surname, profile.PhoneNumber, profile.BirthDay, profile.CreationTime, profile.LastUpdateTime, profile.UserId,
+		profile.Visibility.Name, profile.Visibility.Surname, profile.Visibility.PhoneNumber, profile.Visibility.BirthDay, profile.Visibility.Avatar)
 	return err
 }
+
+// SetProfileAvatar replaces avatar of the user, empty avatarId removes it.
+// Returns ErrNoSuchUser if there is no such profile.
+func (tx *Tx) SetProfileAvatar(ctx context.Context, userId uuid.UUID, avatarId string, contentType string, updateTime time.Time) error {
+	query := "UPDATE Profiles SET avatarId = $2, avatarContentType = $3, lastUpdateTime = $4 WHERE userId = $1"
+	tag, err := tx.tx.Exec(ctx, query, userId, avatarId, contentType, updateTime)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoSuchUser
+	}
+	return nil
+}
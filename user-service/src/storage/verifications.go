@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNoSuchEmailVerification = errors.New("no email verification were found")
+
+// EmailVerification tracks sent verification messages to rate limit resending.
+type EmailVerification struct {
+	UserId          uuid.UUID
+	LastSentTime    *time.Time
+	WindowStartTime *time.Time
+	SentInWindow    int
+}
+
+func (tx *Tx) FindEmailVerificationByUserId(ctx context.Context, userId uuid.UUID) (*EmailVerification, error) {
+	query := "SELECT userId, lastSentTime, windowStartTime, sentInWindow FROM EmailVerifications WHERE userId = $1 FOR UPDATE"
+	var verification EmailVerification
+	err := tx.tx.QueryRow(ctx, query, userId).Scan(&verification.UserId, &verification.LastSentTime, &verification.WindowStartTime, &verification.SentInWindow)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoSuchEmailVerification
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &verification, nil
+}
+
+func (tx *Tx) UpsertEmailVerification(ctx context.Context, verification EmailVerification) error {
+	query := `INSERT INTO EmailVerifications (userId, lastSentTime, windowStartTime, sentInWindow) VALUES ($1, $2, $3, $4)
+ON CONFLICT (userId) DO UPDATE SET lastSentTime = EXCLUDED.lastSentTime, windowStartTime = EXCLUDED.windowStartTime, sentInWindow = EXCLUDED.sentInWindow`
+	_, err := tx.tx.Exec(ctx, query, verification.UserId, verification.LastSentTime, verification.WindowStartTime, verification.SentInWindow)
+	return err
+}
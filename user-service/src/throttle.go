@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"soa-project/user-service/storage"
+)
+
+const (
+	// Failures older than this are forgotten
+	authFailureWindow = time.Hour
+	authBackoffBase   = time.Second
+	authBackoffMax    = time.Minute * 5
+)
+
+type authFailurePolicy struct {
+	// Number of failures allowed without delay
+	backoffAfter int
+	// Number of failures that locks the source
+	lockoutAfter    int
+	lockoutDuration time.Duration
+}
+
+var (
+	accountFailurePolicy = authFailurePolicy{backoffAfter: 3, lockoutAfter: 10, lockoutDuration: time.Minute * 15}
+	// Many users may share an address, so it is treated more loosely than an account
+	ipFailurePolicy = authFailurePolicy{backoffAfter: 10, lockoutAfter: 100, lockoutDuration: time.Hour}
+)
+
+func accountFailureKey(userId uuid.UUID) string {
+	return "user:" + userId.String()
+}
+
+func ipFailureKey(ip string) string {
+	return "ip:" + ip
+}
+
+// wait returns codes.OK if source may try to authenticate now. Otherwise it returns
+// codes.ResourceExhausted for back-off or codes.PermissionDenied for lockout along
+// with delay after which next attempt is allowed.
+func (p authFailurePolicy) wait(failure *storage.AuthFailure, now time.Time) (codes.Code, time.Duration) {
+	if failure == nil {
+		return codes.OK, 0
+	}
+
+	if failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		return codes.PermissionDenied, failure.LockedUntil.Sub(now)
+	}
+
+	if now.Sub(*failure.LastFailureTime) >= authFailureWindow || failure.Failures < p.backoffAfter {
+		return codes.OK, 0
+	}
+
+	delay := authBackoffMax
+	if shift := failure.Failures - p.backoffAfter; shift < 16 {
+		delay = min(authBackoffBase<<shift, authBackoffMax)
+	}
+	if next := failure.LastFailureTime.Add(delay); now.Before(next) {
+		return codes.ResourceExhausted, next.Sub(now)
+	}
+
+	return codes.OK, 0
+}
+
+// record accounts one more failure. Reaching lockout threshold locks the source
+// and starts counting from scratch once the lock is over.
+func (p authFailurePolicy) record(prev *storage.AuthFailure, key string, now time.Time) storage.AuthFailure {
+	next := storage.AuthFailure{Key: key, Failures: 1, LastFailureTime: &now}
+	if prev != nil && now.Sub(*prev.LastFailureTime) < authFailureWindow {
+		next.Failures = prev.Failures + 1
+	}
+
+	if next.Failures >= p.lockoutAfter {
+		lockedUntil := now.Add(p.lockoutDuration)
+		next.LockedUntil = &lockedUntil
+		next.Failures = 0
+	}
+
+	return next
+}
+
+// authThrottle holds failure counters of the sources participating in the attempt.
+type authThrottle struct {
+	now     time.Time
+	ip      string
+	ipPrev  *storage.AuthFailure
+	userId  uuid.UUID
+	account *storage.AuthFailure
+	hasUser bool
+}
+
+func findAuthFailure(ctx context.Context, tx storage.Transaction, key string) (*storage.AuthFailure, error) {
+	failure, err := tx.FindAuthFailure(ctx, key)
+	if err == storage.ErrNoSuchAuthFailure {
+		return nil, nil
+	}
+	return failure, err
+}
+
+// checkIp loads counter of the client address and returns status error if it is throttled.
+func (t *authThrottle) checkIp(ctx context.Context, tx storage.Transaction) error {
+	if t.ip == "" {
+		return nil
+	}
+
+	var err error
+	t.ipPrev, err = findAuthFailure(ctx, tx, ipFailureKey(t.ip))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to find auth failures: %v", err)
+	}
+
+	if code, delay := ipFailurePolicy.wait(t.ipPrev, t.now); code != codes.OK {
+		return statusWithRetryDelay(code, "too many failed attempts from this address", delay)
+	}
+	return nil
+}
+
+// checkAccount loads counter of the account and returns status error if it is throttled.
+func (t *authThrottle) checkAccount(ctx context.Context, tx storage.Transaction, userId uuid.UUID) error {
+	t.userId = userId
+	t.hasUser = true
+
+	var err error
+	t.account, err = findAuthFailure(ctx, tx, accountFailureKey(userId))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to find auth failures: %v", err)
+	}
+
+	if code, delay := accountFailurePolicy.wait(t.account, t.now); code != codes.OK {
+		return statusWithRetryDelay(code, "too many failed attempts for this account", delay)
+	}
+	return nil
+}
+
+func (t *authThrottle) recordFailure(ctx context.Context, tx storage.Transaction) error {
+	if t.ip != "" {
+		err := tx.UpsertAuthFailure(ctx, ipFailurePolicy.record(t.ipPrev, ipFailureKey(t.ip), t.now))
+		if err != nil {
+			return err
+		}
+	}
+	if t.hasUser {
+		err := tx.UpsertAuthFailure(ctx, accountFailurePolicy.record(t.account, accountFailureKey(t.userId), t.now))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fail records failure, commits tx and returns authErr, so the caller may return it as is.
+func (t *authThrottle) fail(ctx context.Context, tx storage.Transaction, authErr error) error {
+	err := t.recordFailure(ctx, tx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to record auth failure: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to commit tx: %v", err)
+	}
+
+	return authErr
+}
+
+// recordSuccess resets the account counter. Address counter is kept,
+// since single valid password doesn't justify guessing on other accounts.
+func (t *authThrottle) recordSuccess(ctx context.Context, tx storage.Transaction) error {
+	if t.hasUser && t.account != nil {
+		return tx.DeleteAuthFailure(ctx, accountFailureKey(t.userId))
+	}
+	return nil
+}
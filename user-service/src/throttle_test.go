@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"soa-project/user-service/storage"
+)
+
+func TestAuthFailurePolicy(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	policy := authFailurePolicy{backoffAfter: 3, lockoutAfter: 10, lockoutDuration: time.Minute * 15}
+
+	tests := []struct {
+		name          string
+		failure       *storage.AuthFailure
+		expectedCode  codes.Code
+		expectedDelay time.Duration
+	}{
+		{
+			name:         "No failures",
+			failure:      nil,
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "Below threshold",
+			failure:      &storage.AuthFailure{Failures: 2, LastFailureTime: at(0)},
+			expectedCode: codes.OK,
+		},
+		{
+			name:          "Back-off",
+			failure:       &storage.AuthFailure{Failures: 5, LastFailureTime: at(-time.Second)},
+			expectedCode:  codes.ResourceExhausted,
+			expectedDelay: time.Second * 3,
+		},
+		{
+			name:         "Back-off elapsed",
+			failure:      &storage.AuthFailure{Failures: 5, LastFailureTime: at(-time.Second * 4)},
+			expectedCode: codes.OK,
+		},
+		{
+			name:          "Back-off capped",
+			failure:       &storage.AuthFailure{Failures: 40, LastFailureTime: at(-time.Minute)},
+			expectedCode:  codes.ResourceExhausted,
+			expectedDelay: authBackoffMax - time.Minute,
+		},
+		{
+			name:          "Locked",
+			failure:       &storage.AuthFailure{Failures: 0, LastFailureTime: at(-time.Minute), LockedUntil: at(time.Minute * 14)},
+			expectedCode:  codes.PermissionDenied,
+			expectedDelay: time.Minute * 14,
+		},
+		{
+			name:         "Forgotten",
+			failure:      &storage.AuthFailure{Failures: 9, LastFailureTime: at(-authFailureWindow)},
+			expectedCode: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, delay := policy.wait(tt.failure, now)
+			if code != tt.expectedCode || delay != tt.expectedDelay {
+				t.Errorf("wait returned %v with delay %v, where %v with delay %v expected", code, delay, tt.expectedCode, tt.expectedDelay)
+			}
+		})
+	}
+
+	failure := policy.record(nil, "user:test", now)
+	for i := 1; i < policy.lockoutAfter; i++ {
+		failure = policy.record(&failure, "user:test", now)
+	}
+	if code, _ := policy.wait(&failure, now); code != codes.PermissionDenied {
+		t.Errorf("source is not locked after %v failures", policy.lockoutAfter)
+	}
+}
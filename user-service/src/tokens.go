@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+const (
+	accessTokenLifetime  = time.Minute * 10
+	refreshTokenLifetime = time.Hour * 24 * 30
+
+	passwordResetTokenLifetime = time.Hour
+	verificationCodeLifetime   = time.Hour * 24
+)
+
+type Claims struct {
+	UserId        string `json:"user_id"`
+	EmailVerified bool   `json:"email_verified"`
+	// Names of the roles user had at the moment of issuing
+	Roles []string `json:"roles,omitempty"`
+	// Session the token belongs to, equals to the family of refresh tokens
+	SessionId string `json:"sid,omitempty"`
+	// Set only for action tokens, access token must never have it
+	Purpose string `json:"purpose,omitempty"`
+	jwt.RegisteredClaims
+}
+
+const (
+	purposeEmailVerification = "email_verification"
+)
+
+// ActionClaims are carried by short-lived tokens which allow exactly one kind of action, e.g. email verification.
+type ActionClaims struct {
+	UserId  string `json:"user_id"`
+	Purpose string `json:"purpose"`
+	Email   string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (m *JwtManager) issueAccessToken(user *storage.User, roles []string, sessionId uuid.UUID) (string, error) {
+	tokenId, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate jti: %w", err)
+	}
+
+	issuedTime := time.Now()
+	expirationTime := issuedTime.Add(accessTokenLifetime)
+
+	claims := Claims{
+		UserId:        user.UserId.String(),
+		EmailVerified: user.EmailVerified,
+		Roles:         roles,
+		SessionId:     sessionId.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId.String(),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(issuedTime),
+		},
+	}
+
+	return m.sign(claims)
+}
+
+func (m *JwtManager) parseAccessToken(accessToken string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, m.verificationKey, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token or claims")
+	}
+	if claims.ID == "" {
+		return nil, errors.New("token has no jti")
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("action token can't be used as access token")
+	}
+
+	return claims, nil
+}
+
+func (m *JwtManager) issueActionToken(purpose string, userId uuid.UUID, email string, lifetime time.Duration) (string, error) {
+	issuedTime := time.Now()
+	claims := ActionClaims{
+		UserId:  userId.String(),
+		Purpose: purpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(issuedTime.Add(lifetime)),
+			IssuedAt:  jwt.NewNumericDate(issuedTime),
+		},
+	}
+
+	return m.sign(claims)
+}
+
+func (m *JwtManager) parseActionToken(actionToken string, purpose string) (*ActionClaims, error) {
+	token, err := jwt.ParseWithClaims(actionToken, &ActionClaims{}, m.verificationKey, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*ActionClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token or claims")
+	}
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("token is not intended for %v", purpose)
+	}
+
+	return claims, nil
+}
+
+func generateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashOpaqueToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// issueTokens signs a new access token and stores a fresh refresh token of the given family.
+// Only the hash of the refresh token is persisted, the token itself is returned to the caller.
+func (s UserService) issueTokens(ctx context.Context, tx storage.Transaction, user *storage.User, familyId uuid.UUID) (string, string, error) {
+	roles, err := tx.ListUserRoles(ctx, user.UserId)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list roles: %w", err)
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.RoleName)
+	}
+
+	accessToken, err := s.jwtManager.issueAccessToken(user, roleNames, familyId)
+	if err != nil {
+		return "", "", fmt.Errorf("jwt signing error: %w", err)
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	creationTime := time.Now()
+	expirationTime := creationTime.Add(refreshTokenLifetime)
+	err = tx.InsertRefreshToken(ctx, storage.RefreshToken{
+		TokenHash:      hashOpaqueToken(refreshToken),
+		FamilyId:       familyId,
+		UserId:         user.UserId,
+		CreationTime:   &creationTime,
+		ExpirationTime: &expirationTime,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// revokeUserSessions makes all refresh tokens of the user unusable and revokes access tokens
+// issued up to this moment.
+func revokeUserSessions(ctx context.Context, tx storage.Transaction, userId uuid.UUID) error {
+	err := tx.RevokeUserRefreshTokens(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	err = tx.RevokeUserSessions(ctx, userId, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke session records: %w", err)
+	}
+
+	return revokeUserAccessTokens(ctx, tx, userId)
+}
+
+// revokeUserAccessTokens revokes access tokens issued up to this moment, but keeps refresh tokens,
+// so the user may obtain new access token with up to date claims.
+func revokeUserAccessTokens(ctx context.Context, tx storage.Transaction, userId uuid.UUID) error {
+	now := time.Now()
+	expirationTime := now.Add(accessTokenLifetime)
+	err := tx.InsertRevocation(ctx, storage.Revocation{
+		Kind:           storage.RevocationKindUser,
+		Subject:        userId.String(),
+		RevocationTime: &now,
+		ExpirationTime: &expirationTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert revocation: %w", err)
+	}
+
+	return nil
+}
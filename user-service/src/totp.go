@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"soa-project/user-service/storage"
+)
+
+// TOTP parameters (RFC 6238). These are defaults understood by every authenticator app.
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+	// Number of steps accepted before and after current one to tolerate clock drift
+	totpSkew   = 1
+	totpIssuer = "soa-project"
+
+	recoveryCodesCount            = 10
+	secondFactorChallengeLifetime = time.Minute * 5
+)
+
+const (
+	purposeSecondFactor = "second_factor"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTotpSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode computes HOTP (RFC 4226) value of the given step with HMAC-SHA1.
+func totpCode(secret []byte, step int64, digits int) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// verifyTotp returns the step matched by code. Steps up to lastUsedStep are rejected,
+// so every code may be used only once.
+func verifyTotp(secret []byte, code string, now time.Time, lastUsedStep int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step, totpDigits)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpUri builds key URI, that is usually shown to the user as QR code.
+func totpUri(secret []byte, account string) string {
+	label := url.PathEscape(totpIssuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", totpEncoding.EncodeToString(secret))
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// generateRecoveryCodes returns codes in form "xxxxx-xxxxx". Codes have enough entropy
+// to be stored as plain sha256 hashes, the same way as opaque tokens are.
+func generateRecoveryCodes() ([]string, error) {
+	recoveryCodes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		buf := make([]byte, 7)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		recoveryCodes = append(recoveryCodes, code[:5]+"-"+code[5:])
+	}
+	return recoveryCodes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.Fields(code), ""))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+// replaceRecoveryCodes invalidates previous recovery codes of the user and stores new ones.
+func replaceRecoveryCodes(ctx context.Context, tx storage.Transaction, userId uuid.UUID) ([]string, error) {
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	err = tx.DeleteUserRecoveryCodes(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	hashes := make([][]byte, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, hashOpaqueToken(code))
+	}
+	err = tx.InsertRecoveryCodes(ctx, userId, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert recovery codes: %w", err)
+	}
+
+	return recoveryCodes, nil
+}
+
+// checkSecondFactor accepts either current TOTP code or unused recovery code.
+// Accepted code is spent within tx.
+func checkSecondFactor(ctx context.Context, tx storage.Transaction, secret *storage.TotpSecret, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		step, ok := verifyTotp(secret.Secret, code, now, secret.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+		err := tx.UpdateTotpLastUsedStep(ctx, secret.UserId, step)
+		if err != nil {
+			return false, fmt.Errorf("failed to update totp step: %w", err)
+		}
+		return true, nil
+	}
+
+	used, err := tx.UseRecoveryCode(ctx, secret.UserId, hashOpaqueToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return used, nil
+}
+
+func hasSecondFactor(ctx context.Context, tx storage.Transaction, userId uuid.UUID) (bool, error) {
+	secret, err := tx.FindTotpSecretByUserId(ctx, userId)
+	if err == storage.ErrNoSuchTotpSecret {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.Confirmed, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotpCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B (SHA1 mode)
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		name     string
+		time     int64
+		expected string
+	}{
+		{name: "59", time: 59, expected: "94287082"},
+		{name: "1111111109", time: 1111111109, expected: "07081804"},
+		{name: "1111111111", time: 1111111111, expected: "14050471"},
+		{name: "1234567890", time: 1234567890, expected: "89005924"},
+		{name: "2000000000", time: 2000000000, expected: "69279037"},
+		{name: "20000000000", time: 20000000000, expected: "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := totpCode(secret, totpStep(time.Unix(tt.time, 0)), 8)
+			if code != tt.expected {
+				t.Errorf("totpCode returned %v, where %v expected", code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerifyTotp(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name         string
+		code         string
+		lastUsedStep int64
+		expectedOk   bool
+	}{
+		{name: "Current", code: totpCode(secret, current, totpDigits), expectedOk: true},
+		{name: "Previous", code: totpCode(secret, current-1, totpDigits), expectedOk: true},
+		{name: "Next", code: totpCode(secret, current+1, totpDigits), expectedOk: true},
+		{name: "Too old", code: totpCode(secret, current-2, totpDigits), expectedOk: false},
+		{name: "Replay", code: totpCode(secret, current, totpDigits), lastUsedStep: current, expectedOk: false},
+		{name: "Wrong length", code: "12345", expectedOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := verifyTotp(secret, tt.code, now, tt.lastUsedStep)
+			if ok != tt.expectedOk {
+				t.Errorf("verifyTotp returned %v, where %v expected", ok, tt.expectedOk)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != recoveryCodesCount {
+		t.Fatalf("generated %v codes, where %v expected", len(recoveryCodes), recoveryCodesCount)
+	}
+
+	code := recoveryCodes[0]
+	if normalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))) != code {
+		t.Errorf("code %v is not recognized after reformatting", code)
+	}
+}
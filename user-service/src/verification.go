@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"soa-project/user-service/storage"
+)
+
+const (
+	verificationResendInterval = time.Minute
+	verificationWindow         = time.Hour * 24
+	verificationMaxInWindow    = 5
+)
+
+// nextEmailVerification accounts one more verification message. If limits are exceeded,
+// it returns positive delay after which message may be sent.
+func nextEmailVerification(prev *storage.EmailVerification, user *storage.User, now time.Time) (storage.EmailVerification, time.Duration) {
+	if prev == nil {
+		return storage.EmailVerification{UserId: user.UserId, LastSentTime: &now, WindowStartTime: &now, SentInWindow: 1}, 0
+	}
+
+	next := *prev
+	if elapsed := now.Sub(*prev.LastSentTime); elapsed < verificationResendInterval {
+		return next, verificationResendInterval - elapsed
+	}
+
+	if elapsed := now.Sub(*prev.WindowStartTime); elapsed < verificationWindow {
+		if prev.SentInWindow >= verificationMaxInWindow {
+			return next, verificationWindow - elapsed
+		}
+		next.SentInWindow++
+	} else {
+		next.WindowStartTime = &now
+		next.SentInWindow = 1
+	}
+	next.LastSentTime = &now
+
+	return next, 0
+}
+
+func (s UserService) sendVerificationEmail(ctx context.Context, user *storage.User) error {
+	code, err := s.jwtManager.issueActionToken(purposeEmailVerification, user.UserId, user.Email, verificationCodeLifetime)
+	if err != nil {
+		return fmt.Errorf("failed to issue verification code: %w", err)
+	}
+
+	body := fmt.Sprintf("Hello, %s!\n\nTo confirm your email address use the following code within %v:\n\n%s\n", user.Login, verificationCodeLifetime, code)
+	if s.emailVerificationUrl != "" {
+		body += fmt.Sprintf("\nOr follow the link: %s%s\n", s.emailVerificationUrl, code)
+	}
+
+	return s.mailer.Send(ctx, Message{To: user.Email, Subject: "Email verification", Body: body})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	shared "soa-project/shared/proto"
+	"soa-project/user-service/storage"
+)
+
+// FollowerChecker tells whether one user follows another. Follows aren't kept by this service,
+// so until there is a source of them the service uses noFollowers.
+type FollowerChecker interface {
+	IsFollower(ctx context.Context, userId uuid.UUID, followerId uuid.UUID) (bool, error)
+}
+
+// noFollowers is FollowerChecker for the world where nobody follows anybody,
+// i.e. fields visible to followers are visible to the owner only.
+type noFollowers struct{}
+
+func (noFollowers) IsFollower(ctx context.Context, userId uuid.UUID, followerId uuid.UUID) (bool, error) {
+	return false, nil
+}
+
+var visibilitiesToPb = map[storage.Visibility]shared.Visibility{
+	storage.VisibilityPublic:    shared.Visibility_VISIBILITY_PUBLIC,
+	storage.VisibilityFollowers: shared.Visibility_VISIBILITY_FOLLOWERS,
+	storage.VisibilityOnlyMe:    shared.Visibility_VISIBILITY_ONLY_ME,
+}
+
+// visibilityFromPb returns def for unspecified visibility.
+func visibilityFromPb(visibility shared.Visibility, def storage.Visibility) (storage.Visibility, error) {
+	if visibility == shared.Visibility_VISIBILITY_UNSPECIFIED {
+		return def, nil
+	}
+	for result, pbVisibility := range visibilitiesToPb {
+		if pbVisibility == visibility {
+			return result, nil
+		}
+	}
+	return "", fmt.Errorf("unknown visibility %v", visibility)
+}
+
+// visibilityField returns visibility of the field named as in user.ProfileVisibility.
+func visibilityField(visibility *storage.ProfileVisibility, field string) *storage.Visibility {
+	switch field {
+	case "name":
+		return &visibility.Name
+	case "surname":
+		return &visibility.Surname
+	case "phone_number":
+		return &visibility.PhoneNumber
+	case "birthday":
+		return &visibility.BirthDay
+	case "avatars":
+		return &visibility.Avatar
+	}
+	panic(fmt.Sprintf("unknown profile field %q", field))
+}
+
+func visibilityFieldPb(visibility *shared.ProfileVisibility, field string) shared.Visibility {
+	switch field {
+	case "name":
+		return visibility.GetName()
+	case "surname":
+		return visibility.GetSurname()
+	case "phone_number":
+		return visibility.GetPhoneNumber()
+	case "birthday":
+		return visibility.GetBirthday()
+	case "avatars":
+		return visibility.GetAvatars()
+	}
+	panic(fmt.Sprintf("unknown profile field %q", field))
+}
+
+func profileVisibilityToPb(visibility storage.ProfileVisibility) *shared.ProfileVisibility {
+	return &shared.ProfileVisibility{
+		Name:        visibilitiesToPb[visibility.Name],
+		Surname:     visibilitiesToPb[visibility.Surname],
+		PhoneNumber: visibilitiesToPb[visibility.PhoneNumber],
+		Birthday:    visibilitiesToPb[visibility.BirthDay],
+		Avatars:     visibilitiesToPb[visibility.Avatar],
+	}
+}
+
+// profileViewer is relation of the viewer to the owner of the profile. Zero value is anonymous viewer.
+type profileViewer struct {
+	owner    bool
+	follower bool
+}
+
+// sees tells whether the viewer may see field of given visibility. Unknown visibility is treated as the strictest.
+func (v profileViewer) sees(visibility storage.Visibility) bool {
+	switch visibility {
+	case storage.VisibilityPublic:
+		return true
+	case storage.VisibilityFollowers:
+		return v.owner || v.follower
+	default:
+		return v.owner
+	}
+}
+
+// parseViewerId returns nil for anonymous viewer.
+func parseViewerId(id *shared.Id) (*uuid.UUID, error) {
+	if id == nil {
+		return nil, nil
+	}
+	viewerId, err := uuid.Parse(id.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	return &viewerId, nil
+}
+
+// resolveProfileViewer finds out relation of the viewer to the owner of the profile, nil viewerId
+// stands for anonymous viewer. Followers are looked up only if some field depends on it.
+func (s UserService) resolveProfileViewer(ctx context.Context, profile *storage.Profile, viewerId *uuid.UUID) (profileViewer, error) {
+	if viewerId == nil {
+		return profileViewer{}, nil
+	}
+	if *viewerId == profile.UserId {
+		return profileViewer{owner: true}, nil
+	}
+
+	visibility := profile.Visibility
+	fields := []storage.Visibility{visibility.Name, visibility.Surname, visibility.PhoneNumber, visibility.BirthDay, visibility.Avatar}
+	for _, field := range fields {
+		if field == storage.VisibilityFollowers {
+			follower, err := s.followers.IsFollower(ctx, profile.UserId, *viewerId)
+			return profileViewer{follower: follower}, err
+		}
+	}
+	return profileViewer{}, nil
+}
+
+// profileToPb converts profile leaving empty the fields the viewer may not see.
+func (s UserService) profileToPb(profile *storage.Profile, viewer profileViewer) *shared.Profile {
+	result := &shared.Profile{
+		CreationTime:   timestamppb.New(*profile.CreationTime),
+		LastUpdateTime: timestamppb.New(*profile.LastUpdateTime),
+	}
+
+	visibility := profile.Visibility
+	if viewer.sees(visibility.Name) {
+		result.Name = profile.Name
+	}
+	if viewer.sees(visibility.Surname) {
+		result.Surname = profile.Surname
+	}
+	if viewer.sees(visibility.PhoneNumber) {
+		result.PhoneNumber = profile.PhoneNumber
+	}
+	if viewer.sees(visibility.BirthDay) && profile.BirthDay != nil {
+		year, month, day := profile.BirthDay.Date()
+		result.Birthday = &shared.Date{Year: int32(year), Month: int32(month), Day: int32(day)}
+	}
+	if viewer.sees(visibility.Avatar) {
+		result.Avatars = s.avatarsToPb(profile.UserId, profile.AvatarId)
+	}
+	if viewer.owner {
+		result.Visibility = profileVisibilityToPb(visibility)
+	}
+
+	return result
+}